@@ -0,0 +1,139 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// AggregateConfig specifies measurements to summarize rather than send raw
+type AggregateConfig struct {
+	Name   string `gcfg:"name"`   // measurement names to aggregate
+	Window int    `gcfg:"window"` // seconds to aggregate over
+}
+
+// summary accumulates values for a series
+type summary struct {
+	name  string
+	tags  map[string]string
+	min   float64
+	max   float64
+	sum   float64
+	last  float64
+	count int
+}
+
+func (s *summary) add(v float64) {
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+	if s.count == 0 || v > s.max {
+		s.max = v
+	}
+	s.sum += v
+	s.last = v
+	s.count++
+}
+
+func (s *summary) fields() map[string]interface{} {
+	return map[string]interface{}{
+		"min":   s.min,
+		"max":   s.max,
+		"mean":  s.sum / float64(s.count),
+		"last":  s.last,
+		"count": s.count,
+	}
+}
+
+// aggregator summarizes series for a window
+type aggregator struct {
+	sync.Mutex
+	window time.Duration
+	names  map[string]bool
+	series map[string]*summary
+	sends  map[string]SendFunc
+}
+
+var (
+	aggOnce     sync.Once
+	aggregators []*aggregator
+)
+
+// startAggregators creates the configured aggregators
+func startAggregators() {
+	for _, c := range cfg.Aggregate {
+		window := c.Window
+		if window <= 0 {
+			window = DefaultFlush
+		}
+		a := &aggregator{
+			window: time.Duration(window) * time.Second,
+			names:  make(map[string]bool),
+			series: make(map[string]*summary),
+			sends:  make(map[string]SendFunc),
+		}
+		for _, name := range strings.Fields(c.Name) {
+			a.names[name] = true
+		}
+		aggregators = append(aggregators, a)
+		go a.run()
+	}
+}
+
+func (a *aggregator) add(send SendFunc, name string, tags map[string]string, v float64) {
+	key := seriesKey(name, tags)
+	a.Lock()
+	s, ok := a.series[key]
+	if !ok {
+		s = &summary{name: name, tags: tags}
+		a.series[key] = s
+		a.sends[key] = send
+	}
+	s.add(v)
+	a.Unlock()
+}
+
+// run sends the summaries at the end of each window
+func (a *aggregator) run() {
+	for {
+		now := time.Now()
+		end := now.Truncate(a.window).Add(a.window)
+		time.Sleep(end.Sub(now))
+		a.Lock()
+		series, sends := a.series, a.sends
+		a.series = make(map[string]*summary)
+		a.sends = make(map[string]SendFunc)
+		a.Unlock()
+		for key, s := range series {
+			if err := sends[key](s.name, s.tags, s.fields(), end); err != nil {
+				errFn(err)
+			}
+		}
+	}
+}
+
+// AggregateSender summarizes values of the configured measurements
+// over their window instead of sending every sample
+func AggregateSender(sender snmp.Sender, send SendFunc) snmp.Sender {
+	if len(cfg.Aggregate) == 0 {
+		return sender
+	}
+	aggOnce.Do(startAggregators)
+	return func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+		for _, a := range aggregators {
+			if !a.names[name] {
+				continue
+			}
+			v, ok := toFloat(value)
+			if !ok || math.IsNaN(v) {
+				break
+			}
+			a.add(send, name, tags, v)
+			return nil
+		}
+		return sender(name, tags, value, ts)
+	}
+}
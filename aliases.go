@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// aliasTag is the tag set from the alias file
+const aliasTag = "alias"
+
+// aliasMap maps host and index to a friendly name
+type aliasMap map[string]map[string]string
+
+var (
+	aliasLock sync.RWMutex
+	aliases   aliasMap
+)
+
+// readAliases loads a json file of {host: {index: name}}
+// or a csv file of host,index,name
+func readAliases(filename string) (aliasMap, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := make(aliasMap)
+	if filepath.Ext(filename) == ".json" {
+		err := json.NewDecoder(f).Decode(&m)
+		return m, err
+	}
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) != 3 {
+			return nil, fmt.Errorf("invalid alias record: %v", rec)
+		}
+		host, index, name := rec[0], rec[1], rec[2]
+		if _, ok := m[host]; !ok {
+			m[host] = make(map[string]string)
+		}
+		m[host][index] = name
+	}
+	return m, nil
+}
+
+// loadAliases replaces the current aliases with the file contents
+func loadAliases(filename string) {
+	m, err := readAliases(filename)
+	if err != nil {
+		log.Printf("error loading aliases from %s: %s\n", filename, err)
+		return
+	}
+	aliasLock.Lock()
+	aliases = m
+	aliasLock.Unlock()
+}
+
+// aliasRefresher reloads the alias file periodically
+func aliasRefresher(filename string, refresh int) {
+	for {
+		time.Sleep(time.Duration(refresh) * time.Second)
+		loadAliases(filename)
+	}
+}
+
+// lookupAlias returns the name for the host's table index
+func lookupAlias(host, index string) (string, bool) {
+	aliasLock.RLock()
+	defer aliasLock.RUnlock()
+	name, ok := aliases[host][index]
+	return name, ok
+}
+
+// AliasSender tags rows with names from the alias file
+func AliasSender(sender snmp.Sender, host string) snmp.Sender {
+	if len(cfg.Common.AliasFile) == 0 {
+		return sender
+	}
+	return func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+		if alias, ok := lookupAlias(host, tags[indexTag]); ok {
+			tags = withTag(tags, aliasTag, alias)
+		}
+		return sender(name, tags, value, ts)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// asyncBuffer is how many values can wait to be processed
+const asyncBuffer = 4096
+
+// asyncItem is a polled value, or the end of a walk if done is set
+type asyncItem struct {
+	name  string
+	tags  map[string]string
+	value interface{}
+	ts    snmp.TimeStamp
+	done  bool
+	err   error
+}
+
+// asyncSender processes values in the background so the poller can send
+// its next request while the values of the last response are handled.
+// The requests themselves are not pipelined, snmputil sends each one
+// after the response before. The end of each walk is passed on in order
+// after its values, with the first error processing them if the walk
+// itself succeeded. It stops when stop is closed
+func asyncSender(sender snmp.Sender, errFn snmp.ErrFunc, stop chan struct{}) (snmp.Sender, snmp.ErrFunc) {
+	items := make(chan asyncItem, asyncBuffer)
+	go func() {
+		var failed error
+		for {
+			var item asyncItem
+			select {
+			case item = <-items:
+			case <-stop:
+				return
+			}
+			if item.done {
+				if item.err == nil {
+					item.err = failed
+				}
+				failed = nil
+				errFn(item.err)
+				continue
+			}
+			if err := sender(item.name, item.tags, item.value, item.ts); err != nil && failed == nil {
+				failed = fmt.Errorf("error processing %s: %s", item.name, err)
+			}
+		}
+	}()
+	enqueue := func(item asyncItem) {
+		select {
+		case items <- item:
+		case <-stop:
+		}
+	}
+	queue := func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+		// the poller may reuse the tags once this returns
+		t := make(map[string]string, len(tags))
+		for k, v := range tags {
+			t[k] = v
+		}
+		enqueue(asyncItem{name: name, tags: t, value: value, ts: ts})
+		return nil
+	}
+	done := func(err error) {
+		enqueue(asyncItem{done: true, err: err})
+	}
+	return queue, done
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+func TestAsyncSender(t *testing.T) {
+	results := make(chan error, 2)
+	failing := func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+		if name == "bad" {
+			return errors.New("no such field")
+		}
+		return nil
+	}
+	stop := make(chan struct{})
+	sender, errFn := asyncSender(failing, func(err error) { results <- err }, stop)
+	sender("bad", nil, 1, snmp.TimeStamp{})
+	errFn(nil)
+	sender("good", nil, 1, snmp.TimeStamp{})
+	errFn(nil)
+	for i, failed := range []bool{true, false} {
+		select {
+		case err := <-results:
+			if (err != nil) != failed {
+				t.Errorf("walk %d: got %v, want an error %t", i, err, failed)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("walk %d: no result", i)
+		}
+	}
+	close(stop)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < asyncBuffer+1; i++ {
+			sender("good", nil, 1, snmp.TimeStamp{})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sending blocked after the walk was stopped")
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"runtime"
+	"sync"
+	"time"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// benchResult records the polling performance for a host
+type benchResult struct {
+	Host     string
+	Rounds   int
+	Values   int
+	Errors   int
+	Elapsed  time.Duration
+	Walks    time.Duration // total walk time, per the reported timestamps
+	Mallocs  uint64
+	TotalMem uint64
+}
+
+// benchHost polls the host's criteria for the given number of rounds,
+// discarding the results
+func benchHost(p snmp.Profile, c *SnmpConfig, crits []snmp.Criteria, rounds int) benchResult {
+	r := benchResult{Host: p.Host, Rounds: rounds}
+	var m sync.Mutex
+	var last snmp.TimeStamp
+	sender := func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+		m.Lock()
+		r.Values++
+		if ts != last {
+			r.Walks += ts.Stop.Sub(ts.Start)
+			last = ts
+		}
+		m.Unlock()
+		return nil
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i := 0; i < rounds; i++ {
+		for _, crit := range crits {
+			if err := sampleAgent(p, c, crit, snmp.IntegerSender(sender)); err != nil {
+				r.Errors++
+				logger.Printf("bench error %s: %s\n", p.Host, err)
+			}
+		}
+	}
+	r.Elapsed = time.Since(start)
+	runtime.ReadMemStats(&after)
+	r.Mallocs = after.Mallocs - before.Mallocs
+	r.TotalMem = after.TotalAlloc - before.TotalAlloc
+	return r
+}
+
+// bencher polls every host without saving data, reporting throughput.
+// Hosts are polled in turn so allocations can be attributed to each
+func bencher(agents []snmpInfo, rounds int, w io.Writer) {
+	fmt.Fprintf(w, "%-24s %6s %8s %6s %10s %10s %12s %10s %12s\n",
+		"HOST", "ROUNDS", "VALUES", "ERRORS", "ELAPSED", "VALUES/S", "AVG WALK", "MALLOCS", "BYTES")
+	for _, a := range agents {
+		crits := criteria(a.Config, a.MIB)
+		for _, profile := range a.Config.profiles() {
+			r := benchHost(profile, a.Config, crits, rounds)
+			rate := float64(r.Values) / r.Elapsed.Seconds()
+			var walk time.Duration
+			if walks := r.Rounds * len(crits); walks > 0 {
+				walk = r.Walks / time.Duration(walks)
+			}
+			fmt.Fprintf(w, "%-24s %6d %8d %6d %10s %10.1f %12s %10d %12d\n",
+				r.Host, r.Rounds, r.Values, r.Errors, r.Elapsed.Round(time.Millisecond), rate,
+				walk.Round(time.Microsecond), r.Mallocs, r.TotalMem)
+			if r.Values == 0 {
+				log.Printf("no values received from %s\n", r.Host)
+			}
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	snmp "github.com/paulstuart/snmputil"
+	"github.com/soniah/gosnmp"
+)
+
+// defaultRepetitions is how many values each GETBULK asks for
+const defaultRepetitions = 50
+
+// oidNames maps the oids in the loaded mibs back to their names
+var oidNames map[string]string
+
+// setMibOIDs sets the names in the loaded mibs and their oids
+func setMibOIDs(names map[string]string) {
+	mibOIDs = names
+	oidNames = make(map[string]string, len(names))
+	for name, o := range names {
+		oidNames[strings.Trim(o, ".")] = name
+	}
+}
+
+// gosnmpClient returns a client for the agent of the profile, with its version and security
+func gosnmpClient(p snmp.Profile, c *SnmpConfig) *gosnmp.GoSNMP {
+	g := &gosnmp.GoSNMP{
+		Target:    p.Host,
+		Port:      161,
+		Community: p.Community,
+		Version:   gosnmp.Version2c,
+		Timeout:   time.Duration(p.Timeout) * time.Second,
+		Retries:   p.Retries,
+	}
+	if p.Port > 0 {
+		g.Port = uint16(p.Port)
+	}
+	switch p.Version {
+	case "1":
+		g.Version = gosnmp.Version1
+	case "3":
+		usm(g, p, c.Context)
+	}
+	if g.Timeout <= 0 {
+		g.Timeout = 5 * time.Second
+	}
+	return g
+}
+
+// rootOID returns the numeric oid the criteria walks
+func rootOID(crit snmp.Criteria) (string, error) {
+	oid := crit.OID
+	if !numeric(oid) {
+		o, ok := mibOIDs[oid]
+		if !ok {
+			return "", fmt.Errorf("unknown oid: %s", oid)
+		}
+		oid = o
+	}
+	oid = "." + strings.Trim(oid, ".")
+	if len(crit.Index) > 0 {
+		oid += "." + strings.Trim(crit.Index, ".")
+	}
+	return oid, nil
+}
+
+// oidName returns the name of the column the oid is in, and the index of its row
+func oidName(oid string) (string, string) {
+	subs := strings.Split(strings.Trim(oid, "."), ".")
+	for i := len(subs); i > 0; i-- {
+		if name, ok := oidNames[strings.Join(subs[:i], ".")]; ok {
+			return name, strings.Join(subs[i:], ".")
+		}
+	}
+	return strings.Trim(oid, "."), ""
+}
+
+// pduValue returns the value of the pdu as the SNMP library sends it
+func pduValue(pdu gosnmp.SnmpPDU) interface{} {
+	if b, ok := pdu.Value.([]byte); ok {
+		return string(b)
+	}
+	return pdu.Value
+}
+
+// walkPDUs walks the oid a GETBULK at a time, passing each value to fn
+func walkPDUs(g *gosnmp.GoSNMP, root string, reps uint32, fn func(gosnmp.SnmpPDU)) error {
+	oid := root
+	for first := true; ; first = false {
+		resp, err := g.GetBulk([]string{oid}, 0, reps)
+		if err != nil {
+			return err
+		}
+		if resp.Error == gosnmp.TooBig {
+			return fmt.Errorf("walk of %s: tooBig with %d repetitions", root, reps)
+		}
+		if resp.Error != gosnmp.NoError {
+			return fmt.Errorf("walk of %s refused by agent: %v", root, resp.Error)
+		}
+		if len(resp.Variables) == 0 {
+			return nil
+		}
+		for i, pdu := range resp.Variables {
+			switch pdu.Type {
+			case gosnmp.EndOfMibView, gosnmp.NoSuchObject, gosnmp.NoSuchInstance:
+				return nil
+			}
+			if !strings.HasPrefix(pdu.Name, root+".") {
+				if first && i == 0 {
+					// the oid is an instance rather than a column or table
+					return getPDU(g, root, fn)
+				}
+				return nil
+			}
+			if !oidLess(strings.Trim(oid, "."), strings.Trim(pdu.Name, ".")) {
+				return fmt.Errorf("walk of %s: oids not increasing at %s", root, pdu.Name)
+			}
+			fn(pdu)
+			oid = pdu.Name
+		}
+	}
+}
+
+// getPDU gets a single oid, passing its value to fn if the agent has it
+func getPDU(g *gosnmp.GoSNMP, oid string, fn func(gosnmp.SnmpPDU)) error {
+	resp, err := g.Get([]string{oid})
+	if err != nil {
+		return err
+	}
+	if resp.Error != gosnmp.NoError {
+		return fmt.Errorf("get of %s refused by agent: %v", oid, resp.Error)
+	}
+	for _, pdu := range resp.Variables {
+		switch pdu.Type {
+		case gosnmp.NoSuchObject, gosnmp.NoSuchInstance:
+			continue
+		}
+		fn(pdu)
+	}
+	return nil
+}
+
+// columnFilter returns whether to keep a column, by the regexps of the criteria
+func columnFilter(crit snmp.Criteria) (func(string) bool, error) {
+	if len(crit.Regexps) == 0 {
+		return func(string) bool { return true }, nil
+	}
+	re, err := regexp.Compile(strings.Join(crit.Regexps, "|"))
+	if err != nil {
+		return nil, err
+	}
+	return func(name string) bool {
+		return re.MatchString(name) == crit.Keep
+	}, nil
+}
+
+// nativeWalk walks the criteria with gosnmp rather than the SNMP library, sending
+// the values with the same names and tags once the walk is complete
+func nativeWalk(p snmp.Profile, c *SnmpConfig, crit snmp.Criteria, sender snmp.Sender, reps uint32) error {
+	root, err := rootOID(crit)
+	if err != nil {
+		return err
+	}
+	keep, err := columnFilter(crit)
+	if err != nil {
+		return err
+	}
+	g := gosnmpClient(p, c)
+	if err := g.Connect(); err != nil {
+		return err
+	}
+	defer g.Conn.Close()
+	var pdus []gosnmp.SnmpPDU
+	start := time.Now()
+	if err := walkPDUs(g, root, reps, func(pdu gosnmp.SnmpPDU) { pdus = append(pdus, pdu) }); err != nil {
+		return err
+	}
+	ts := snmp.TimeStamp{Start: start, Stop: time.Now()}
+	for _, pdu := range pdus {
+		name, index := oidName(pdu.Name)
+		if !keep(name) {
+			continue
+		}
+		if renamed, ok := crit.Rename[name]; ok {
+			name = renamed
+		}
+		tags := make(map[string]string, len(crit.Tags)+2)
+		for k, v := range crit.Tags {
+			tags[k] = v
+		}
+		tags["host"] = p.Host
+		if len(index) > 0 && index != "0" {
+			tags[indexTag] = index
+		}
+		if err := sender(name, tags, pduValue(pdu), ts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
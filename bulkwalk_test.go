@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+func TestOIDName(t *testing.T) {
+	setMibOIDs(map[string]string{
+		"ifXTable":     ".1.3.6.1.2.1.31.1.1",
+		"ifXEntry":     ".1.3.6.1.2.1.31.1.1.1",
+		"ifName":       ".1.3.6.1.2.1.31.1.1.1.1",
+		"ifHCInOctets": ".1.3.6.1.2.1.31.1.1.1.6",
+		"sysUpTime":    ".1.3.6.1.2.1.1.3",
+	})
+	tests := []struct {
+		oid, name, index string
+	}{
+		{".1.3.6.1.2.1.31.1.1.1.6.3", "ifHCInOctets", "3"},
+		{".1.3.6.1.2.1.31.1.1.1.1.1001", "ifName", "1001"},
+		{".1.3.6.1.2.1.1.3.0", "sysUpTime", "0"},
+		{".1.3.6.1.2.1.31.1.1.1.18.3", "ifXEntry", "18.3"},
+		{".1.3.6.1.4.1.9.9.1", "1.3.6.1.4.1.9.9.1", ""},
+	}
+	for _, tt := range tests {
+		if name, index := oidName(tt.oid); name != tt.name || index != tt.index {
+			t.Errorf("%s: got %s %q, want %s %q", tt.oid, name, index, tt.name, tt.index)
+		}
+	}
+	roots := []struct {
+		crit snmp.Criteria
+		root string
+		fail bool
+	}{
+		{crit: snmp.Criteria{OID: "ifXEntry"}, root: ".1.3.6.1.2.1.31.1.1.1"},
+		{crit: snmp.Criteria{OID: "ifName", Index: "3"}, root: ".1.3.6.1.2.1.31.1.1.1.1.3"},
+		{crit: snmp.Criteria{OID: "1.3.6.1.4.1.9.9.1."}, root: ".1.3.6.1.4.1.9.9.1"},
+		{crit: snmp.Criteria{OID: "ifMissing"}, fail: true},
+	}
+	for _, tt := range roots {
+		root, err := rootOID(tt.crit)
+		if tt.fail != (err != nil) || root != tt.root {
+			t.Errorf("%s: got %s (%v), want %s", tt.crit.OID, root, err, tt.root)
+		}
+	}
+}
+
+func TestColumnFilter(t *testing.T) {
+	tests := []struct {
+		crit snmp.Criteria
+		name string
+		want bool
+	}{
+		{snmp.Criteria{}, "ifName", true},
+		{snmp.Criteria{Regexps: []string{"ifHC.*"}, Keep: true}, "ifHCInOctets", true},
+		{snmp.Criteria{Regexps: []string{"ifHC.*"}, Keep: true}, "ifName", false},
+		{snmp.Criteria{Regexps: []string{"ifHC.*"}}, "ifHCInOctets", false},
+		{snmp.Criteria{Regexps: []string{"ifHC.*", "ifName"}}, "ifAlias", true},
+	}
+	for _, tt := range tests {
+		keep, err := columnFilter(tt.crit)
+		if err != nil {
+			t.Errorf("%v: %v", tt.crit.Regexps, err)
+		} else if got := keep(tt.name); got != tt.want {
+			t.Errorf("%v keep=%t %s: got %t, want %t", tt.crit.Regexps, tt.crit.Keep, tt.name, got, tt.want)
+		}
+	}
+}
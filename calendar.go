@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCalendar limits the seconds shown in the polling calendar
+const maxCalendar = 3600
+
+// calendarWalk is when a walk is polled: every Freq seconds,
+// Offset seconds into the interval
+type calendarWalk struct {
+	Agent  string `json:"agent"`
+	Host   string `json:"host"`
+	OID    string `json:"oid"`
+	Freq   int    `json:"freq"`
+	Offset int    `json:"offset"`
+}
+
+// calendarSlot is a second of the polling calendar
+type calendarSlot struct {
+	Second int
+	Count  int
+	Level  int // 0 to 4, relative to the busiest second
+	Walks  string
+}
+
+// calendarReport shows how the polls are spread over the polling interval
+type calendarReport struct {
+	Station  string           `json:"station,omitempty"`
+	Interval int              `json:"interval"` // seconds, the longest polling frequency
+	Peak     int              `json:"peak"`     // most walks polled in the same second
+	Walks    []calendarWalk   `json:"walks"`
+	Seconds  []int            `json:"seconds"` // walks polled in each second of the interval
+	Rows     [][]calendarSlot `json:"-"`
+}
+
+// calendar is when each walk started polling
+var calendar = struct {
+	sync.Mutex
+	walks []calendarWalk
+}{}
+
+// scheduled records the walk started polling at the given time, at the interval of its schedule.
+// Its offset is relative to the epoch, so all walks share the same interval
+func scheduled(w tableWalk, start time.Time) {
+	freq := int(cycleFreq(w.info.Config, w.crit, start) / time.Second)
+	if freq < 1 {
+		return
+	}
+	calendar.Lock()
+	calendar.walks = append(calendar.walks, calendarWalk{
+		Agent:  w.info.Name,
+		Host:   w.profile.Host,
+		OID:    w.crit.OID,
+		Freq:   freq,
+		Offset: int(start.Unix() % int64(freq)),
+	})
+	calendar.Unlock()
+}
+
+// unscheduled removes the walks of the agent from the calendar
+func unscheduled(agent string) {
+	calendar.Lock()
+	walks := calendar.walks[:0]
+	for _, w := range calendar.walks {
+		if w.Agent != agent {
+			walks = append(walks, w)
+		}
+	}
+	calendar.walks = walks
+	calendar.Unlock()
+}
+
+// pollingCalendar returns how many walks are polled in each second of the interval
+func pollingCalendar() calendarReport {
+	calendar.Lock()
+	walks := append([]calendarWalk{}, calendar.walks...)
+	calendar.Unlock()
+	sort.Slice(walks, func(i, j int) bool {
+		if walks[i].Offset != walks[j].Offset {
+			return walks[i].Offset < walks[j].Offset
+		}
+		return walks[i].Host+walks[i].OID < walks[j].Host+walks[j].OID
+	})
+
+	r := calendarReport{Station: cfg.Common.Station, Walks: walks}
+	for _, w := range walks {
+		if w.Freq > r.Interval {
+			r.Interval = w.Freq
+		}
+	}
+	if r.Interval > maxCalendar {
+		r.Interval = maxCalendar
+	}
+	r.Seconds = make([]int, r.Interval)
+	names := make([][]string, r.Interval)
+	for _, w := range walks {
+		for s := w.Offset % w.Freq; s < r.Interval; s += w.Freq {
+			r.Seconds[s]++
+			names[s] = append(names[s], w.Host+" "+w.OID)
+		}
+	}
+	for _, n := range r.Seconds {
+		if n > r.Peak {
+			r.Peak = n
+		}
+	}
+
+	var row []calendarSlot
+	for s, n := range r.Seconds {
+		slot := calendarSlot{Second: s, Count: n}
+		if r.Peak > 0 {
+			slot.Level = (4*n + r.Peak - 1) / r.Peak
+		}
+		slot.Walks = fmt.Sprintf("%ds: %d", s, n)
+		if n > 0 {
+			slot.Walks += "\n" + strings.Join(names[s], "\n")
+		}
+		if row = append(row, slot); len(row) == 60 {
+			r.Rows = append(r.Rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		r.Rows = append(r.Rows, row)
+	}
+	return r
+}
+
+// calendarPage shows which walks are polled in each second of the interval,
+// as a heatmap, or as json if requested with ?format=json or an Accept header of application/json
+func calendarPage(w http.ResponseWriter, r *http.Request) {
+	report := pollingCalendar()
+	if r.URL.Query().Get("format") == "json" || r.Header.Get("Accept") == "application/json" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			log.Printf("calendar error:%s\n", err)
+		}
+		return
+	}
+	if err := calendarTmpl.Execute(w, report); err != nil {
+		log.Printf("calendar error:%s\n", err)
+	}
+}
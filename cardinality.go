@@ -0,0 +1,94 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// seriesGuard tracks the distinct series seen per measurement
+type seriesGuard struct {
+	sync.Mutex
+	limit   int
+	drop    bool
+	series  map[string]map[string]struct{}
+	warned  map[string]bool
+	dropped map[string]int
+}
+
+var guard = &seriesGuard{
+	series:  make(map[string]map[string]struct{}),
+	warned:  make(map[string]bool),
+	dropped: make(map[string]int),
+}
+
+// allow records the series and returns false if it should not be sent
+func (g *seriesGuard) allow(name string, tags map[string]string) bool {
+	key := seriesKey(name, tags)
+	g.Lock()
+	defer g.Unlock()
+	s, ok := g.series[name]
+	if !ok {
+		s = make(map[string]struct{})
+		g.series[name] = s
+	}
+	if _, ok := s[key]; ok {
+		return true
+	}
+	if len(s) >= g.limit {
+		if !g.warned[name] {
+			log.Printf("measurement %s exceeds series limit of %d\n", name, g.limit)
+			g.warned[name] = true
+		}
+		if g.drop {
+			g.dropped[name]++
+			return false
+		}
+	}
+	s[key] = struct{}{}
+	return true
+}
+
+// cardinality returns the series count per measurement
+func (g *seriesGuard) cardinality() map[string]int {
+	m := make(map[string]int)
+	g.Lock()
+	for k, v := range g.series {
+		m[k] = len(v)
+	}
+	g.Unlock()
+	return m
+}
+
+// CardinalitySender enforces the series limit per measurement
+func CardinalitySender(sender snmp.Sender) snmp.Sender {
+	if cfg.Common.MaxSeries <= 0 {
+		return sender
+	}
+	guard.Lock()
+	guard.limit = cfg.Common.MaxSeries
+	guard.drop = cfg.Common.DropSeries
+	guard.Unlock()
+	return func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+		if !guard.allow(name, tags) {
+			return nil
+		}
+		return sender(name, tags, value, ts)
+	}
+}
+
+// remove forgets the host's series, so they no longer count toward the limit
+func (g *seriesGuard) remove(host string) {
+	tag := ",host=" + host + ","
+	g.Lock()
+	for _, s := range g.series {
+		for key := range s {
+			if strings.Contains(key+",", tag) {
+				delete(s, key)
+			}
+		}
+	}
+	g.Unlock()
+}
@@ -0,0 +1,69 @@
+package main
+
+import "fmt"
+
+// ClassConfig is a write class, batching points differently from the influx config
+// it is written with, e.g., a realtime class with a short flush interval
+type ClassConfig struct {
+	Flush     int `gcfg:"flush"`     // seconds between writes
+	BatchSize int `gcfg:"batchSize"` // points to batch before writing
+}
+
+// classSenderName returns the name of the sender for the snmp config's class,
+// or of its influx config if it has no class
+func classSenderName(name string) string {
+	s := senderName(name)
+	if c, ok := snmpConfigs()[name]; ok && len(c.Class) > 0 {
+		s += "/" + c.Class
+	}
+	return s
+}
+
+// checkClasses verifies the classes the agents are assigned to are defined
+func checkClasses(agents []snmpInfo) error {
+	if _, ok := cfg.Class[eventsName[1:]]; ok {
+		return fmt.Errorf("class %s is reserved for events", eventsName[1:])
+	}
+	for _, a := range agents {
+		if class := a.Config.Class; len(class) > 0 {
+			if _, ok := cfg.Class[class]; !ok {
+				return fmt.Errorf("snmp config %s: no class named %s", a.Name, class)
+			}
+		}
+	}
+	return nil
+}
+
+// classSenders adds a sender for each class used with an influx config
+func classSenders(s map[string]Sender) error {
+	for name, c := range snmpConfigs() {
+		if len(c.Class) == 0 || c.Disabled {
+			continue
+		}
+		key := classSenderName(name)
+		if _, ok := s[key]; ok {
+			continue
+		}
+		ic, ok := influxFor(name)
+		if !ok {
+			continue
+		}
+		class, ok := cfg.Class[c.Class]
+		if !ok {
+			return fmt.Errorf("snmp config %s: no class named %s", name, c.Class)
+		}
+		flush, batch := class.Flush, class.BatchSize
+		if flush <= 0 {
+			flush = ic.Flush
+		}
+		if batch <= 0 {
+			batch = ic.BatchSize
+		}
+		sender, err := subSender(ic, flush, batch)
+		if err != nil {
+			return fmt.Errorf("influx config %s class %s: %s", senderName(name), c.Class, err)
+		}
+		s[key] = sender
+	}
+	return nil
+}
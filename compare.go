@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// seriesTypes are the field types of each series, by measurement and tags
+type seriesTypes map[string]map[string]string
+
+// sampleTypes returns the field types of the sampled series
+func sampleTypes(points []sampledPoint) seriesTypes {
+	s := make(seriesTypes)
+	for _, pt := range points {
+		key := pt.Name + "," + tagString(pt.Tags)
+		if s[key] == nil {
+			s[key] = make(map[string]string)
+		}
+		for k, t := range pt.Types {
+			s[key][k] = t
+		}
+	}
+	return s
+}
+
+// fieldNames returns the field names in sorted order
+func fieldNames(types map[string]string) []string {
+	names := make([]string, 0, len(types))
+	for k := range types {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// loadSample reads a sample saved with -sample -format json
+func loadSample(filename string) (seriesTypes, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var points []sampledPoint
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var pt sampledPoint
+		if err := json.Unmarshal(scanner.Bytes(), &pt); err != nil {
+			return nil, fmt.Errorf("%s line %d: %s", filename, line, err)
+		}
+		points = append(points, pt)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sampleTypes(points), nil
+}
+
+// compareSamples writes the series that are new, missing, or whose field types
+// have changed since the saved sample, returning how many differ
+func compareSamples(saved, current seriesTypes, w io.Writer) int {
+	keys := make([]string, 0, len(saved)+len(current))
+	for k := range saved {
+		keys = append(keys, k)
+	}
+	for k := range current {
+		if _, ok := saved[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	differ := 0
+	for _, key := range keys {
+		was, ok := saved[key]
+		if !ok {
+			fmt.Fprintf(w, "new:     %s\n", key)
+			differ++
+			continue
+		}
+		now, ok := current[key]
+		if !ok {
+			fmt.Fprintf(w, "missing: %s\n", key)
+			differ++
+			continue
+		}
+		for _, field := range fieldNames(was) {
+			if t, ok := now[field]; !ok {
+				fmt.Fprintf(w, "missing: %s %s\n", key, field)
+				differ++
+			} else if t != was[field] {
+				fmt.Fprintf(w, "type:    %s %s %s -> %s\n", key, field, was[field], t)
+				differ++
+			}
+		}
+		for _, field := range fieldNames(now) {
+			if _, ok := was[field]; !ok {
+				fmt.Fprintf(w, "new:     %s %s\n", key, field)
+				differ++
+			}
+		}
+	}
+	return differ
+}
+
+// sampleCompare samples the agents and compares the result with the saved sample
+func sampleCompare(agents []snmpInfo, filename string, w io.Writer) (int, error) {
+	saved, err := loadSample(filename)
+	if err != nil {
+		return 0, err
+	}
+	differ := compareSamples(saved, sampleTypes(structuredSample(agents)), w)
+	fmt.Fprintf(w, "%d differences from %s\n", differ, filename)
+	return differ, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// jsonSetting returns the value of a setting, or its default if it is not given,
+// with secrets redacted. Unset settings without a default are omitted
+func jsonSetting(section, key string, f reflect.Value) (interface{}, bool) {
+	if reflect.DeepEqual(f.Interface(), reflect.Zero(f.Type()).Interface()) {
+		def, ok := configDefaults[section+"."+key]
+		if !ok {
+			return nil, false
+		}
+		v := reflect.New(f.Type())
+		if _, err := fmt.Sscan(def, v.Interface()); err != nil || f.Kind() == reflect.String {
+			return redact(key, def), true
+		}
+		return v.Elem().Interface(), true
+	}
+	switch f.Kind() {
+	case reflect.String:
+		return redact(key, f.String()), true
+	case reflect.Slice:
+		values := make([]string, f.Len())
+		for i := range values {
+			values[i] = redact(key, fmt.Sprint(f.Index(i).Interface()))
+		}
+		return values, true
+	}
+	return f.Interface(), true
+}
+
+// jsonSection returns the settings of a config section by their config file names
+func jsonSection(section string, v reflect.Value) map[string]interface{} {
+	settings := make(map[string]interface{})
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath != "" {
+			// not part of the config file
+			continue
+		}
+		key := configKey(t.Field(i))
+		if value, ok := jsonSetting(section, key, v.Field(i)); ok {
+			settings[key] = value
+		}
+	}
+	return settings
+}
+
+// configJSON returns the effective configuration, with defaults filled in
+// and secrets redacted. Named sections are keyed by their names
+func configJSON() map[string]interface{} {
+	conf := make(map[string]interface{})
+	v := reflect.ValueOf(cfg)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		section := strings.ToLower(t.Field(i).Name)
+		f := v.Field(i)
+		switch f.Kind() {
+		case reflect.Struct:
+			conf[section] = jsonSection(section, f)
+		case reflect.Map:
+			named := make(map[string]interface{})
+			for _, k := range f.MapKeys() {
+				named[k.String()] = jsonSection(section, f.MapIndex(k).Elem())
+			}
+			conf[section] = named
+		}
+	}
+	return conf
+}
+
+// configAPI serves the running configuration, for comparing with the deployed source
+func configAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(configJSON()); err != nil {
+		log.Printf("config error:%s\n", err)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// conformance modes
+const (
+	conformSanitize = "sanitize" // fix what can be fixed, dropping the rest
+	conformReject   = "reject"   // drop any point that breaks the rules
+)
+
+// maxStringField is the largest string field influxdb accepts
+const maxStringField = 64 * 1024
+
+// conformLogged are the problems already logged, so each is only logged once per measurement
+var conformLogged = struct {
+	sync.Mutex
+	seen map[string]bool
+}{seen: make(map[string]bool)}
+
+// checkConformance verifies the conformance mode is valid
+func checkConformance(mode string) error {
+	switch mode {
+	case "", conformSanitize, conformReject:
+		return nil
+	}
+	return fmt.Errorf("invalid conformance mode: %s", mode)
+}
+
+// conformString fixes a name, key or tag value that line protocol cannot represent:
+// invalid utf-8, line breaks, and a trailing backslash, which would escape the separator.
+// It returns the problem found, if any
+func conformString(s string) (string, string) {
+	problem := ""
+	if !utf8.ValidString(s) {
+		s = strings.ToValidUTF8(s, "?")
+		problem = "invalid utf-8"
+	}
+	if strings.ContainsAny(s, "\r\n") {
+		s = strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+		problem = "line break"
+	}
+	if strings.HasSuffix(s, `\`) {
+		s = strings.TrimRight(s, `\`)
+		problem = "trailing backslash"
+	}
+	return s, problem
+}
+
+// conformKey also renames "time", which influxdb does not allow as a key
+func conformKey(s string) (string, string) {
+	s, problem := conformString(s)
+	if s == "time" {
+		s, problem = "time_", "key named time"
+	}
+	return s, problem
+}
+
+// conformLog logs the problem, the first time it is found in the measurement
+func conformLog(name, what, problem string, dropped bool) {
+	atomic.AddInt64(&invalidCount, 1)
+	key := name + "\x00" + what + "\x00" + problem
+	conformLogged.Lock()
+	seen := conformLogged.seen[key]
+	conformLogged.seen[key] = true
+	conformLogged.Unlock()
+	if seen && !debugging() {
+		return
+	}
+	action := "fixed"
+	if dropped {
+		action = "dropped"
+	}
+	log.Printf("line protocol: %s in %s of measurement %q, %s\n", problem, what, name, action)
+}
+
+// ConformSender checks points follow the line protocol rules before they are queued,
+// rather than their writes failing. In sanitize mode problems are fixed where they can be
+// and the rest dropped, in reject mode the point is dropped. Each problem is logged
+// the first time it is found in a measurement
+func ConformSender(send SendFunc) SendFunc {
+	mode := cfg.Common.Conformance
+	if len(mode) == 0 {
+		return send
+	}
+	return func(name string, tags map[string]string, fields map[string]interface{}, ts time.Time) error {
+		reject := mode == conformReject
+		fixed, problem := conformString(name)
+		if len(fixed) == 0 {
+			problem = "empty name"
+		}
+		if len(problem) > 0 {
+			conformLog(name, "the name", problem, reject || len(fixed) == 0)
+			if reject || len(fixed) == 0 {
+				return nil
+			}
+			name = fixed
+		}
+
+		var ctags map[string]string
+		for k, v := range tags {
+			key, kp := conformKey(k)
+			value, vp := conformString(v)
+			if len(kp) == 0 && len(vp) == 0 && len(key) > 0 && len(value) > 0 {
+				continue
+			}
+			if ctags == nil {
+				ctags = make(map[string]string, len(tags))
+				for k, v := range tags {
+					ctags[k] = v
+				}
+			}
+			what, problem := "tag key "+k, kp
+			if len(problem) == 0 {
+				what, problem = "tag "+k, vp
+			}
+			if len(key) == 0 {
+				problem = "empty key"
+			} else if len(value) == 0 {
+				problem = "empty value"
+			}
+			unfixable := len(key) == 0 || len(value) == 0
+			conformLog(name, what, problem, reject || unfixable)
+			if reject {
+				return nil
+			}
+			delete(ctags, k)
+			if !unfixable {
+				ctags[key] = value
+			}
+		}
+		if ctags != nil {
+			tags = ctags
+		}
+
+		var cfields map[string]interface{}
+		for k, v := range fields {
+			key, problem := conformKey(k)
+			if len(key) == 0 {
+				problem = "empty key"
+			}
+			s, isString := v.(string)
+			if isString && len(problem) == 0 && len(s) > maxStringField {
+				problem = "string longer than 64KB"
+			}
+			if len(problem) == 0 {
+				continue
+			}
+			conformLog(name, "field "+k, problem, reject || len(key) == 0)
+			if reject {
+				return nil
+			}
+			if cfields == nil {
+				cfields = make(map[string]interface{}, len(fields))
+				for k, v := range fields {
+					cfields[k] = v
+				}
+			}
+			delete(cfields, k)
+			if len(key) == 0 {
+				continue
+			}
+			if isString && len(s) > maxStringField {
+				v = strings.ToValidUTF8(s[:maxStringField], "")
+			}
+			cfields[key] = v
+		}
+		if cfields != nil {
+			if len(cfields) == 0 {
+				return nil
+			}
+			fields = cfields
+		}
+		return send(name, tags, fields, ts)
+	}
+}
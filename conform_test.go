@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestConformString(t *testing.T) {
+	tests := []struct {
+		in, want, problem string
+	}{
+		{"GigabitEthernet0/0/1", "GigabitEthernet0/0/1", ""},
+		{"", "", ""},
+		{"uplink\r\nto core", "uplink  to core", "line break"},
+		{"bad\xffbyte", "bad?byte", "invalid utf-8"},
+		{`C:\`, "C:", "trailing backslash"},
+		{`a\\`, "a", "trailing backslash"},
+		{`a\b`, `a\b`, ""},
+	}
+	for _, tt := range tests {
+		got, problem := conformString(tt.in)
+		if got != tt.want || problem != tt.problem {
+			t.Errorf("%q: got %q (%q), want %q (%q)", tt.in, got, problem, tt.want, tt.problem)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// CredentialConfig is a named community, or SNMPv3 passwords,
+// that snmp configs refer to, so they can be changed without restarting
+type CredentialConfig struct {
+	Community string `gcfg:"community"`
+	// File holds the community, and is read again whenever it changes (e.g., a mounted secret)
+	File string `gcfg:"file"`
+	// AuthPassword and PrivPassword replace those of SNMPv3 snmp configs,
+	// and are read again from AuthFile and PrivFile whenever they change
+	AuthPassword string `gcfg:"authPassword"`
+	AuthFile     string `gcfg:"authFile"`
+	PrivPassword string `gcfg:"privPassword"`
+	PrivFile     string `gcfg:"privFile"`
+}
+
+// secret is the current value of part of a credential
+type secret struct {
+	value   string
+	modTime time.Time // of the file it was read from
+}
+
+// credential is the current value of a named credential
+type credential struct {
+	community secret
+	authPass  secret
+	privPass  secret
+}
+
+var credentials = struct {
+	sync.Mutex
+	values map[string]*credential
+}{values: make(map[string]*credential)}
+
+// checkCredentials verifies the credentials the agents refer to are defined
+func checkCredentials(agents []snmpInfo) error {
+	for _, a := range agents {
+		if name := a.Config.Credential; len(name) > 0 {
+			if _, ok := cfg.Credential[name]; !ok {
+				return fmt.Errorf("snmp config %s: no credential named %s", a.Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// refresh reads the secret from its file again if it has changed
+func (s *secret) refresh(name, file string) {
+	if len(file) == 0 {
+		return
+	}
+	if fi, err := os.Stat(file); err == nil && !fi.ModTime().Equal(s.modTime) {
+		if data, err := ioutil.ReadFile(file); err == nil {
+			s.value = strings.TrimSpace(string(data))
+			s.modTime = fi.ModTime()
+			log.Printf("credential %s read from %s\n", name, file)
+		}
+	}
+}
+
+// currentCredential returns the current values of the named credential,
+// reading its files again if they have changed
+func currentCredential(name string) (credential, bool) {
+	c, ok := cfg.Credential[name]
+	if !ok {
+		return credential{}, false
+	}
+	credentials.Lock()
+	defer credentials.Unlock()
+	cred, ok := credentials.values[name]
+	if !ok {
+		cred = &credential{
+			community: secret{value: c.Community},
+			authPass:  secret{value: c.AuthPassword},
+			privPass:  secret{value: c.PrivPassword},
+		}
+		credentials.values[name] = cred
+	}
+	cred.community.refresh(name, c.File)
+	cred.authPass.refresh(name, c.AuthFile)
+	cred.privPass.refresh(name, c.PrivFile)
+	return *cred, true
+}
+
+// withCredential returns the profile with the current community, or SNMPv3
+// passwords, of the credential the walk's snmp config refers to, if it has one
+func withCredential(p snmp.Profile, c *SnmpConfig) snmp.Profile {
+	if len(c.Credential) == 0 {
+		return p
+	}
+	cred, ok := currentCredential(c.Credential)
+	if !ok {
+		return p
+	}
+	if len(cred.community.value) > 0 {
+		p.Community = cred.community.value
+	}
+	if len(cred.authPass.value) > 0 {
+		p.AuthPass = cred.authPass.value
+	}
+	if len(cred.privPass.value) > 0 {
+		p.PrivPass = cred.privPass.value
+	}
+	return p
+}
+
+// credentialAPI sets a credential's community or passwords from a POST to /api/credentials/{name}.
+// It requires the admin token, and is disabled if there is none
+func credentialAPI(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" && r.Method != "PUT" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/api/credentials/")
+	community, auth, priv := r.FormValue("community"), r.FormValue("authPassword"), r.FormValue("privPassword")
+	if _, ok := cfg.Credential[name]; !ok || len(community)+len(auth)+len(priv) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if _, ok := currentCredential(name); ok {
+		credentials.Lock()
+		cred := credentials.values[name]
+		if len(community) > 0 {
+			cred.community.value = community
+		}
+		if len(auth) > 0 {
+			cred.authPass.value = auth
+		}
+		if len(priv) > 0 {
+			cred.privPass.value = priv
+		}
+		credentials.Unlock()
+	}
+	log.Printf("credential %s changed from %s\n", name, r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// cyclePoll samples the walk every polling interval, rather than leaving a
+// poller running, so a changed credential is used from the next cycle,
+// a cycle that fails can be escalated, an OID that keeps failing can be
+// quarantined, the walk can wait for one of the agent's slots,
+// the interval can follow a schedule, and the walk can end when
+// discovery no longer finds the agent
+func cyclePoll(p snmp.Profile, c *SnmpConfig, crit snmp.Criteria, slots, stop chan struct{}, sender snmp.Sender, errFn snmp.ErrFunc) {
+	for n := 0; !stopping() && !stopped(stop) && (crit.Count == 0 || n < crit.Count); n++ {
+		start := time.Now()
+		if !quarantined(p.Host, crit.OID) {
+			err := withSlot(slots, func() error {
+				return supervise("poll of "+p.Host, func() error {
+					return sampleWalk(p, c, crit, sender)
+				})
+			})
+			walked(p.Host, crit.OID, err)
+			errFn(err)
+		}
+		pause(stop, time.Until(start.Add(cycleFreq(c, crit, start))))
+	}
+	quit.Done()
+}
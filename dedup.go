@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// lastValue is the most recently sent value of a series
+type lastValue struct {
+	value interface{}
+	sent  time.Time
+}
+
+// sameValue compares polled values
+func sameValue(a, b interface{}) bool {
+	if x, ok := a.([]byte); ok {
+		y, ok := b.([]byte)
+		return ok && bytes.Equal(x, y)
+	}
+	if _, ok := b.([]byte); ok {
+		return false
+	}
+	return a == b
+}
+
+// DedupSender suppresses values that are unchanged from the last one sent,
+// unless the heartbeat interval has elapsed since it was sent
+func DedupSender(sender snmp.Sender, heartbeat int) snmp.Sender {
+	if heartbeat <= 0 {
+		return sender
+	}
+	interval := time.Duration(heartbeat) * time.Second
+	var m sync.Mutex
+	last := make(map[string]lastValue)
+	return func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+		key := seriesKey(name, tags)
+		m.Lock()
+		prior, ok := last[key]
+		if ok && sameValue(prior.value, value) && ts.Stop.Sub(prior.sent) < interval {
+			m.Unlock()
+			return nil
+		}
+		last[key] = lastValue{value, ts.Stop}
+		m.Unlock()
+		return sender(name, tags, value, ts)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"sync"
+)
+
+// deltaField is the field with the change of a counter since the previous poll
+const deltaField = "delta"
+
+// deltas tracks the last value of the counters of a host that are sent with their deltas
+type deltas struct {
+	sync.Mutex
+	columns map[string]bool
+	last    map[string]uint64
+}
+
+// newDeltas returns the tracker for the columns the agent polls on the host, or nil if there are none
+func newDeltas(host, agent, spec string) *deltas {
+	if len(strings.TrimSpace(spec)) == 0 {
+		return nil
+	}
+	d := &deltas{
+		columns: make(map[string]bool),
+		last:    make(map[string]uint64),
+	}
+	for _, column := range strings.Fields(spec) {
+		d.columns[column] = true
+	}
+	// counters restart from zero when the device reboots
+	onReboot(host, agent, d.reset)
+	addCache(host, d)
+	return d
+}
+
+// counterValue converts a polled counter to uint64
+func counterValue(value interface{}) (uint64, bool) {
+	switch v := value.(type) {
+	case uint:
+		return uint64(v), true
+	case uint32:
+		return uint64(v), true
+	case uint64:
+		return v, true
+	case int:
+		return uint64(v), v >= 0
+	case int64:
+		return uint64(v), v >= 0
+	}
+	return 0, false
+}
+
+// counter32 returns true if the value is a Counter32, as gosnmp returns them as uint
+func counter32(value interface{}) bool {
+	switch value.(type) {
+	case uint, uint32:
+		return true
+	}
+	return false
+}
+
+// delta returns the change of the counter since the previous poll. There is none
+// for the first poll, or when the counter went back other than by the wrap of
+// a Counter32, as it was reset
+func (d *deltas) delta(name string, tags map[string]string, value interface{}) (int64, bool) {
+	if d == nil || !d.columns[name] {
+		return 0, false
+	}
+	v, ok := counterValue(value)
+	if !ok {
+		return 0, false
+	}
+	key := seriesKey(name, tags)
+	d.Lock()
+	prior, seen := d.last[key]
+	d.last[key] = v
+	d.Unlock()
+	switch {
+	case !seen:
+		return 0, false
+	case v >= prior:
+		return int64(v - prior), true
+	case counter32(value) && prior <= math.MaxUint32 && prior > math.MaxUint32/2:
+		return int64(v + math.MaxUint32 + 1 - prior), true
+	}
+	return 0, false
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDelta(t *testing.T) {
+	type poll struct {
+		value interface{}
+		delta int64
+		ok    bool
+	}
+	tests := []struct {
+		name  string
+		polls []poll
+	}{
+		{"first poll", []poll{{uint64(100), 0, false}}},
+		{"increase", []poll{{uint64(100), 0, false}, {uint64(250), 150, true}, {uint64(250), 0, true}}},
+		{"32 bit wrap", []poll{{uint32(math.MaxUint32 - 9), 0, false}, {uint32(5), 15, true}}},
+		{"reset", []poll{{uint64(1000), 0, false}, {uint64(10), 0, false}, {uint64(25), 15, true}}},
+		{"64 bit reset", []poll{{uint64(math.MaxUint32 + 100), 0, false}, {uint64(50), 0, false}}},
+		{"64 bit reset below 2^32", []poll{{uint64(math.MaxUint32 - 9), 0, false}, {uint64(5), 0, false}}},
+		{"uint wrap", []poll{{uint(math.MaxUint32 - 1), 0, false}, {uint(3), 5, true}}},
+		{"small 32 bit reset", []poll{{uint32(1000), 0, false}, {uint32(10), 0, false}}},
+		{"not a counter", []poll{{"up", 0, false}, {-1, 0, false}}},
+	}
+	for _, tt := range tests {
+		d := newDeltas("delta.test", "agent", "ifHCInOctets")
+		tags := map[string]string{"ifName": tt.name}
+		for i, p := range tt.polls {
+			delta, ok := d.delta("ifHCInOctets", tags, p.value)
+			if delta != p.delta || ok != p.ok {
+				t.Errorf("%s: poll %d got %d (%t), want %d (%t)", tt.name, i, delta, ok, p.delta, p.ok)
+			}
+		}
+	}
+}
+
+func TestDeltaColumns(t *testing.T) {
+	if d := newDeltas("delta.test", "agent", " "); d != nil {
+		t.Errorf("expected no deltas without columns")
+	}
+	d := newDeltas("delta.test", "agent", "ifHCInOctets ifHCOutOctets")
+	tags := map[string]string{"ifName": "eth0"}
+	d.delta("ifInErrors", tags, uint64(1))
+	if _, ok := d.delta("ifInErrors", tags, uint64(2)); ok {
+		t.Errorf("expected no delta for a column not listed")
+	}
+	d.delta("ifHCInOctets", tags, uint64(1))
+	d.delta("ifHCOutOctets", tags, uint64(100))
+	if delta, _ := d.delta("ifHCInOctets", tags, uint64(3)); delta != 2 {
+		t.Errorf("columns are tracked apart, got %d want 2", delta)
+	}
+	rebooted("delta.test")
+	if _, ok := d.delta("ifHCInOctets", tags, uint64(4)); ok {
+		t.Errorf("expected no delta after a reboot")
+	}
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultDiscoveryRefresh is how many seconds between checks of a source for changes
+const defaultDiscoveryRefresh = 300
+
+// DiscoveryConfig finds agents to poll with an snmp config
+type DiscoveryConfig struct {
+	// URL is the source: file:///path, dns://name (SRV if it starts with _), or an http(s) url
+	URL string `gcfg:"url"`
+	// Snmp is the snmp config the agents are polled with, unless the source names another
+	Snmp string `gcfg:"snmp"`
+	// Refresh is how many seconds between checks for changes (default 300)
+	Refresh int `gcfg:"refresh"`
+}
+
+// AgentSpec is an agent found by discovery. Each agent is polled with its own
+// copy of the snmp config, which has the community, tags or mibs it was found with
+type AgentSpec struct {
+	Host      string            `json:"host"`
+	Config    string            `json:"config,omitempty"` // snmp config to poll it with, if not the discovery's
+	Community string            `json:"community,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	Mibs      string            `json:"mibs,omitempty"`
+}
+
+// discovered maps the names of the snmp configs created for discovered agents
+// to the snmp configs they were copied from. It and cfg.Snmp are replaced under
+// agentLock rather than changed as agents come and go, and read with snmpConfigs and discoveredFrom
+var discovered = make(map[string]string)
+
+// snmpConfigs returns the snmp configs, including those of discovered agents.
+// The map is replaced rather than changed, so it can be read once it is returned
+func snmpConfigs() map[string]*SnmpConfig {
+	agentLock.Lock()
+	defer agentLock.Unlock()
+	return cfg.Snmp
+}
+
+// mibConfigs returns the mibs configs, which are replaced the same way
+func mibConfigs() map[string]*MibConfig {
+	agentLock.Lock()
+	defer agentLock.Unlock()
+	return cfg.Mibs
+}
+
+// discoveredFrom returns the snmp config a discovered agent's was copied from
+func discoveredFrom(name string) (string, bool) {
+	agentLock.Lock()
+	defer agentLock.Unlock()
+	from, ok := discovered[name]
+	return from, ok
+}
+
+// agentSnmp is the snmp config of a discovered agent, and the name of the one it was copied from
+type agentSnmp struct {
+	from   string
+	config *SnmpConfig
+}
+
+var (
+	agentLock sync.Mutex
+	found     = make(map[string]map[string]agentSnmp) // discovery name to the snmp configs of the agents it found
+)
+
+// DiscoverySource provides a list of agents, and notices when it changes
+type DiscoverySource interface {
+	// List returns the current agents
+	List() ([]AgentSpec, error)
+	// Watch sends the agents on the channel each time they change
+	Watch(ch chan<- []AgentSpec)
+}
+
+// DiscoveryFactory creates a discovery source from its config
+type DiscoveryFactory func(*DiscoveryConfig) (DiscoverySource, error)
+
+var (
+	discoveryLock sync.Mutex
+	discoveries   = make(map[string]DiscoveryFactory)
+)
+
+// RegisterDiscovery makes a discovery source available for urls with the given scheme
+func RegisterDiscovery(scheme string, factory DiscoveryFactory) {
+	discoveryLock.Lock()
+	discoveries[scheme] = factory
+	discoveryLock.Unlock()
+}
+
+func init() {
+	RegisterDiscovery("file", newPolledSource)
+	RegisterDiscovery("dns", newPolledSource)
+	RegisterDiscovery("http", newPolledSource)
+	RegisterDiscovery("https", newPolledSource)
+}
+
+// discoverySource creates the source for the config, by the scheme of its url
+func discoverySource(c *DiscoveryConfig) (DiscoverySource, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, err
+	}
+	discoveryLock.Lock()
+	factory, ok := discoveries[u.Scheme]
+	discoveryLock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no discovery source for %s urls", u.Scheme)
+	}
+	return factory(c)
+}
+
+// polledSource is a source that is read again every refresh interval to see if it changed
+type polledSource struct {
+	read    func() ([]AgentSpec, error)
+	refresh time.Duration
+}
+
+// newPolledSource creates a source for a file, dns name or http url
+func newPolledSource(c *DiscoveryConfig) (DiscoverySource, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, err
+	}
+	refresh := c.Refresh
+	if refresh <= 0 {
+		refresh = defaultDiscoveryRefresh
+	}
+	s := &polledSource{refresh: time.Duration(refresh) * time.Second}
+	switch u.Scheme {
+	case "file":
+		s.read = func() ([]AgentSpec, error) { return fileAgents(u.Path) }
+	case "dns":
+		s.read = func() ([]AgentSpec, error) { return dnsAgents(u.Host) }
+	default:
+		s.read = func() ([]AgentSpec, error) { return httpAgents(c.URL) }
+	}
+	return s, nil
+}
+
+// List returns the agents currently in the source, sorted so lists can be compared
+func (s *polledSource) List() ([]AgentSpec, error) {
+	list, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Host < list[j].Host })
+	return list, nil
+}
+
+// Watch reads the source every refresh interval, sending the agents when they change
+func (s *polledSource) Watch(ch chan<- []AgentSpec) {
+	last, _ := s.List()
+	for {
+		time.Sleep(s.refresh)
+		list, err := s.List()
+		if err != nil {
+			log.Printf("discovery error: %s\n", err)
+			continue
+		}
+		if !reflect.DeepEqual(list, last) {
+			last = list
+			ch <- list
+		}
+	}
+}
+
+// parseAgents reads a json list of agents, or a host per line
+func parseAgents(data []byte) ([]AgentSpec, error) {
+	var list []AgentSpec
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err := json.Unmarshal(trimmed, &list)
+		return list, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		list = append(list, AgentSpec{Host: line})
+	}
+	return list, scanner.Err()
+}
+
+// fileAgents reads the agents from a file
+func fileAgents(path string) ([]AgentSpec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseAgents(data)
+}
+
+// dnsAgents looks up the agents as the targets of SRV records, or the addresses of a name
+func dnsAgents(name string) ([]AgentSpec, error) {
+	var list []AgentSpec
+	if strings.HasPrefix(name, "_") {
+		_, srvs, err := net.LookupSRV("", "", name)
+		if err != nil {
+			return nil, err
+		}
+		for _, srv := range srvs {
+			list = append(list, AgentSpec{Host: strings.TrimSuffix(srv.Target, ".")})
+		}
+		return list, nil
+	}
+	addrs, err := net.LookupHost(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		list = append(list, AgentSpec{Host: addr})
+	}
+	return list, nil
+}
+
+// httpAgents gets the agents from a url
+func httpAgents(u string) ([]AgentSpec, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return parseAgents(data)
+}
+
+// agentConfigs returns the snmp configs for the agents, by name
+func agentConfigs(name string, c *DiscoveryConfig, list []AgentSpec) map[string]agentSnmp {
+	configs := make(map[string]agentSnmp, len(list))
+	for _, a := range list {
+		config := a.Config
+		if len(config) == 0 {
+			config = c.Snmp
+		}
+		sc, ok := snmpConfigs()[config]
+		if _, own := discoveredFrom(config); !ok || own {
+			log.Printf("discovery %s: no snmp config named %s for %s\n", name, config, a.Host)
+			continue
+		}
+		configs[config+"/"+a.Host] = agentSnmp{config, agentConfig(sc, a)}
+	}
+	return configs
+}
+
+// agentConfig returns a copy of the snmp config for the agent, with its own settings
+func agentConfig(sc *SnmpConfig, a AgentSpec) *SnmpConfig {
+	c := *sc
+	c.Host = a.Host
+	if len(a.Community) > 0 {
+		c.Community = a.Community
+		c.Credential = ""
+	}
+	if len(a.Mibs) > 0 {
+		c.Mibs = a.Mibs
+	}
+	c.tags = a.Tags
+	return &c
+}
+
+// reconcile replaces the agents the source found before with the ones it found now,
+// returning the names of the snmp configs added and removed. An agent found
+// with different settings is both, so its walks are restarted with them
+func reconcile(name string, configs map[string]agentSnmp) (added, removed []string) {
+	agentLock.Lock()
+	defer agentLock.Unlock()
+	snmps := make(map[string]*SnmpConfig, len(cfg.Snmp)+len(configs))
+	for k, c := range cfg.Snmp {
+		snmps[k] = c
+	}
+	links := make(map[string]string, len(discovered)+len(configs))
+	for k, from := range discovered {
+		links[k] = from
+	}
+	prior := found[name]
+	for k, p := range prior {
+		if a, ok := configs[k]; !ok || !reflect.DeepEqual(a.config, p.config) {
+			delete(snmps, k)
+			delete(links, k)
+			removed = append(removed, k)
+		}
+	}
+	current := make(map[string]agentSnmp, len(configs))
+	for k, a := range configs {
+		if p, ok := prior[k]; ok && reflect.DeepEqual(a.config, p.config) {
+			current[k] = p
+			continue
+		}
+		if _, ok := snmps[k]; ok {
+			log.Printf("discovery %s: %s was already found, ignoring it\n", name, k)
+			continue
+		}
+		snmps[k] = a.config
+		links[k] = a.from
+		current[k] = a
+		added = append(added, k)
+	}
+	cfg.Snmp = snmps
+	discovered = links
+	found[name] = current
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// discover adds the agents found by each discovery source to the config,
+// and returns the sources so they can be watched for changes. A source that
+// can't be read is logged, and the agents it last found are used until it can be
+func discover() (map[string]DiscoverySource, error) {
+	sources := make(map[string]DiscoverySource)
+	for name, c := range cfg.Discovery {
+		if _, ok := snmpConfigs()[c.Snmp]; !ok {
+			return nil, fmt.Errorf("discovery %s: no snmp config named %s", name, c.Snmp)
+		}
+		src, err := discoverySource(c)
+		if err != nil {
+			return nil, fmt.Errorf("discovery %s: %s", name, err)
+		}
+		sources[name] = src
+		list, err := src.List()
+		if err != nil {
+			saved, serr := savedAgents(name)
+			if serr != nil {
+				log.Printf("discovery %s: %s, polling the static hosts until it can be read\n", name, err)
+				continue
+			}
+			log.Printf("discovery %s: %s, polling the %d agents it last found\n", name, err, len(saved))
+			list = saved
+		} else {
+			saveAgents(name, list)
+		}
+		added, _ := reconcile(name, agentConfigs(name, c, list))
+		log.Printf("discovery %s found %d agents\n", name, len(added))
+	}
+	return sources, nil
+}
+
+// watchDiscovery watches each source, starting the walks of the agents
+// it adds and stopping those of the agents it removes
+func watchDiscovery(sources map[string]DiscoverySource) {
+	for name, src := range sources {
+		ch := make(chan []AgentSpec)
+		go src.Watch(ch)
+		go func(name string, ch chan []AgentSpec) {
+			for list := range ch {
+				saveAgents(name, list)
+				added, removed := reconcile(name, agentConfigs(name, cfg.Discovery[name], list))
+				for _, agent := range removed {
+					retireAgent(agent)
+				}
+				startAgents(discoveredAgents(name, added))
+				log.Printf("discovery %s: %d agents added, %d removed\n", name, len(added), len(removed))
+			}
+		}(name, ch)
+	}
+}
+
+// discoveredAgents returns the agents of the snmp configs,
+// leaving out any with settings that can't be used
+func discoveredAgents(source string, configs []string) []snmpInfo {
+	names, err := mibNames()
+	if err != nil {
+		log.Printf("discovery %s: cannot read mib names: %s\n", source, err)
+	}
+	var list []snmpInfo
+	for _, name := range configs {
+		agents, err := configAgents(name, snmpConfigs()[name])
+		if err == nil {
+			err = checkDiscovered(agents, names)
+		}
+		if err != nil {
+			log.Printf("discovery %s: not polling %s: %s\n", source, name, err)
+			continue
+		}
+		list = append(list, agents...)
+	}
+	return list
+}
+
+// checkDiscovered checks the agents of a discovered snmp config, as validate does at startup
+func checkDiscovered(agents []snmpInfo, names map[string]string) error {
+	for _, a := range agents {
+		if err := checkAgent(a); err != nil {
+			return err
+		}
+	}
+	if err := expandNames(agents, names); err != nil {
+		return err
+	}
+	return checkOIDs(agents, names)
+}
+
+// savedPath is where the agents last found by the source are kept
+func savedPath(name string) string {
+	dir := cfg.Common.CacheDir
+	if len(dir) == 0 {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "discovery-"+name+".json")
+}
+
+// saveAgents keeps the agents found by the source, to be used if it can't be read at startup
+func saveAgents(name string, list []AgentSpec) {
+	data, err := json.Marshal(list)
+	if err == nil {
+		err = ioutil.WriteFile(savedPath(name), data, 0600)
+	}
+	if err != nil {
+		log.Printf("cannot save the agents of discovery %s: %s\n", name, err)
+	}
+}
+
+// savedAgents returns the agents the source last found
+func savedAgents(name string) ([]AgentSpec, error) {
+	data, err := ioutil.ReadFile(savedPath(name))
+	if err != nil {
+		return nil, err
+	}
+	var list []AgentSpec
+	return list, json.Unmarshal(data, &list)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	client "github.com/influxdata/influxdb/client/v2"
+	"github.com/pkg/errors"
+)
+
+// rollup is a downsampling step, grouping by interval
+// and saving into a retention policy of the given duration
+type rollup struct {
+	Interval string
+	Duration string
+}
+
+// policy is the retention policy name for the rollup
+func (r rollup) policy() string {
+	return "rp_" + r.Interval
+}
+
+// influxDuration converts a duration to influxql units,
+// allowing years to be specified as 'y'
+func influxDuration(s string) (string, error) {
+	if len(s) < 2 {
+		return "", fmt.Errorf("invalid duration: %q", s)
+	}
+	unit := s[len(s)-1:]
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n <= 0 {
+		return "", fmt.Errorf("invalid duration: %q", s)
+	}
+	switch unit {
+	case "s", "m", "h", "d", "w":
+		return s, nil
+	case "y":
+		return fmt.Sprintf("%dd", n*365), nil
+	}
+	return "", fmt.Errorf("invalid duration unit: %q", s)
+}
+
+// rollups parses a downsample spec, e.g., "5m@30d 1h@2y"
+func rollups(spec string) ([]rollup, error) {
+	var list []rollup
+	for _, step := range strings.Fields(spec) {
+		parts := strings.Split(step, "@")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid downsample step: %q", step)
+		}
+		interval, err := influxDuration(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		duration, err := influxDuration(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, rollup{interval, duration})
+	}
+	return list, nil
+}
+
+// downsampleFields are the fields rolled up by each step, aliased
+// so that a step sourced from the one before keeps the same names
+const downsampleFields = `mean("value") AS "value", sum("delta") AS "delta"`
+
+// downsampleQueries returns the statements needed to create the
+// retention policies and continuous queries for the rollups.
+// Each step is sourced from the previous one, the first from retention
+func downsampleQueries(database, retention string, list []rollup) []string {
+	var queries []string
+	from := retention
+	for _, r := range list {
+		rp := r.policy()
+		queries = append(queries,
+			fmt.Sprintf(`CREATE RETENTION POLICY "%s" ON "%s" DURATION %s REPLICATION 1`, rp, database, r.Duration),
+			fmt.Sprintf(`CREATE CONTINUOUS QUERY "cq_%s" ON "%s" BEGIN SELECT %s INTO "%s"."%s".:MEASUREMENT FROM "%s"."%s"./.*/ GROUP BY time(%s), * END`,
+				r.Interval, database, downsampleFields, database, rp, database, from, r.Interval),
+		)
+		from = rp
+	}
+	return queries
+}
+
+// defaultPolicy returns the name of the database's default retention policy
+func defaultPolicy(conn client.Client, database string) (string, error) {
+	resp, err := conn.Query(client.NewQuery(fmt.Sprintf(`SHOW RETENTION POLICIES ON "%s"`, database), database, ""))
+	if err == nil {
+		err = resp.Error()
+	}
+	if err != nil {
+		return "", err
+	}
+	for _, r := range resp.Results {
+		for _, s := range r.Series {
+			name, def := -1, -1
+			for i, c := range s.Columns {
+				switch c {
+				case "name":
+					name = i
+				case "default":
+					def = i
+				}
+			}
+			if name < 0 || def < 0 {
+				continue
+			}
+			for _, v := range s.Values {
+				if yes, ok := v[def].(bool); ok && yes {
+					return fmt.Sprint(v[name]), nil
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("database %s has no default retention policy", database)
+}
+
+// errUnreachable is returned when the server can't be reached to set up downsampling
+var errUnreachable = errors.New("influxdb server unreachable")
+
+// downsample creates the continuous queries for the sender config
+func downsample(conf client.HTTPConfig, c *InfluxConfig, list []rollup) error {
+	conn, err := client.NewHTTPClient(conf)
+	if err != nil {
+		return errors.Wrap(err, "error creating HTTPClient")
+	}
+	defer conn.Close()
+	if _, _, err := conn.Ping(conf.Timeout); err != nil {
+		return errUnreachable
+	}
+	retention := c.Retention
+	if len(retention) == 0 {
+		if retention, err = defaultPolicy(conn, c.Database); err != nil {
+			return errors.Wrap(err, "downsample setup failed")
+		}
+	}
+	for _, cmd := range downsampleQueries(c.Database, retention, list) {
+		resp, err := conn.Query(client.NewQuery(cmd, c.Database, ""))
+		if err == nil {
+			err = resp.Error()
+		}
+		if err != nil {
+			return errors.Wrapf(err, "downsample query failed: %s", cmd)
+		}
+	}
+	return nil
+}
+
+// setupDownsample creates the continuous queries in the background
+// so startup isn't held up, retrying until the server can be reached
+func setupDownsample(conf client.HTTPConfig, c *InfluxConfig, list []rollup) {
+	for !stopping() {
+		err := downsample(conf, c, list)
+		if err == nil {
+			return
+		}
+		if err != errUnreachable {
+			log.Printf("downsampling for %s not set up: %s\n", c.URL, err)
+			return
+		}
+		time.Sleep(retry)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// buildInfo describes the running binary
+type buildInfo struct {
+	Version   string            `json:"version"`
+	GoVersion string            `json:"go_version"`
+	OS        string            `json:"os"`
+	Arch      string            `json:"arch"`
+	Modules   map[string]string `json:"modules,omitempty"`
+}
+
+// senderJSON is a sender's statistics as json
+type senderJSON struct {
+	Sent      int64            `json:"sent"`
+	Dropped   int64            `json:"dropped"`
+	Errors    int64            `json:"errors"`
+	Classes   map[string]int64 `json:"error_classes,omitempty"`
+	Queued    int              `json:"queued"`
+	LastError string           `json:"last_error,omitempty"`
+	LastTime  time.Time        `json:"last_time"`
+}
+
+// nameMaps are the names resolved from the agents
+type nameMaps struct {
+	SysNames   map[string]string            `json:"sysnames"`
+	Interfaces map[string]map[string]string `json:"interfaces"` // by host/column
+	Joins      map[string]joinCache         `json:"joins"`      // by host/key/column
+	Aliases    aliasMap                     `json:"aliases"`
+}
+
+// runtimeDump is everything needed to understand the collector's state in a bug report
+type runtimeDump struct {
+	Time        time.Time             `json:"time"`
+	Station     string                `json:"station,omitempty"`
+	Started     time.Time             `json:"started"`
+	Build       buildInfo             `json:"build"`
+	Config      string                `json:"config"` // secrets redacted
+	Agents      map[string]agentJSON  `json:"agents"`
+	Senders     map[string]senderJSON `json:"senders"`
+	Series      map[string]int        `json:"series"`
+	Invalid     int64                 `json:"invalid"`
+	Quarantined map[string]time.Time  `json:"quarantined"`
+	Names       nameMaps              `json:"names"`
+	MibCache    []mibCacheEntry       `json:"mib_cache"`
+}
+
+// joinTables are the joins in use, by host/key/column
+var joinTables = struct {
+	sync.Mutex
+	joins map[string]*join
+}{joins: make(map[string]*join)}
+
+// binaryInfo returns the version and dependencies the collector was built with
+func binaryInfo() buildInfo {
+	b := buildInfo{
+		Version:   version,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		b.Modules = make(map[string]string)
+		for _, m := range info.Deps {
+			b.Modules[m.Path] = m.Version
+		}
+	}
+	return b
+}
+
+// nameCache returns a copy of the resolved names
+func nameCache() nameMaps {
+	n := nameMaps{
+		SysNames:   make(map[string]string),
+		Interfaces: make(map[string]map[string]string),
+		Joins:      make(map[string]joinCache),
+	}
+	sysNames.Lock()
+	for k, v := range sysNames.names {
+		n.SysNames[k] = v
+	}
+	sysNames.Unlock()
+
+	ifTables.Lock()
+	for key, t := range ifTables.tables {
+		t.Lock()
+		n.Interfaces[key] = t.names
+		t.Unlock()
+	}
+	ifTables.Unlock()
+
+	joinTables.Lock()
+	for key, j := range joinTables.joins {
+		j.Lock()
+		n.Joins[key] = joinCache{j.keys, j.names}
+		j.Unlock()
+	}
+	joinTables.Unlock()
+
+	aliasLock.RLock()
+	n.Aliases = aliases
+	aliasLock.RUnlock()
+	return n
+}
+
+// stateDump returns a snapshot of the collector's state
+func stateDump() runtimeDump {
+	var config bytes.Buffer
+	printConfig(&config)
+	d := runtimeDump{
+		Time:        time.Now(),
+		Station:     cfg.Common.Station,
+		Started:     startTime,
+		Build:       binaryInfo(),
+		Config:      config.String(),
+		Agents:      make(map[string]agentJSON),
+		Senders:     make(map[string]senderJSON),
+		Series:      guard.cardinality(),
+		Invalid:     atomic.LoadInt64(&invalidCount),
+		Quarantined: quarantineList(),
+		Names:       nameCache(),
+		MibCache:    mibCacheStatus,
+	}
+	for name, s := range getStats() {
+		d.Agents[name] = newAgentJSON(s)
+	}
+	for name, s := range senderStats() {
+		sj := senderJSON{
+			Sent:     s.Sent,
+			Dropped:  s.Dropped,
+			Errors:   s.Errors,
+			Classes:  s.Classes,
+			Queued:   s.Queued,
+			LastTime: s.LastTime,
+		}
+		if s.LastError != nil {
+			sj.LastError = s.LastError.Error()
+		}
+		d.Senders[name] = sj
+	}
+	return d
+}
+
+// dumpAPI returns a snapshot of the collector's state as json
+func dumpAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(stateDump()); err != nil {
+		log.Printf("dump error:%s\n", err)
+	}
+}
+
+// dumpFile writes a snapshot of the collector's state to the configured file
+func dumpFile() {
+	if len(cfg.Common.DumpFile) == 0 {
+		return
+	}
+	data, err := json.MarshalIndent(stateDump(), "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(cfg.Common.DumpFile, data, 0600)
+	}
+	if err != nil {
+		log.Printf("dump error:%s\n", err)
+		return
+	}
+	log.Printf("state written to %s\n", cfg.Common.DumpFile)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// elapsedMeasurement is where the duration of each walk is saved
+const elapsedMeasurement = "snmp_elapsed"
+
+// walkTimer tracks when a walk's values were received
+type walkTimer struct {
+	sync.Mutex
+	start  time.Time
+	stop   time.Time
+	values int
+}
+
+// wrap returns a sender that times the values passing through it
+func (t *walkTimer) wrap(sender snmp.Sender) snmp.Sender {
+	return func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+		t.Lock()
+		if t.values == 0 || ts.Start.Before(t.start) {
+			t.start = ts.Start
+		}
+		if ts.Stop.After(t.stop) {
+			t.stop = ts.Stop
+		}
+		t.values++
+		t.Unlock()
+		return sender(name, tags, value, ts)
+	}
+}
+
+// done returns the fields for the walk just finished, and resets for the next
+func (t *walkTimer) done() (map[string]interface{}, time.Time, bool) {
+	t.Lock()
+	defer t.Unlock()
+	if t.values == 0 {
+		return nil, time.Time{}, false
+	}
+	fields := map[string]interface{}{
+		"elapsed": int(t.stop.Sub(t.start).Nanoseconds() / 1000000),
+		"values":  t.values,
+	}
+	start := t.start
+	t.values = 0
+	t.stop = time.Time{}
+	return fields, start, true
+}
+
+// elapsedTags returns the tags of a walk's duration
+func elapsedTags(host, oid string) map[string]string {
+	tags := map[string]string{"host": host, "mib": oid}
+	for k, v := range commonTags {
+		tags[k] = v
+	}
+	return tags
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// EnrichConfig specifies a csv file of extra tags, keyed by an existing tag.
+// The first row is a header naming the key column and the tags to add
+type EnrichConfig struct {
+	File    string `gcfg:"file"`
+	Key     string `gcfg:"key"`     // tag whose value is looked up in the first column
+	Refresh int    `gcfg:"refresh"` // seconds between reloads of the file
+}
+
+// enricher holds the tags to add for each key value
+type enricher struct {
+	sync.RWMutex
+	key  string
+	tags map[string]map[string]string
+}
+
+var enrichers []*enricher
+
+// readEnrich loads the csv file of tags
+func readEnrich(filename string) (map[string]map[string]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	recs, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(recs) == 0 {
+		return nil, fmt.Errorf("no header in %s", filename)
+	}
+	header := recs[0]
+	m := make(map[string]map[string]string)
+	for _, rec := range recs[1:] {
+		t := make(map[string]string, len(rec)-1)
+		for i := 1; i < len(rec) && i < len(header); i++ {
+			if len(rec[i]) > 0 {
+				t[header[i]] = rec[i]
+			}
+		}
+		m[rec[0]] = t
+	}
+	return m, nil
+}
+
+func (e *enricher) load(filename string) {
+	m, err := readEnrich(filename)
+	if err != nil {
+		log.Printf("error loading tags from %s: %s\n", filename, err)
+		return
+	}
+	e.Lock()
+	e.tags = m
+	e.Unlock()
+}
+
+// loadEnrichers reads the configured tag files, reloading them periodically
+func loadEnrichers() {
+	for _, c := range cfg.Enrich {
+		e := &enricher{key: c.Key}
+		e.load(c.File)
+		if c.Refresh > 0 {
+			go func(c *EnrichConfig) {
+				for {
+					time.Sleep(time.Duration(c.Refresh) * time.Second)
+					e.load(c.File)
+				}
+			}(c)
+		}
+		enrichers = append(enrichers, e)
+	}
+}
+
+// EnrichSender adds the tags looked up from the tag files
+func EnrichSender(sender snmp.Sender) snmp.Sender {
+	if len(enrichers) == 0 {
+		return sender
+	}
+	return func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+		var t map[string]string
+		for _, e := range enrichers {
+			e.RLock()
+			extra := e.tags[tags[e.key]]
+			e.RUnlock()
+			for k, v := range extra {
+				if t == nil {
+					t = make(map[string]string, len(tags)+len(extra))
+					for k, v := range tags {
+						t[k] = v
+					}
+				}
+				t[k] = v
+			}
+		}
+		if t != nil {
+			tags = t
+		}
+		return sender(name, tags, value, ts)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix starts the names of the environment variables that configure
+// a single agent and sender when there is no config file
+const envPrefix = "INFLUXSNMP_"
+
+// envSettings map config sections and keys to environment variables, with their defaults
+var envSettings = []struct {
+	section, key, env, def string
+}{
+	{"common", "mibfile", "MIBFILE", ""},
+	{"common", "httpPort", "HTTP_PORT", "8080"},
+	{"common", "station", "STATION", ""},
+	{`snmp "env"`, "host", "HOST", ""},
+	{`snmp "env"`, "community", "COMMUNITY", "public"},
+	{`snmp "env"`, "version", "VERSION", "2c"},
+	{`snmp "env"`, "port", "PORT", ""},
+	{`snmp "env"`, "freq", "FREQ", "60"},
+	{`snmp "env"`, "timeout", "TIMEOUT", ""},
+	{`snmp "env"`, "retries", "RETRIES", ""},
+	{`snmp "env"`, "tags", "TAGS", ""},
+	{`snmp "env"`, "mibs", "MIBS", ""},
+	{`mibs "env"`, "name", "OIDS", ""},
+	{`mibs "env"`, "index", "INDEX", ""},
+	{`influx "*"`, "url", "INFLUX_URL", "http://localhost:8086"},
+	{`influx "*"`, "database", "INFLUX_DATABASE", "snmp"},
+	{`influx "*"`, "username", "INFLUX_USERNAME", ""},
+	{`influx "*"`, "password", "INFLUX_PASSWORD", ""},
+	{`influx "*"`, "retention", "INFLUX_RETENTION", ""},
+}
+
+// envConfig builds a config from INFLUXSNMP_ environment variables, returning
+// false if INFLUXSNMP_HOST is not set. The agent polls the preset or defined
+// mibs listed in INFLUXSNMP_MIBS, and the OIDs in INFLUXSNMP_OIDS
+func envConfig() (string, bool) {
+	if len(os.Getenv(envPrefix+"HOST")) == 0 {
+		return "", false
+	}
+	var b bytes.Buffer
+	last := ""
+	for _, s := range envSettings {
+		value := os.Getenv(envPrefix + s.env)
+		if s.env == "MIBS" && len(os.Getenv(envPrefix+"OIDS")) > 0 {
+			value = strings.TrimSpace(value + " env")
+		}
+		if len(value) == 0 {
+			value = s.def
+		}
+		if len(value) == 0 {
+			continue
+		}
+		if s.section != last {
+			fmt.Fprintf(&b, "\n[%s]\n", s.section)
+			last = s.section
+		}
+		fmt.Fprintf(&b, "%s = %s\n", s.key, configValue(value))
+	}
+	return b.String(), true
+}
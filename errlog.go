@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// defaultErrorHistory is how many errors are kept for each agent
+const defaultErrorHistory = 10
+
+// errorEntry is an error and when it happened
+type errorEntry struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error"`
+}
+
+// errorRing keeps the most recent errors
+type errorRing struct {
+	entries []errorEntry
+	next    int
+}
+
+func (r *errorRing) add(err error) {
+	size := cfg.Common.ErrorHistory
+	if size <= 0 {
+		size = defaultErrorHistory
+	}
+	e := errorEntry{time.Now(), err.Error()}
+	if len(r.entries) < size {
+		r.entries = append(r.entries, e)
+		return
+	}
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % len(r.entries)
+}
+
+// list returns the errors, most recent first
+func (r *errorRing) list() []errorEntry {
+	n := len(r.entries)
+	list := make([]errorEntry, 0, n)
+	for i := 1; i <= n; i++ {
+		list = append(list, r.entries[(r.next-i+n)%n])
+	}
+	return list
+}
+
+// agentJSON is an agent's statistics as json
+type agentJSON struct {
+	Gets        int          `json:"gets"`
+	Errors      int          `json:"errors"`
+	Values      int64        `json:"values"`
+	Bytes       int64        `json:"bytes"`
+	MaxPoll     int64        `json:"max_poll_bytes"`
+	TooBig      int          `json:"too_big,omitempty"`
+	Repetitions int          `json:"repetitions,omitempty"`
+	LastOK      time.Time    `json:"last_ok"`
+	Recent      []errorEntry `json:"recent_errors"`
+}
+
+func newAgentJSON(s snmpStats) agentJSON {
+	return agentJSON{
+		Gets:        s.GetCnt,
+		Errors:      s.ErrCnt,
+		Values:      s.Values,
+		Bytes:       s.Bytes,
+		MaxPoll:     s.MaxPoll,
+		TooBig:      s.TooBig,
+		Repetitions: s.Repetitions,
+		LastOK:      s.LastOK,
+		Recent:      s.Recent,
+	}
+}
+
+// statusAPI returns the polling statistics of each agent as json
+func statusAPI(w http.ResponseWriter, r *http.Request) {
+	agents := make(map[string]agentJSON)
+	for name, s := range getStats() {
+		agents[name] = newAgentJSON(s)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(agents); err != nil {
+		log.Printf("status error:%s\n", err)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// rung is a further attempt at a poll that failed, with its own timeout and retries
+type rung struct {
+	timeout int // seconds
+	retries int
+}
+
+// ladder parses the escalation steps of the snmp config, each "timeout [retries]"
+func ladder(c *SnmpConfig) ([]rung, error) {
+	rungs := make([]rung, 0, len(c.Escalate))
+	for _, step := range c.Escalate {
+		f := strings.Fields(step)
+		if len(f) == 0 || len(f) > 2 {
+			return nil, fmt.Errorf("invalid escalation: %q", step)
+		}
+		var r rung
+		var err error
+		if r.timeout, err = strconv.Atoi(f[0]); err != nil || r.timeout < 1 {
+			return nil, fmt.Errorf("invalid escalation timeout: %q", step)
+		}
+		if len(f) > 1 {
+			if r.retries, err = strconv.Atoi(f[1]); err != nil || r.retries < 0 {
+				return nil, fmt.Errorf("invalid escalation retries: %q", step)
+			}
+		}
+		rungs = append(rungs, r)
+	}
+	return rungs, nil
+}
+
+// escalate tries fn with the profile's timeout and retries, and if that fails,
+// with each step of the escalation of the agent's snmp config in turn, so busy
+// devices get longer to answer without slowing the polls of those that aren't
+func escalate(p snmp.Profile, c *SnmpConfig, fn func(snmp.Profile) error) error {
+	err := fn(p)
+	if err == nil || c == nil {
+		return err
+	}
+	// validate ensures the steps are valid
+	rungs, _ := ladder(c)
+	for _, r := range rungs {
+		logger.Printf("escalating poll of %s to %ds timeout, %d retries: %s\n", p.Host, r.timeout, r.retries, err)
+		p.Timeout, p.Retries = r.timeout, r.retries
+		if err = fn(p); err == nil {
+			return nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// eventMeasurement is where state change events are saved
+const eventMeasurement = "events"
+
+// ThresholdConfig specifies value limits that generate events
+type ThresholdConfig struct {
+	Name  string   `gcfg:"name"`  // measurement names to watch
+	Raise float64  `gcfg:"raise"` // value that raises the event
+	Clear *float64 `gcfg:"clear"` // value that clears the event, the raise value if not given
+	Hold  int      `gcfg:"hold"`  // seconds a new state must persist before reporting
+}
+
+// clearLevel returns the value that clears the event
+func (t *ThresholdConfig) clearLevel() float64 {
+	if t.Clear == nil {
+		return t.Raise
+	}
+	return *t.Clear
+}
+
+// alarmed applies hysteresis to a value -- once raised, the value
+// must cross the clear threshold before the event clears.
+// If raise is below clear the threshold is for falling values
+func (t *ThresholdConfig) alarmed(value float64, prior bool) bool {
+	level := t.clearLevel()
+	if t.Raise >= level {
+		if prior && level == t.Raise {
+			// without hysteresis, the value stays raised until it falls below
+			return value >= level
+		}
+		if prior {
+			return value > level
+		}
+		return value >= t.Raise
+	}
+	if prior {
+		return value < level
+	}
+	return value <= t.Raise
+}
+
+// flapper suppresses state changes that do not persist for the hold time
+type flapper struct {
+	hold  time.Duration
+	alarm bool      // last reported state
+	since time.Time // when a differing state was first seen
+}
+
+// update returns true if the reported state has changed
+func (f *flapper) update(alarm bool, now time.Time) bool {
+	if alarm == f.alarm {
+		f.since = time.Time{}
+		return false
+	}
+	if f.since.IsZero() {
+		f.since = now
+	}
+	if now.Sub(f.since) < f.hold {
+		return false
+	}
+	f.alarm = alarm
+	f.since = time.Time{}
+	return true
+}
+
+// toFloat converts numeric values to float64
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// stateName returns the event state label
+func stateName(alarm bool, raised, cleared string) string {
+	if alarm {
+		return raised
+	}
+	return cleared
+}
+
+// thresholds returns the threshold configs applicable to the measurement
+func thresholds(name string) []*ThresholdConfig {
+	var list []*ThresholdConfig
+	for _, t := range cfg.Threshold {
+		for _, n := range strings.Fields(t.Name) {
+			if n == name {
+				list = append(list, t)
+				break
+			}
+		}
+	}
+	return list
+}
+
+// ThresholdSender generates events when values cross configured thresholds
+func ThresholdSender(sender snmp.Sender, send SendFunc) snmp.Sender {
+	if len(cfg.Threshold) == 0 {
+		return sender
+	}
+	var m sync.Mutex
+	states := make(map[string]*flapper)
+	return func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+		if err := sender(name, tags, value, ts); err != nil {
+			return err
+		}
+		f, ok := toFloat(value)
+		if !ok {
+			return nil
+		}
+		for _, t := range thresholds(name) {
+			key := fmt.Sprintf("%p:%s", t, seriesKey(name, tags))
+			m.Lock()
+			state, ok := states[key]
+			if !ok {
+				state = &flapper{hold: time.Duration(t.Hold) * time.Second}
+				states[key] = state
+			}
+			changed := state.update(t.alarmed(f, state.alarm), ts.Stop)
+			alarm := state.alarm
+			m.Unlock()
+			if !changed {
+				continue
+			}
+			etags := map[string]string{"type": "threshold", "measurement": name}
+			for k, v := range tags {
+				etags[k] = v
+			}
+			fields := map[string]interface{}{
+				"state": stateName(alarm, "raised", "cleared"),
+				"value": f,
+			}
+			if err := send(eventMeasurement, etags, fields, ts.Stop); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// availability tracks poll success to generate up/down events
+func availability(send SendFunc, host, mibID string) func(error) {
+	state := &flapper{hold: time.Duration(cfg.Common.EventHold) * time.Second}
+	var m sync.Mutex
+	return func(err error) {
+		now := time.Now()
+		m.Lock()
+		changed := state.update(err != nil, now)
+		alarm := state.alarm
+		m.Unlock()
+		if !changed {
+			return
+		}
+		tags := map[string]string{"type": "availability", "host": host, "mib": mibID}
+		fields := map[string]interface{}{"state": stateName(alarm, "down", "up")}
+		if err != nil {
+			fields["error"] = err.Error()
+		}
+		annotator(fmt.Sprintf("%s (%s) is %s", host, mibID, fields["state"]), "availability", host)
+		if err := send(eventMeasurement, tags, fields, now); err != nil {
+			log.Println("event error:", err)
+		}
+	}
+}
+
+// stateColumn describes a status column that generates
+// events when it leaves or returns to its normal state
+type stateColumn struct {
+	kind   string         // event type
+	normal string         // label of the normal state
+	labels map[int]string // enumerated values
+}
+
+// label returns the enumerated name of the value
+func (c stateColumn) label(value interface{}) string {
+	if f, ok := toFloat(value); ok {
+		if l, ok := c.labels[int(f)]; ok {
+			return l
+		}
+	}
+	return fmt.Sprint(value)
+}
+
+var (
+	ifStates = map[int]string{
+		1: "up", 2: "down", 3: "testing", 4: "unknown",
+		5: "dormant", 6: "notPresent", 7: "lowerLayerDown",
+	}
+	bgpStates = map[int]string{
+		1: "idle", 2: "connect", 3: "active",
+		4: "opensent", 5: "openconfirm", 6: "established",
+	}
+	ospfStates = map[int]string{
+		1: "down", 2: "attempt", 3: "init", 4: "twoWay",
+		5: "exchangeStart", 6: "exchange", 7: "loading", 8: "full",
+	}
+
+	// stateColumns are the columns tracked for state change events
+	stateColumns = map[string]stateColumn{
+		"ifOperStatus":  {"link", "up", ifStates},
+		"ifAdminStatus": {"link", "up", ifStates},
+		"bgpPeerState":  {"bgp", "established", bgpStates},
+		"ospfNbrState":  {"ospf", "full", ospfStates},
+	}
+)
+
+// StateSender generates events when interface, bgp session,
+// or ospf neighbor states change. The raw status is saved as
+// status_value, since threshold events save a float value
+func StateSender(sender snmp.Sender, send SendFunc) snmp.Sender {
+	if !cfg.Common.Events {
+		return sender
+	}
+	hold := time.Duration(cfg.Common.EventHold) * time.Second
+	var m sync.Mutex
+	states := make(map[string]*flapper)
+	return func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+		if err := sender(name, tags, value, ts); err != nil {
+			return err
+		}
+		column, ok := stateColumns[name]
+		if !ok {
+			return nil
+		}
+		key := seriesKey(name, tags)
+		label := column.label(value)
+		abnormal := label != column.normal
+		m.Lock()
+		state, ok := states[key]
+		if !ok {
+			// the first reading is the baseline
+			state = &flapper{hold: hold, alarm: abnormal}
+			states[key] = state
+		}
+		changed := state.update(abnormal, ts.Stop)
+		m.Unlock()
+		if !changed {
+			return nil
+		}
+		etags := map[string]string{"type": column.kind, "status": name}
+		for k, v := range tags {
+			etags[k] = v
+		}
+		fields := map[string]interface{}{
+			"state":        label,
+			"status_value": fmt.Sprint(value),
+		}
+		return send(eventMeasurement, etags, fields, ts.Stop)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAlarmed(t *testing.T) {
+	level := func(f float64) *float64 { return &f }
+	tests := []struct {
+		name   string
+		t      ThresholdConfig
+		values []float64
+		want   []bool
+	}{
+		{
+			name:   "rising without hysteresis",
+			t:      ThresholdConfig{Raise: 90},
+			values: []float64{80, 90, 95, 90, 89.9, 90},
+			want:   []bool{false, true, true, true, false, true},
+		},
+		{
+			name:   "rising with hysteresis",
+			t:      ThresholdConfig{Raise: 90, Clear: level(80)},
+			values: []float64{85, 90, 85, 80, 85, 79, 85},
+			want:   []bool{false, true, true, false, false, false, false},
+		},
+		{
+			name:   "falling with hysteresis",
+			t:      ThresholdConfig{Raise: 10, Clear: level(20)},
+			values: []float64{50, 15, 10, 15, 19.9, 20, 15, 5},
+			want:   []bool{false, false, true, true, true, false, false, true},
+		},
+		{
+			name:   "clear at zero",
+			t:      ThresholdConfig{Raise: 5, Clear: level(0)},
+			values: []float64{0, 5, 1, 0.5, 0, 3, 5},
+			want:   []bool{false, true, true, true, false, false, true},
+		},
+		{
+			name:   "raise at zero",
+			t:      ThresholdConfig{Raise: 0, Clear: level(1)},
+			values: []float64{2, 0.5, 0, 0.5, 1, 0.5},
+			want:   []bool{false, false, true, true, false, false},
+		},
+	}
+	for _, tt := range tests {
+		alarm := false
+		for i, v := range tt.values {
+			alarm = tt.t.alarmed(v, alarm)
+			if alarm != tt.want[i] {
+				t.Errorf("%s: value %d (%g) got %t, want %t", tt.name, i, v, alarm, tt.want[i])
+				break
+			}
+		}
+	}
+}
+
+func TestFlapper(t *testing.T) {
+	type step struct {
+		after   int // seconds since the start
+		alarm   bool
+		changed bool
+	}
+	tests := []struct {
+		name  string
+		hold  int
+		steps []step
+	}{
+		{
+			name:  "no hold",
+			steps: []step{{0, false, false}, {10, true, true}, {20, true, false}, {30, false, true}},
+		},
+		{
+			name: "raise held",
+			hold: 60,
+			steps: []step{
+				{0, true, false}, {30, true, false}, {60, true, true}, {90, true, false},
+			},
+		},
+		{
+			name: "clear held",
+			hold: 60,
+			steps: []step{
+				{0, true, false}, {60, true, true},
+				{70, false, false}, {100, false, false}, {130, false, true}, {140, false, false},
+			},
+		},
+		{
+			name: "flap suppressed",
+			hold: 60,
+			steps: []step{
+				{0, true, false}, {30, false, false}, {40, true, false}, {90, true, false}, {100, true, true},
+			},
+		},
+	}
+	start := time.Now()
+	for _, tt := range tests {
+		f := &flapper{hold: time.Duration(tt.hold) * time.Second}
+		for i, s := range tt.steps {
+			now := start.Add(time.Duration(s.after) * time.Second)
+			if changed := f.update(s.alarm, now); changed != s.changed {
+				t.Errorf("%s: step %d got %t, want %t", tt.name, i, changed, s.changed)
+				break
+			}
+			if s.changed && f.alarm != s.alarm {
+				t.Errorf("%s: step %d reported %t, want %t", tt.name, i, f.alarm, s.alarm)
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// defaultEventBatch is the batch size of event senders
+const defaultEventBatch = 100
+
+// eventsName is the suffix of the sender names of event senders
+const eventsName = "/events"
+
+// subSender creates a sender to the same influxdb as the config with its own batching,
+// so some points can be written sooner than the bulk of them
+func subSender(c *InfluxConfig, flush, batchSize int) (Sender, error) {
+	sc := *c
+	sc.Flush = flush
+	sc.BatchSize = batchSize
+	sc.Writers = 1
+	// the main sender sets up downsampling
+	sc.Downsample = ""
+	return newSender(&sc)
+}
+
+// eventSenders adds a sender for the events of each influx config with an event flush interval
+func eventSenders(s map[string]Sender) error {
+	for name, c := range cfg.Influx {
+		if c.EventFlush <= 0 {
+			continue
+		}
+		batch := c.EventBatchSize
+		if batch <= 0 {
+			batch = defaultEventBatch
+		}
+		sender, err := subSender(c, c.EventFlush, batch)
+		if err != nil {
+			return fmt.Errorf("influx config %s events: %s", name, err)
+		}
+		s[name+eventsName] = sender
+	}
+	return nil
+}
+
+// EventRouter sends events with the events sender, if there is one,
+// so they are not held back by the flush interval of the bulk of the points
+func EventRouter(send SendFunc, events Sender, retention string) SendFunc {
+	if events == nil {
+		return send
+	}
+	return func(name string, tags map[string]string, fields map[string]interface{}, ts time.Time) error {
+		if name == eventMeasurement {
+			return events.Send(retention, name, tags, fields, ts)
+		}
+		return send(name, tags, fields, ts)
+	}
+}
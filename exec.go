@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// ExecConfig specifies an external program that transforms points.
+// Each point is written to its stdin as a line of json, and it must
+// reply with a line containing a json array of zero or more points
+type ExecConfig struct {
+	Command string `gcfg:"command"`
+	Timeout int    `gcfg:"timeout"` // milliseconds to wait for a reply
+	OnError string `gcfg:"onError"` // "pass" the point unchanged (default) or "drop" it
+}
+
+// execPoint is the json representation of a point
+type execPoint struct {
+	Name  string            `json:"name"`
+	Tags  map[string]string `json:"tags"`
+	Value interface{}       `json:"value"`
+	Time  int64             `json:"time"`
+}
+
+// execStage manages the external program
+type execStage struct {
+	sync.Mutex
+	command string
+	timeout time.Duration
+	cmd     *exec.Cmd
+	in      io.WriteCloser
+	out     *bufio.Reader
+}
+
+var (
+	execOnce sync.Once
+	execer   *execStage
+)
+
+func (e *execStage) start() error {
+	args := strings.Fields(e.command)
+	cmd := exec.Command(args[0], args[1:]...)
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	e.cmd, e.in, e.out = cmd, in, bufio.NewReader(out)
+	return nil
+}
+
+func (e *execStage) stop() {
+	if e.cmd == nil {
+		return
+	}
+	e.in.Close()
+	e.cmd.Process.Kill()
+	e.cmd.Wait()
+	e.cmd = nil
+}
+
+// number converts json numbers to int64 or float64
+func number(v interface{}) interface{} {
+	n, ok := v.(json.Number)
+	if !ok {
+		return v
+	}
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	if f, err := n.Float64(); err == nil {
+		return f
+	}
+	return n.String()
+}
+
+// transform sends the point to the program and returns its reply.
+// If the program fails or times out it is restarted on the next call
+func (e *execStage) transform(pt execPoint) ([]execPoint, error) {
+	e.Lock()
+	defer e.Unlock()
+	if e.cmd == nil {
+		if err := e.start(); err != nil {
+			return nil, err
+		}
+	}
+	b, err := json.Marshal(pt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := e.in.Write(append(b, '\n')); err != nil {
+		e.stop()
+		return nil, err
+	}
+
+	type reply struct {
+		line []byte
+		err  error
+	}
+	c := make(chan reply, 1)
+	go func(r *bufio.Reader) {
+		line, err := r.ReadBytes('\n')
+		c <- reply{line, err}
+	}(e.out)
+
+	select {
+	case r := <-c:
+		if r.err != nil {
+			e.stop()
+			return nil, r.err
+		}
+		var pts []execPoint
+		dec := json.NewDecoder(bytes.NewReader(r.line))
+		dec.UseNumber()
+		if err := dec.Decode(&pts); err != nil {
+			return nil, err
+		}
+		for i := range pts {
+			pts[i].Value = number(pts[i].Value)
+		}
+		return pts, nil
+	case <-time.After(e.timeout):
+		e.stop()
+		return nil, fmt.Errorf("exec %s timed out", e.command)
+	}
+}
+
+// ExecSender passes points through the external program
+func ExecSender(sender snmp.Sender) snmp.Sender {
+	c := cfg.Exec
+	if len(strings.TrimSpace(c.Command)) == 0 {
+		return sender
+	}
+	// all pollers share the one program
+	execOnce.Do(func() {
+		timeout := time.Duration(c.Timeout) * time.Millisecond
+		if timeout <= 0 {
+			timeout = time.Second
+		}
+		execer = &execStage{command: c.Command, timeout: timeout}
+	})
+	drop := c.OnError == "drop"
+	return func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+		pts, err := execer.transform(execPoint{name, tags, value, ts.Stop.UnixNano()})
+		if err != nil {
+			log.Printf("exec error for %s: %s\n", name, err)
+			if drop {
+				return nil
+			}
+			return sender(name, tags, value, ts)
+		}
+		for _, pt := range pts {
+			stamp := ts
+			if pt.Time != 0 && pt.Time != ts.Stop.UnixNano() {
+				when := time.Unix(0, pt.Time)
+				stamp = snmp.TimeStamp{Start: when, Stop: when}
+			}
+			if err := sender(pt.Name, pt.Tags, pt.Value, stamp); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// exit codes
+const (
+	exitFailed = 1 // self test or benchmark problems, or a sample that differs
+	exitConfig = 2 // invalid config, mibs, or command line
+	exitDB     = 3 // the database could not be used
+	exitSNMP   = 4 // devices could not be polled
+)
+
+// fatal logs the error and exits with the code
+func fatal(code int, format string, args ...interface{}) {
+	log.Printf(format+"\n", args...)
+	os.Exit(code)
+}
+
+// validate checks the config is usable before anything is started
+func validate(agents []snmpInfo) error {
+	// -dump and -grafana exit before the mib files are loaded
+	if len(cfg.Common.MibFile) == 0 && !dump && len(grafana) == 0 {
+		return fmt.Errorf("no mibfile specified")
+	}
+	for _, a := range agents {
+		if err := checkAgent(a); err != nil {
+			return err
+		}
+	}
+	for name, c := range cfg.Influx {
+		if _, err := senderFactory(c); err != nil {
+			return fmt.Errorf("influx config %s: %s", name, err)
+		}
+	}
+	if _, err := loadRelabels(); err != nil {
+		return err
+	}
+	if err := checkCredentials(agents); err != nil {
+		return err
+	}
+	if err := checkClasses(agents); err != nil {
+		return err
+	}
+	if err := checkInvalid(cfg.Common.Invalid); err != nil {
+		return err
+	}
+	if err := checkConformance(cfg.Common.Conformance); err != nil {
+		return err
+	}
+	if err := checkTenants(agents); err != nil {
+		return err
+	}
+	if err := checkScript(); err != nil {
+		return err
+	}
+	return checkInput()
+}
+
+// checkAgent checks the settings of an agent
+func checkAgent(a snmpInfo) error {
+	if _, err := processorList(a.Config); err != nil {
+		return fmt.Errorf("snmp config %s: %s", a.Name, err)
+	}
+	switch a.Config.Timestamp {
+	case "", "start", "middle", "stop", "uptime":
+	default:
+		return fmt.Errorf("snmp config %s: invalid timestamp: %s", a.Name, a.Config.Timestamp)
+	}
+	if _, err := parseSchedule(a.Config); err != nil {
+		return fmt.Errorf("snmp config %s: %s", a.Name, err)
+	}
+	if err := checkV3(a.Config); err != nil {
+		return fmt.Errorf("snmp config %s: %s", a.Name, err)
+	}
+	if _, err := ladder(a.Config); err != nil {
+		return fmt.Errorf("snmp config %s: %s", a.Name, err)
+	}
+	if _, _, err := parseTop(a.MIB.Top); err != nil {
+		return fmt.Errorf("snmp config %s: %s", a.Name, err)
+	}
+	if err := checkResolve(a.Config.Resolve); err != nil {
+		return fmt.Errorf("snmp config %s: %s", a.Name, err)
+	}
+	if a.Config.Freq < 1 {
+		return fmt.Errorf("snmp config %s: invalid polling frequency: %d", a.Name, a.Config.Freq)
+	}
+	if _, ok := influxFor(a.Name); !ok {
+		return fmt.Errorf("snmp config %s: no influx config", a.Name)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+)
+
+// favicon is the default icon, a 16x16 png bar chart in an ico container
+var favicon = []byte{
+	0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x10, 0x10, 0x00, 0x00, 0x01, 0x00,
+	0x20, 0x00, 0x63, 0x00, 0x00, 0x00, 0x16, 0x00, 0x00, 0x00, 0x89, 0x50,
+	0x4e, 0x47, 0x0d, 0x0a, 0x1a, 0x0a, 0x00, 0x00, 0x00, 0x0d, 0x49, 0x48,
+	0x44, 0x52, 0x00, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x10, 0x08, 0x06,
+	0x00, 0x00, 0x00, 0x1f, 0xf3, 0xff, 0x61, 0x00, 0x00, 0x00, 0x2a, 0x49,
+	0x44, 0x41, 0x54, 0x78, 0xda, 0x63, 0x60, 0x18, 0x05, 0x38, 0x81, 0xbc,
+	0x5f, 0xcf, 0x7f, 0x18, 0x1e, 0x26, 0x06, 0x90, 0x6c, 0x20, 0xc9, 0x06,
+	0x10, 0xd2, 0x30, 0x6a, 0x00, 0xad, 0x0d, 0x30, 0x36, 0x36, 0xfe, 0x4f,
+	0x0e, 0x06, 0xe9, 0x05, 0x00, 0x06, 0xa5, 0xa9, 0xf9, 0xdf, 0xfa, 0x3b,
+	0xda, 0x00, 0x00, 0x00, 0x00, 0x49, 0x45, 0x4e, 0x44, 0xae, 0x42, 0x60,
+	0x82,
+}
+
+// faviconPage serves the configured icon file, or the built in one
+func faviconPage(w http.ResponseWriter, r *http.Request) {
+	icon := favicon
+	if len(cfg.Common.Favicon) > 0 {
+		data, err := ioutil.ReadFile(cfg.Common.Favicon)
+		if err != nil {
+			log.Printf("favicon error:%s\n", err)
+		} else {
+			icon = data
+		}
+	}
+	http.ServeContent(w, r, "favicon.ico", startTime, bytes.NewReader(icon))
+}
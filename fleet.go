@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// peerInterval is how often the coverage of peer collectors is fetched
+const peerInterval = time.Minute
+
+// coverage is the devices a collector is configured for, and those it is polling successfully
+type coverage struct {
+	Station    string    `json:"station,omitempty"`
+	Updated    time.Time `json:"updated"`
+	Configured []string  `json:"configured"`
+	Polling    []string  `json:"polling"`
+}
+
+// peerStatus is the last coverage fetched from a peer
+type peerStatus struct {
+	URL   string
+	Error string
+	coverage
+}
+
+// fleetStatus is the coverage of this collector and its peers
+type fleetStatus struct {
+	Peers []peerStatus
+	// Uncovered are devices configured somewhere that no collector is polling
+	Uncovered []string
+}
+
+var (
+	peerLock sync.Mutex
+	peers    = make(map[string]peerStatus)
+)
+
+// localCoverage returns this collector's coverage
+func localCoverage() coverage {
+	now := time.Now()
+	c := coverage{Station: cfg.Common.Station, Updated: now}
+	configured := make(map[string]bool)
+	for _, s := range snmpConfigs() {
+		for _, host := range strings.Fields(s.Host) {
+			configured[host] = true
+		}
+	}
+	polling := make(map[string]bool)
+	for name, s := range getStats() {
+		if agentState(s, now) == stateHealthy {
+			polling[strings.SplitN(name, "/", 2)[0]] = true
+		}
+	}
+	c.Configured = sortedKeys(configured)
+	c.Polling = sortedKeys(polling)
+	return c
+}
+
+// sortedKeys returns the keys of the set in order
+func sortedKeys(set map[string]bool) []string {
+	list := make([]string, 0, len(set))
+	for k := range set {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// coverageAPI returns this collector's coverage as json, for its peers
+func coverageAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(localCoverage()); err != nil {
+		log.Printf("coverage error:%s\n", err)
+	}
+}
+
+// fetchCoverage gets the coverage of the peer
+func fetchCoverage(client *http.Client, peer string) (coverage, error) {
+	var c coverage
+	resp, err := client.Get(strings.TrimRight(peer, "/") + "/api/coverage")
+	if err != nil {
+		return c, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return c, fmt.Errorf("status: %s", resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&c)
+	return c, err
+}
+
+// peerExchange periodically fetches the coverage of the peer collectors
+func peerExchange(list []string) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	for {
+		for _, peer := range list {
+			c, err := fetchCoverage(client, peer)
+			peerLock.Lock()
+			p := peers[peer]
+			p.URL = peer
+			if err != nil {
+				// keep the last known coverage, it is shown with its age
+				p.Error = err.Error()
+			} else {
+				p.Error = ""
+				p.coverage = c
+			}
+			peers[peer] = p
+			peerLock.Unlock()
+			if err != nil && debugging() {
+				log.Printf("peer %s: %s\n", peer, err)
+			}
+		}
+		time.Sleep(peerInterval)
+	}
+}
+
+// fleet returns the coverage of all collectors, or nil if there are no peers
+func fleet() *fleetStatus {
+	if len(cfg.Common.Peers) == 0 {
+		return nil
+	}
+	local := localCoverage()
+	f := &fleetStatus{Peers: []peerStatus{{URL: "local", coverage: local}}}
+	peerLock.Lock()
+	for _, p := range peers {
+		f.Peers = append(f.Peers, p)
+	}
+	peerLock.Unlock()
+	sort.Slice(f.Peers[1:], func(i, j int) bool {
+		return f.Peers[i+1].URL < f.Peers[j+1].URL
+	})
+
+	configured := make(map[string]bool)
+	polling := make(map[string]bool)
+	for _, p := range f.Peers {
+		for _, host := range p.Configured {
+			configured[host] = true
+		}
+		if len(p.Error) > 0 {
+			// an unreachable collector is not polling anything
+			continue
+		}
+		for _, host := range p.Polling {
+			polling[host] = true
+		}
+	}
+	for host := range polling {
+		delete(configured, host)
+	}
+	f.Uncovered = sortedKeys(configured)
+	return f
+}
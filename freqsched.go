@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// weekdays are the day names used in frequency schedules
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// freqWindow is a time of day, on some days of the week, with its own polling frequency
+type freqWindow struct {
+	days     [7]bool
+	from, to int // minutes into the day, wrapping past midnight if to < from
+	freq     int
+}
+
+// parseDays parses days of the week, e.g., "mon-fri" or "sat,sun"
+func parseDays(spec string) ([7]bool, error) {
+	var days [7]bool
+	for _, part := range strings.Split(strings.ToLower(spec), ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		first, ok := weekdays[bounds[0]]
+		if !ok {
+			return days, fmt.Errorf("invalid day: %q", bounds[0])
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, ok = weekdays[bounds[1]]; !ok {
+				return days, fmt.Errorf("invalid day: %q", bounds[1])
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// parseClock parses a time of day as minutes, e.g., "08:30"
+func parseClock(spec string) (int, error) {
+	t, err := time.Parse("15:04", spec)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day: %q", spec)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// parseWindow parses a frequency schedule entry, as "[days] HH:MM-HH:MM freq",
+// e.g., "mon-fri 08:00-18:00 30"
+func parseWindow(spec string) (freqWindow, error) {
+	var w freqWindow
+	f := strings.Fields(spec)
+	switch len(f) {
+	case 2:
+		for i := range w.days {
+			w.days[i] = true
+		}
+	case 3:
+		days, err := parseDays(f[0])
+		if err != nil {
+			return w, err
+		}
+		w.days, f = days, f[1:]
+	default:
+		return w, fmt.Errorf("invalid schedule: %q", spec)
+	}
+	times := strings.SplitN(f[0], "-", 2)
+	if len(times) != 2 {
+		return w, fmt.Errorf("invalid schedule: %q", spec)
+	}
+	var err error
+	if w.from, err = parseClock(times[0]); err != nil {
+		return w, err
+	}
+	if w.to, err = parseClock(times[1]); err != nil {
+		return w, err
+	}
+	if w.freq, err = strconv.Atoi(f[1]); err != nil || w.freq < 1 {
+		return w, fmt.Errorf("invalid schedule frequency: %q", spec)
+	}
+	return w, nil
+}
+
+// parseSchedule parses the frequency schedule of an snmp config
+func parseSchedule(c *SnmpConfig) ([]freqWindow, error) {
+	if len(c.Schedule) > 0 && c.Align {
+		return nil, fmt.Errorf("align can't be used with a frequency schedule")
+	}
+	var list []freqWindow
+	for _, spec := range c.Schedule {
+		w, err := parseWindow(spec)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, w)
+	}
+	return list, nil
+}
+
+// contains returns true if the time is in the window
+func (w freqWindow) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if w.from <= w.to {
+		return w.days[t.Weekday()] && minute >= w.from && minute < w.to
+	}
+	// past midnight, the window started the day before
+	if minute >= w.from {
+		return w.days[t.Weekday()]
+	}
+	return minute < w.to && w.days[(t.Weekday()+6)%7]
+}
+
+// cycleFreq returns how long until the walk is polled again: the frequency of the
+// first window of the schedule containing the time, or otherwise the walk's own.
+// Walks of mib configs with their own frequency keep it
+func cycleFreq(c *SnmpConfig, crit snmp.Criteria, t time.Time) time.Duration {
+	freq := time.Duration(crit.Freq) * time.Second
+	if len(c.Schedule) == 0 || crit.Freq != c.Freq {
+		return freq
+	}
+	// the schedule was validated at startup
+	windows, _ := parseSchedule(c)
+	for _, w := range windows {
+		if w.contains(t) {
+			return time.Duration(w.freq) * time.Second
+		}
+	}
+	return freq
+}
@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestParseSchedule(t *testing.T) {
+	weekdays := [7]bool{false, true, true, true, true, true, false}
+	all := [7]bool{true, true, true, true, true, true, true}
+	tests := []struct {
+		schedule []string
+		align    bool
+		want     []freqWindow
+		fail     bool
+	}{
+		{schedule: nil, want: nil},
+		{schedule: []string{"08:00-18:00 30"}, want: []freqWindow{{days: all, from: 480, to: 1080, freq: 30}}},
+		{schedule: []string{"mon-fri 08:30-17:45 15"}, want: []freqWindow{{days: weekdays, from: 510, to: 1065, freq: 15}}},
+		{schedule: []string{"fri-mon 22:00-06:00 300"}, want: []freqWindow{{days: [7]bool{true, true, false, false, false, true, true}, from: 1320, to: 360, freq: 300}}},
+		{schedule: []string{"sat,sun 00:00-23:59 600", "MON 09:00-10:00 10"}, want: []freqWindow{
+			{days: [7]bool{true, false, false, false, false, false, true}, from: 0, to: 1439, freq: 600},
+			{days: [7]bool{false, true}, from: 540, to: 600, freq: 10},
+		}},
+		{schedule: []string{"08:00-18:00 30"}, align: true, fail: true},
+		{schedule: []string{"08:00-18:00"}, fail: true},
+		{schedule: []string{"mon-fri 08:00 30"}, fail: true},
+		{schedule: []string{"mon-xyz 08:00-18:00 30"}, fail: true},
+		{schedule: []string{"08:00-25:00 30"}, fail: true},
+		{schedule: []string{"08:00-18:00 0"}, fail: true},
+		{schedule: []string{"08:00-18:00 often"}, fail: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSchedule(&SnmpConfig{Schedule: tt.schedule, Align: tt.align})
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %+v", tt.schedule, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.schedule, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%q: got %+v, want %+v", tt.schedule, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%q: got %+v, want %+v", tt.schedule, got[i], tt.want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// GrafanaConfig specifies where to publish annotations
+type GrafanaConfig struct {
+	URL     string `gcfg:"url"`
+	Token   string `gcfg:"token"`
+	Tags    string `gcfg:"tags"`
+	Timeout int    `gcfg:"timeout"`
+}
+
+type annotation struct {
+	Time int64    `json:"time"`
+	Tags []string `json:"tags"`
+	Text string   `json:"text"`
+}
+
+// annotate posts an annotation to grafana, if configured
+func annotate(text string, tags ...string) error {
+	g := cfg.Grafana
+	if len(g.URL) == 0 {
+		return nil
+	}
+	a := annotation{
+		Time: time.Now().UnixNano() / int64(time.Millisecond),
+		Tags: append(strings.Fields(g.Tags), tags...),
+		Text: text,
+	}
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	url := strings.TrimSuffix(g.URL, "/") + "/api/annotations"
+	req, err := http.NewRequest("POST", url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(g.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+g.Token)
+	}
+	timeout := g.Timeout
+	if timeout <= 0 {
+		timeout = 10
+	}
+	c := http.Client{Timeout: time.Duration(timeout) * time.Second}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("grafana annotation failed: %s", resp.Status)
+	}
+	return nil
+}
+
+// annotator posts an annotation in the background, logging any failure
+func annotator(text string, tags ...string) {
+	go func() {
+		if err := annotate(text, tags...); err != nil {
+			log.Println("annotation error:", err)
+		}
+	}()
+}
+
+type grafanaTarget struct {
+	RefID string `json:"refId"`
+	Query string `json:"query"`
+	Raw   bool   `json:"rawQuery"`
+}
+
+type grafanaPanel struct {
+	ID         int             `json:"id"`
+	Title      string          `json:"title"`
+	Type       string          `json:"type"`
+	Datasource string          `json:"datasource"`
+	Span       int             `json:"span"`
+	Targets    []grafanaTarget `json:"targets"`
+}
+
+type grafanaRow struct {
+	Title  string         `json:"title"`
+	Panels []grafanaPanel `json:"panels"`
+}
+
+type grafanaDashboard struct {
+	Title         string       `json:"title"`
+	Tags          []string     `json:"tags"`
+	Refresh       string       `json:"refresh"`
+	SchemaVersion int          `json:"schemaVersion"`
+	Rows          []grafanaRow `json:"rows"`
+}
+
+// counterSuffixes are how the standard mibs name their counters, such as ifHCInOctets or ifInErrors
+var counterSuffixes = []string{"Octets", "Pkts", "Errors", "Discards", "UnknownProtos"}
+
+// panelColumns returns the columns a name in a mibs config is polled as: the
+// columns below it in the loaded mibs if it is a table, entry or group
+func panelColumns(name string) []string {
+	if oid, ok := mibOIDs[name]; ok {
+		if leaves := subtree(strings.Trim(oid, "."), mibOIDs); len(leaves) > 0 {
+			return leaves
+		}
+	}
+	return []string{name}
+}
+
+// isCounter returns true if the column is a counter, graphed as its rate:
+// it is one of the mibs config's deltas, or named like a counter
+func isCounter(m *MibConfig, column string) bool {
+	if hasField(m.Deltas, column) {
+		return true
+	}
+	for _, suffix := range counterSuffixes {
+		if strings.HasSuffix(column, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// panelQuery returns the query graphing the column of the host, as a rate for counters
+func panelQuery(m *MibConfig, column, measurement, host string) string {
+	sel := `mean("value")`
+	if isCounter(m, measurement) || isCounter(m, column) {
+		sel = `non_negative_derivative(mean("value"), 1s)`
+	}
+	return fmt.Sprintf(`SELECT %s FROM "%s" WHERE "host" = '%s' AND $timeFilter GROUP BY time($__interval), *`, sel, measurement, host)
+}
+
+// datasource returns the database the agent's data is written to
+func datasource(name string) string {
+	if c, ok := influxFor(name); ok {
+		return c.Database
+	}
+	return ""
+}
+
+// dashboard generates a grafana dashboard for the agents,
+// with a row per device or per mib group
+func dashboard(agents []snmpInfo, byMib bool) grafanaDashboard {
+	d := grafanaDashboard{
+		Title:         "influxsnmp",
+		Tags:          strings.Fields(cfg.Grafana.Tags),
+		Refresh:       "1m",
+		SchemaVersion: 12,
+	}
+	rows := make(map[string]*grafanaRow)
+	var order []string
+	id := 0
+	for _, a := range agents {
+		renames := pairs(a.Config.Rename)
+		var columns []string
+		for _, name := range strings.Fields(a.MIB.Name) {
+			columns = append(columns, panelColumns(name)...)
+		}
+		for _, profile := range a.Config.profiles() {
+			for _, column := range columns {
+				name := column
+				if renamed, ok := renames[column]; ok {
+					name = renamed
+				}
+				title := profile.Host
+				if byMib {
+					title = a.MIB.Name
+				}
+				row, ok := rows[title]
+				if !ok {
+					row = &grafanaRow{Title: title}
+					rows[title] = row
+					order = append(order, title)
+				}
+				id++
+				q := panelQuery(a.MIB, column, name, profile.Host)
+				row.Panels = append(row.Panels, grafanaPanel{
+					ID:         id,
+					Title:      fmt.Sprintf("%s %s", profile.Host, name),
+					Type:       "graph",
+					Datasource: datasource(a.Name),
+					Span:       6,
+					Targets:    []grafanaTarget{{RefID: "A", Query: q, Raw: true}},
+				})
+			}
+		}
+	}
+	for _, title := range order {
+		d.Rows = append(d.Rows, *rows[title])
+	}
+	return d
+}
+
+// grafanaDump writes the generated dashboard as json
+func grafanaDump(agents []snmpInfo, w io.Writer) error {
+	var byMib bool
+	switch grafana {
+	case "device":
+	case "mib":
+		byMib = true
+	default:
+		return fmt.Errorf("invalid grafana dashboard type: %s", grafana)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(dashboard(agents, byMib))
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDashboard(t *testing.T) {
+	setMibOIDs(map[string]string{
+		"ifXTable":     ".1.3.6.1.2.1.31.1.1",
+		"ifXEntry":     ".1.3.6.1.2.1.31.1.1.1",
+		"ifName":       ".1.3.6.1.2.1.31.1.1.1.1",
+		"ifHCInOctets": ".1.3.6.1.2.1.31.1.1.1.6",
+		"sysUpTime":    ".1.3.6.1.2.1.1.3",
+	})
+	agents := []snmpInfo{{
+		Name:   "core",
+		Config: &SnmpConfig{Host: "grafana1", Rename: "ifHCInOctets=in"},
+		MIB:    &MibConfig{Name: "ifXTable sysUpTime"},
+	}}
+	d := dashboard(agents, false)
+	if len(d.Rows) != 1 {
+		t.Fatalf("got %d rows, want 1", len(d.Rows))
+	}
+	want := map[string]string{
+		"grafana1 ifName":    `SELECT mean("value") FROM "ifName"`,
+		"grafana1 in":        `SELECT non_negative_derivative(mean("value"), 1s) FROM "in"`,
+		"grafana1 sysUpTime": `SELECT mean("value") FROM "sysUpTime"`,
+	}
+	panels := d.Rows[0].Panels
+	if len(panels) != len(want) {
+		t.Fatalf("got %d panels, want %d", len(panels), len(want))
+	}
+	for _, p := range panels {
+		prefix, ok := want[p.Title]
+		if !ok {
+			t.Errorf("unexpected panel %s", p.Title)
+			continue
+		}
+		if q := p.Targets[0].Query; !strings.HasPrefix(q, prefix) {
+			t.Errorf("%s: got %s, want %s", p.Title, q, prefix)
+		}
+	}
+}
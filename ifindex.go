@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// missRefresh is the least time between walks of a column for indexes it has no
+// name for, so an interface without one doesn't have the column walked every poll
+const missRefresh = 5 * time.Minute
+
+// ifTable maps an agent's interface indexes to names from a column such as
+// ifName or ifAlias. It is walked again whenever the agent reboots,
+// since not all devices keep their ifIndex values across reboots, and when
+// an index it has no name for is polled, for interfaces added since
+type ifTable struct {
+	sync.Mutex
+	profile snmp.Profile
+	config  *SnmpConfig
+	column  string
+	names   map[string]string
+	missed  time.Time // when the column was last walked for an index it had no name for
+}
+
+var ifTables = struct {
+	sync.Mutex
+	tables map[string]*ifTable
+}{tables: make(map[string]*ifTable)}
+
+// removeIfTables drops the host's tables of names
+func removeIfTables(host string) {
+	ifTables.Lock()
+	for key := range ifTables.tables {
+		if strings.HasPrefix(key, host+"/") {
+			delete(ifTables.tables, key)
+		}
+	}
+	ifTables.Unlock()
+}
+
+// ifTableFor returns the agent's table of names from the column,
+// loading it from the cache or walking it the first time it is used
+func ifTableFor(p snmp.Profile, c *SnmpConfig, column string) *ifTable {
+	key := p.Host + "/" + column
+	ifTables.Lock()
+	t, ok := ifTables.tables[key]
+	if !ok {
+		t = &ifTable{profile: p, config: c, column: column}
+		ifTables.tables[key] = t
+	}
+	ifTables.Unlock()
+	if ok {
+		return t
+	}
+	var names map[string]string
+	if loadMapping(&names, p.Host, column) {
+		t.names = names
+	}
+	if err := t.refresh(p); err != nil {
+		log.Printf("walk of %s for %s failed: %s\n", column, p.Host, err)
+	}
+	// the table is shared by the agents polling the host, so it is kept until the host isn't polled
+	onReboot(p.Host, "", func() {
+		if err := t.refresh(p); err != nil {
+			log.Printf("walk of %s for %s after reboot failed: %s\n", column, p.Host, err)
+		}
+	})
+	addCache(p.Host, t)
+	return t
+}
+
+// refresh walks the column, warning if indexes now refer to different names
+func (t *ifTable) refresh(p snmp.Profile) error {
+	names, err := walk(p, t.config, t.column)
+	if err != nil {
+		return err
+	}
+	t.Lock()
+	moved := 0
+	for index, name := range names {
+		if prior, ok := t.names[index]; ok && prior != name {
+			moved++
+		}
+	}
+	t.names = names
+	t.Unlock()
+	if moved > 0 {
+		msg := fmt.Sprintf("%s: %d interfaces changed index", p.Host, moved)
+		log.Println(msg)
+		annotator(msg, "ifindex", p.Host)
+	}
+	return saveMapping(names, p.Host, t.column)
+}
+
+func (t *ifTable) name(index string) (string, bool) {
+	t.Lock()
+	name, ok := t.names[index]
+	t.Unlock()
+	return name, ok
+}
+
+// lookup returns the name of the index, walking the column again if it has
+// none and the column wasn't walked for another missing index recently
+func (t *ifTable) lookup(index string) (string, bool) {
+	if name, ok := t.name(index); ok {
+		return name, true
+	}
+	now := time.Now()
+	t.Lock()
+	retry := now.Sub(t.missed) >= missRefresh
+	if retry {
+		t.missed = now
+	}
+	t.Unlock()
+	if !retry {
+		return "", false
+	}
+	if err := t.refresh(t.profile); err != nil {
+		log.Printf("walk of %s for %s for index %s failed: %s\n", t.column, t.profile.Host, index, err)
+		return "", false
+	}
+	return t.name(index)
+}
+
+// KeySender replaces the index tag of interface rows with the name
+// from the column, so each series follows its interface rather than
+// an index that may be reassigned when the device reboots
+func KeySender(sender snmp.Sender, p snmp.Profile, c *SnmpConfig, column string) snmp.Sender {
+	if len(column) == 0 {
+		return sender
+	}
+	t := ifTableFor(p, c, column)
+	return func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+		index, ok := tags[indexTag]
+		if !ok {
+			return sender(name, tags, value, ts)
+		}
+		ifName, ok := t.lookup(index)
+		if !ok {
+			return sender(name, tags, value, ts)
+		}
+		k := make(map[string]string, len(tags))
+		for key, v := range tags {
+			if key != indexTag {
+				k[key] = v
+			}
+		}
+		k[column] = ifName
+		return sender(name, k, value, ts)
+	}
+}
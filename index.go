@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// indexPart is a named component of a composite table index
+type indexPart struct {
+	name string
+	kind string // int, ip, mac, string
+}
+
+// indexParts parses an index structure, e.g., "addr:ip port:int"
+func indexParts(spec string) ([]indexPart, error) {
+	var parts []indexPart
+	for _, f := range strings.Fields(spec) {
+		p := strings.Split(f, ":")
+		if len(p) != 2 {
+			return nil, fmt.Errorf("invalid index part: %q", f)
+		}
+		switch p[1] {
+		case "int", "ip", "mac", "string":
+		default:
+			return nil, fmt.Errorf("invalid index type: %q", f)
+		}
+		parts = append(parts, indexPart{p[0], p[1]})
+	}
+	return parts, nil
+}
+
+// octets converts the sub-identifiers to bytes
+func octets(subs []string) ([]byte, error) {
+	b := make([]byte, len(subs))
+	for i, s := range subs {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > 255 {
+			return nil, fmt.Errorf("invalid octet: %q", s)
+		}
+		b[i] = byte(n)
+	}
+	return b, nil
+}
+
+// splitIndex splits a dotted index suffix into its named parts
+func splitIndex(parts []indexPart, index string) (map[string]string, error) {
+	subs := strings.Split(index, ".")
+	tags := make(map[string]string, len(parts))
+	for _, p := range parts {
+		var n int
+		switch p.kind {
+		case "int":
+			n = 1
+		case "ip":
+			n = 4
+		case "mac":
+			n = 6
+		case "string":
+			if len(subs) == 0 {
+				return nil, fmt.Errorf("index %s too short for %s", index, p.name)
+			}
+			size, err := strconv.Atoi(subs[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string length in index %s", index)
+			}
+			subs = subs[1:]
+			n = size
+		}
+		if len(subs) < n {
+			return nil, fmt.Errorf("index %s too short for %s", index, p.name)
+		}
+		val := subs[:n]
+		subs = subs[n:]
+		switch p.kind {
+		case "int":
+			tags[p.name] = val[0]
+		case "ip":
+			tags[p.name] = strings.Join(val, ".")
+		case "mac":
+			b, err := octets(val)
+			if err != nil {
+				return nil, err
+			}
+			hex := make([]string, len(b))
+			for i, x := range b {
+				hex[i] = fmt.Sprintf("%02x", x)
+			}
+			tags[p.name] = strings.Join(hex, ":")
+		case "string":
+			b, err := octets(val)
+			if err != nil {
+				return nil, err
+			}
+			tags[p.name] = string(b)
+		}
+	}
+	return tags, nil
+}
+
+// IndexSender splits composite table indexes into separate tags
+func IndexSender(sender snmp.Sender, spec string) snmp.Sender {
+	if len(spec) == 0 {
+		return sender
+	}
+	parts, err := indexParts(spec)
+	if err != nil {
+		log.Println(err)
+		return sender
+	}
+	return func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+		index, ok := tags[indexTag]
+		if !ok {
+			return sender(name, tags, value, ts)
+		}
+		split, err := splitIndex(parts, index)
+		if err != nil {
+			return err
+		}
+		t := make(map[string]string, len(tags)+len(split))
+		for k, v := range tags {
+			if k != indexTag {
+				t[k] = v
+			}
+		}
+		for k, v := range split {
+			t[k] = v
+		}
+		return sender(name, t, value, ts)
+	}
+}
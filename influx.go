@@ -2,6 +2,16 @@ package main
 
 import (
 	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	client "github.com/influxdata/influxdb/client/v2"
@@ -12,8 +22,11 @@ var (
 	retry = time.Second * 30
 )
 
-// Sender is a function that accepts the components of a datapoint
-type Sender func(string, map[string]string, map[string]interface{}, time.Time) error
+// routedPoint is a point and the retention policy it is saved in
+type routedPoint struct {
+	retention string
+	pt        *client.Point
+}
 
 const (
 	// DefaultBatchSize is the default number points to batch before sending
@@ -49,13 +62,70 @@ func dbCheck(conn client.Client, database string) error {
 	return fmt.Errorf("database %s does not exist", database)
 }
 
-// NewSender returns a function that will accept datapoints to send to influxdb
+func init() {
+	RegisterSender("http", influxFactory)
+	RegisterSender("https", influxFactory)
+	RegisterSender("udp", influxFactory)
+	RegisterSender("unix", influxFactory)
+}
+
+// influxFactory creates an influxdb sender from its config
+func influxFactory(c *InfluxConfig) (Sender, error) {
+	var conf interface{}
+	verify := c.Verify
+	if strings.HasPrefix(c.URL, "udp://") {
+		conf = client.UDPConfig{Addr: strings.TrimPrefix(c.URL, "udp://")}
+		// udp writes can't be read back
+		verify = 0
+	} else {
+		conf = c.httpConfig()
+		list, err := rollups(c.Downsample)
+		if err != nil {
+			return nil, err
+		}
+		if len(list) > 0 {
+			go setupDownsample(conf.(client.HTTPConfig), c, list)
+		}
+	}
+	return NewSender(conf, c.batchConfig(), c.BatchSize, c.QueueSize, c.Flush, c.Writers, c.Ordered, c.FailSoft, verify, c.Gzip, errFn)
+}
+
+// influxSender batches datapoints to write to influxdb.
+// Each writer has its own queue and batches, so writes can be in flight concurrently
+type influxSender struct {
+	conn      client.Client
+	post      func(client.BatchPoints) error // writes a batch
+	batch     client.BatchPointsConfig
+	batchSize int
+	ordered   bool
+	verify    float64 // fraction of batches to read back
+	next      uint32
+	pending   int64 // points queued or batched but not yet written
+	pts       []chan routedPoint
+	flushReq  []chan chan error
+	done      chan struct{}
+	errFunc   func(error)
+
+	sync.Mutex
+	stats SenderStats
+}
+
+// NewSender returns a sender that batches datapoints to send to influxdb.
+// If failSoft is set, a server that can't be reached is not an error;
+// points are queued and the writes retried until it can be.
+// Verify is the fraction of batches to read back after they are written,
+// and compress gzips http writes
 func NewSender(
 	config interface{},
 	batch client.BatchPointsConfig,
 	batchSize int,
 	queueSize int,
 	flush int,
+	writers int,
+	ordered bool,
+	failSoft bool,
+	verify float64,
+	compress bool,
 	errFunc func(error),
 ) (Sender, error) {
 	if batchSize <= 0 {
@@ -67,9 +137,14 @@ func NewSender(
 	if flush <= 0 {
 		flush = DefaultFlush
 	}
+	if writers <= 0 {
+		writers = 1
+	}
 
 	var conn client.Client
 	var err error
+	var unreachable error
+	var post func(client.BatchPoints) error
 
 	switch conf := config.(type) {
 	case client.HTTPConfig:
@@ -77,13 +152,19 @@ func NewSender(
 		if err != nil {
 			return nil, errors.Wrap(err, "error creating HTTPClient")
 		}
+		if post, err = httpWriter(conf, compress); err != nil {
+			return nil, errors.Wrap(err, "error creating HTTPClient")
+		}
 
 		_, _, err = conn.Ping(conf.Timeout)
 		if err != nil {
-			return nil, fmt.Errorf("cannot ping influxdb server: %s", conf.Addr)
-		}
-
-		if err := dbCheck(conn, batch.Database); err != nil {
+			unreachable = fmt.Errorf("cannot ping influxdb server: %s", conf.Addr)
+			if !failSoft {
+				return nil, unreachable
+			}
+			log.Printf("%s, queueing points until it is available\n", unreachable)
+		} else if err := dbCheck(conn, batch.Database); err != nil {
+			// a missing database won't be fixed by waiting
 			return nil, errors.Wrapf(err, "check for database %s failed", batch.Database)
 		}
 	case client.UDPConfig:
@@ -91,53 +172,346 @@ func NewSender(
 		if err != nil {
 			return nil, errors.Wrap(err, "error creating UDPClient")
 		}
+		post = conn.Write
 	}
 
-	pts := make(chan *client.Point, queueSize)
+	// validate the batch config
+	if _, err := client.NewBatchPoints(batch); err != nil {
+		return nil, errors.Wrap(err, "batchpoints error")
+	}
 
-	bp, err := client.NewBatchPoints(batch)
+	s := &influxSender{
+		conn:      conn,
+		post:      post,
+		batch:     batch,
+		batchSize: batchSize,
+		ordered:   ordered,
+		verify:    verify,
+		done:      make(chan struct{}),
+		errFunc:   errFunc,
+	}
+	if unreachable != nil {
+		s.stats.LastError = unreachable
+		s.stats.LastTime = time.Now()
+		go s.recovered(config.(client.HTTPConfig).Timeout)
+	}
+	for i := 0; i < writers; i++ {
+		pts := make(chan routedPoint, queueSize/writers+1)
+		flushReq := make(chan chan error)
+		s.pts = append(s.pts, pts)
+		s.flushReq = append(s.flushReq, flushReq)
+		go s.run(pts, flushReq, time.Duration(flush)*time.Second)
+	}
+	return s, nil
+}
+
+// recovered waits for a server that was unreachable at startup to answer,
+// then checks the database that couldn't be checked before
+func (s *influxSender) recovered(timeout time.Duration) {
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-time.After(retry):
+		}
+		if _, _, err := s.conn.Ping(timeout); err != nil {
+			continue
+		}
+		if err := dbCheck(s.conn, s.batch.Database); err != nil {
+			err = errors.Wrapf(err, "check for database %s failed", s.batch.Database)
+			log.Println(err)
+			s.Lock()
+			s.stats.LastError = err
+			s.stats.LastTime = time.Now()
+			s.Unlock()
+			return
+		}
+		log.Printf("influxdb database %s is available\n", s.batch.Database)
+		return
+	}
+}
+
+// droppedCount extracts the number of dropped points from a partial write error
+var droppedCount = regexp.MustCompile(`dropped=(\d+)`)
+
+// unparsable extracts the line influxdb could not parse
+var unparsable = regexp.MustCompile(`unable to parse '((?:[^'\\]|\\.)*)'`)
+
+// subset returns a batch with the same settings as bp containing the points
+func subset(bp client.BatchPoints, pts []*client.Point) client.BatchPoints {
+	b, _ := client.NewBatchPoints(client.BatchPointsConfig{
+		Precision:        bp.Precision(),
+		Database:         bp.Database(),
+		RetentionPolicy:  bp.RetentionPolicy(),
+		WriteConsistency: bp.WriteConsistency(),
+	})
+	b.AddPoints(pts)
+	return b
+}
+
+// write error classes
+const (
+	errNetwork  = "network"  // connection failures, retried
+	errServer   = "server"   // server side failures, retried
+	errAuth     = "auth"     // authentication failures, dropped
+	errConfig   = "config"   // missing database or retention policy, dropped
+	errSchema   = "schema"   // unparsable points or field type conflicts, dropped
+	errClient   = "client"   // other requests the server refused, dropped
+	errSize     = "size"     // request too large, split
+	errThrottle = "throttle" // rate limited or overloaded, retried after the time asked
+)
+
+// classify returns the class of the write error and whether to retry it
+func classify(err error) (string, bool) {
+	if _, ok := err.(*throttleError); ok {
+		return errThrottle, true
+	}
+	if _, ok := err.(net.Error); ok {
+		return errNetwork, true
+	}
+	status, _ := err.(*statusError)
+	if status != nil && status.code == http.StatusRequestEntityTooLarge {
+		return errSize, false
+	}
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "too large"):
+		return errSize, false
+	case strings.Contains(msg, "authorization failed"),
+		strings.Contains(msg, "unable to parse authentication"),
+		strings.Contains(msg, "user not found"),
+		strings.Contains(msg, "not authorized"):
+		return errAuth, false
+	case strings.Contains(msg, "database not found"),
+		strings.Contains(msg, "retention policy not found"):
+		return errConfig, false
+	case strings.Contains(msg, "partial write"),
+		strings.Contains(msg, "unable to parse"),
+		strings.Contains(msg, "field type conflict"):
+		return errSchema, false
+	}
+	if status != nil && status.code >= 400 && status.code < 500 {
+		// a gateway may answer with its own page rather than an influxdb error
+		if status.code == http.StatusUnauthorized || status.code == http.StatusForbidden {
+			return errAuth, false
+		}
+		return errClient, false
+	}
+	return errServer, true
+}
+
+func (s *influxSender) record(sent, dropped int, err error) {
+	atomic.AddInt64(&s.pending, -int64(sent+dropped))
+	s.Lock()
+	s.stats.Sent += int64(sent)
+	s.stats.Dropped += int64(dropped)
 	if err != nil {
-		return nil, errors.Wrap(err, "batchpoints error")
+		class, _ := classify(err)
+		if s.stats.Classes == nil {
+			s.stats.Classes = make(map[string]int64)
+		}
+		s.stats.Classes[class]++
+		s.stats.Errors++
+		s.stats.LastError = err
+		s.stats.LastTime = time.Now()
+	}
+	s.Unlock()
+	if err != nil && s.errFunc != nil {
+		s.errFunc(err)
 	}
+}
 
-	go func() {
-		delay := time.Duration(flush) * time.Second
-		tick := time.Tick(delay)
-		count := 0
-		for {
-			select {
-			case p := <-pts:
-				bp.AddPoint(p)
-				count++
-				if count < batchSize {
-					continue
-				}
-			case <-tick:
-				if len(bp.Points()) == 0 {
-					continue
+// write saves the batch, retrying transient failures until it succeeds.
+// Points influxdb rejects are dropped so they can't block the rest.
+// Authentication, configuration and other errors the server refuses drop the batch
+func (s *influxSender) write(bp client.BatchPoints) {
+	pts := bp.Points()
+	if len(pts) == 0 {
+		return
+	}
+	for {
+		err := s.post(bp)
+		if err == nil {
+			s.record(len(pts), 0, nil)
+			if s.verify > 0 && rand.Float64() < s.verify {
+				go s.readBack(bp)
+			}
+			return
+		}
+		msg := err.Error()
+		class, retryable := classify(err)
+		switch {
+		case class == errAuth || class == errConfig || class == errClient:
+			s.record(0, len(pts), err)
+			log.Printf("ALERT: influxdb write to %s failed, dropped %d points: %s\n", bp.Database(), len(pts), err)
+			annotator("influxdb write failed: "+msg, "alert", class)
+			return
+		case strings.Contains(msg, "partial write"):
+			// the valid points were saved
+			dropped := 0
+			if m := droppedCount.FindStringSubmatch(msg); m != nil {
+				dropped, _ = strconv.Atoi(m[1])
+			}
+			s.record(len(pts)-dropped, dropped, err)
+			return
+		case class == errSize:
+			s.record(0, 0, err)
+			if len(pts) == 1 {
+				s.record(0, 1, nil)
+				return
+			}
+			half := len(pts) / 2
+			s.write(subset(bp, pts[:half]))
+			s.write(subset(bp, pts[half:]))
+			return
+		case strings.Contains(msg, "unable to parse"):
+			s.record(0, 0, err)
+			bad := make(map[string]bool)
+			for _, m := range unparsable.FindAllStringSubmatch(msg, -1) {
+				bad[m[1]] = true
+			}
+			keep := make([]*client.Point, 0, len(pts))
+			for _, pt := range pts {
+				if !bad[pt.PrecisionString(bp.Precision())] && !bad[pt.String()] {
+					keep = append(keep, pt)
 				}
 			}
-			for {
-				if err := conn.Write(bp); err != nil {
-					if errFunc != nil {
-						errFunc(err)
-					}
-					time.Sleep(retry)
-					continue
+			if len(keep) == len(pts) {
+				// the offending point can't be identified, so isolate it
+				if len(pts) == 1 {
+					s.record(0, 1, nil)
+					return
 				}
-				bp, _ = client.NewBatchPoints(batch)
-				count = 0
-				break
+				half := len(pts) / 2
+				s.write(subset(bp, pts[:half]))
+				s.write(subset(bp, pts[half:]))
+				return
 			}
+			s.record(0, len(pts)-len(keep), nil)
+			s.write(subset(bp, keep))
+			return
+		}
+		s.record(0, 0, err)
+		if !retryable {
+			s.record(0, len(pts), nil)
+			return
 		}
-	}()
+		if t, ok := err.(*throttleError); ok {
+			s.throttled(t.wait)
+			continue
+		}
+		time.Sleep(retry)
+	}
+}
 
-	return func(key string, tags map[string]string, fields map[string]interface{}, ts time.Time) error {
-		pt, err := client.NewPoint(key, tags, fields, ts)
-		if err != nil {
-			return err
+func (s *influxSender) run(queue chan routedPoint, flushReq chan chan error, delay time.Duration) {
+	batches := make(map[string]client.BatchPoints)
+	tick := time.NewTicker(delay)
+	defer tick.Stop()
+	add := func(p routedPoint) {
+		bp, ok := batches[p.retention]
+		if !ok {
+			bp, _ = client.NewBatchPoints(s.batch)
+			if len(p.retention) > 0 {
+				bp.SetRetentionPolicy(p.retention)
+			}
+			batches[p.retention] = bp
+		}
+		bp.AddPoint(p.pt)
+	}
+	count := 0
+	for {
+		var reply chan error
+		select {
+		case p := <-queue:
+			add(p)
+			count++
+			if count < s.batchSize {
+				continue
+			}
+		case <-tick.C:
+			if count == 0 {
+				continue
+			}
+		case reply = <-flushReq:
+			// drain what is already queued
+			for n := len(queue); n > 0; n-- {
+				add(<-queue)
+			}
+		case <-s.done:
+			return
+		}
+		for rp, bp := range batches {
+			s.write(bp)
+			delete(batches, rp)
 		}
-		pts <- pt
-		return nil
-	}, nil
+		count = 0
+		if reply != nil {
+			reply <- nil
+		}
+	}
+}
+
+// Send queues the datapoint to be written
+func (s *influxSender) Send(retention, key string, tags map[string]string, fields map[string]interface{}, ts time.Time) error {
+	pt, err := client.NewPoint(key, tags, fields, ts)
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&s.pending, 1)
+	s.pts[s.writer(key, tags)] <- routedPoint{retention, pt}
+	return nil
+}
+
+// writer picks the queue for a point. Ordered senders always
+// use the same writer for a series, so its points are written in order
+func (s *influxSender) writer(key string, tags map[string]string) int {
+	if len(s.pts) == 1 {
+		return 0
+	}
+	if s.ordered {
+		h := fnv.New32a()
+		h.Write([]byte(seriesKey(key, tags)))
+		return int(h.Sum32() % uint32(len(s.pts)))
+	}
+	return int(atomic.AddUint32(&s.next, 1) % uint32(len(s.pts)))
+}
+
+// Flush writes all queued datapoints
+func (s *influxSender) Flush() error {
+	replies := make([]chan error, len(s.flushReq))
+	for i, req := range s.flushReq {
+		replies[i] = make(chan error)
+		req <- replies[i]
+	}
+	var err error
+	for _, reply := range replies {
+		if rerr := <-reply; err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// Close flushes the queue and closes the connection
+func (s *influxSender) Close() error {
+	err := s.Flush()
+	close(s.done)
+	if cerr := s.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Stats returns the count of datapoints sent and write errors
+func (s *influxSender) Stats() SenderStats {
+	s.Lock()
+	stats := s.stats
+	stats.Classes = make(map[string]int64, len(s.stats.Classes))
+	for k, v := range s.stats.Classes {
+		stats.Classes[k] = v
+	}
+	s.Unlock()
+	stats.Queued = int(atomic.LoadInt64(&s.pending))
+	return stats
 }
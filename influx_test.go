@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	client "github.com/influxdata/influxdb/client/v2"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err   error
+		class string
+		retry bool
+	}{
+		{&throttleError{retry, "429 Too Many Requests"}, errThrottle, true},
+		{&statusError{413, "413 Request Entity Too Large", ""}, errSize, false},
+		{&statusError{401, "401 Unauthorized", "<html>Unauthorized</html>"}, errAuth, false},
+		{&statusError{403, "403 Forbidden", ""}, errAuth, false},
+		{&statusError{404, "404 Not Found", `{"error":"database not found: \"snmp\""}`}, errConfig, false},
+		{&statusError{404, "404 Not Found", "<html>no such route</html>"}, errClient, false},
+		{&statusError{400, "400 Bad Request", `{"error":"partial write: field type conflict dropped=2"}`}, errSchema, false},
+		{&statusError{400, "400 Bad Request", "bad gateway request"}, errClient, false},
+		{&statusError{500, "500 Internal Server Error", "timeout"}, errServer, true},
+		{&statusError{502, "502 Bad Gateway", ""}, errServer, true},
+		{errors.New("database not found"), errConfig, false},
+		{errors.New("connection reset"), errServer, true},
+	}
+	for _, tt := range tests {
+		class, retry := classify(tt.err)
+		if class != tt.class || retry != tt.retry {
+			t.Errorf("%v: got %s (%t), want %s (%t)", tt.err, class, retry, tt.class, tt.retry)
+		}
+	}
+}
+
+// testBatch returns a batch of points, named bad where listed
+func testBatch(t *testing.T, n int, bad ...int) client.BatchPoints {
+	bp, err := client.NewBatchPoints(client.BatchPointsConfig{Database: "snmp", Precision: "s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		name := "ifXTable"
+		for _, b := range bad {
+			if i == b {
+				name = "bad"
+			}
+		}
+		pt, err := client.NewPoint(name, map[string]string{"index": strconv.Itoa(i)}, map[string]interface{}{"value": i}, time.Unix(1700000000, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		bp.AddPoint(pt)
+	}
+	return bp
+}
+
+func TestWrite(t *testing.T) {
+	// badLine is the error influxdb returns for the first bad point, if any
+	badLine := func(bp client.BatchPoints, named bool) error {
+		for _, pt := range bp.Points() {
+			if pt.Name() != "bad" {
+				continue
+			}
+			line := "bad"
+			if named {
+				line = pt.PrecisionString(bp.Precision())
+			}
+			return &statusError{400, "400 Bad Request", fmt.Sprintf(`{"error":"unable to parse '%s': invalid field"}`, line)}
+		}
+		return nil
+	}
+	tests := []struct {
+		name          string
+		bp            client.BatchPoints
+		post          func(client.BatchPoints) error
+		sent, dropped int64
+	}{
+		{"ok", testBatch(t, 5), func(client.BatchPoints) error { return nil }, 5, 0},
+		{"partial write", testBatch(t, 5), func(client.BatchPoints) error {
+			return &statusError{400, "400 Bad Request", `{"error":"partial write: field type conflict dropped=2"}`}
+		}, 3, 2},
+		{"too large", testBatch(t, 5), func(bp client.BatchPoints) error {
+			if len(bp.Points()) > 2 {
+				return &statusError{413, "413 Request Entity Too Large", ""}
+			}
+			return nil
+		}, 5, 0},
+		{"always too large", testBatch(t, 5), func(client.BatchPoints) error {
+			return &statusError{413, "413 Request Entity Too Large", ""}
+		}, 0, 5},
+		{"unable to parse", testBatch(t, 5, 3), func(bp client.BatchPoints) error { return badLine(bp, true) }, 4, 1},
+		{"unidentified line", testBatch(t, 5, 1, 3), func(bp client.BatchPoints) error { return badLine(bp, false) }, 3, 2},
+	}
+	for _, tt := range tests {
+		s := &influxSender{post: tt.post}
+		done := make(chan struct{})
+		go func() {
+			s.write(tt.bp)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: write did not return", tt.name)
+		}
+		if s.stats.Sent != tt.sent || s.stats.Dropped != tt.dropped {
+			t.Errorf("%s: sent %d and dropped %d, want %d and %d", tt.name, s.stats.Sent, s.stats.Dropped, tt.sent, tt.dropped)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/influxdata/influxdb/models"
+)
+
+// InputConfig accepts points in influx line protocol from other programs,
+// to be written with the collector's queueing and retries
+type InputConfig struct {
+	// Listen is where to read points from: unix:///path, tcp://host:port or fifo:///path
+	Listen string `gcfg:"listen"`
+	// Influx is the influx config to write the points with, if not the default
+	Influx string `gcfg:"influx"`
+	// Retention is the retention policy to save the points in, if not the sender default
+	Retention string `gcfg:"retention"`
+	// Precision of the timestamps: ns (default), us, ms, s, m or h
+	Precision string `gcfg:"precision"`
+	// Token must be the first line sent on each tcp connection, if set
+	Token string `gcfg:"token"`
+}
+
+// linePrecision maps the batch precisions to those of the line protocol parser
+var linePrecision = map[string]string{"ns": "n", "us": "u", "ms": "ms", "s": "s", "m": "m", "h": "h"}
+
+// inputScheme splits the listen url into its scheme and address
+func inputScheme(listen string) (string, string, error) {
+	i := strings.Index(listen, "://")
+	if i < 0 {
+		return "", "", fmt.Errorf("invalid input url: %s", listen)
+	}
+	scheme, addr := listen[:i], listen[i+3:]
+	switch scheme {
+	case "unix", "tcp", "fifo":
+		return scheme, addr, nil
+	}
+	return "", "", fmt.Errorf("unsupported input url: %s", listen)
+}
+
+// checkInput verifies the input's url and that it has an influx config to write to
+func checkInput() error {
+	c := cfg.Input
+	if len(c.Listen) == 0 {
+		return nil
+	}
+	scheme, addr, err := inputScheme(c.Listen)
+	if err != nil {
+		return err
+	}
+	if scheme == "tcp" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("input: %s", err)
+		}
+		if ip := net.ParseIP(host); len(c.Token) == 0 && (len(host) == 0 || (ip != nil && ip.IsUnspecified())) {
+			return fmt.Errorf("input: %s listens on every address, it needs a bind address or a token", c.Listen)
+		}
+	}
+	if _, ok := cfg.Influx[c.influxName()]; !ok {
+		return fmt.Errorf("input: no influx config named %s", c.influxName())
+	}
+	if _, ok := precisions[c.Precision]; !ok && len(c.Precision) > 0 {
+		return fmt.Errorf("input: invalid precision: %s", c.Precision)
+	}
+	return nil
+}
+
+// influxName returns the name of the influx config the input writes with
+func (c InputConfig) influxName() string {
+	if len(c.Influx) > 0 {
+		return c.Influx
+	}
+	return "*"
+}
+
+// readPoints sends each line of points read, logging the lines that cannot be parsed.
+// If a token is given, it must be the first line
+func readPoints(r io.Reader, from string, send SendFunc, c InputConfig, token string) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	if len(token) > 0 {
+		if !scanner.Scan() || subtle.ConstantTimeCompare(bytes.TrimSpace(scanner.Bytes()), []byte(token)) != 1 {
+			log.Printf("input from %s refused: invalid token\n", from)
+			return
+		}
+	}
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		pts, err := models.ParsePointsWithPrecision(line, time.Now(), linePrecision[c.Precision])
+		if err != nil {
+			log.Printf("input error from %s: %s\n", from, err)
+			continue
+		}
+		for _, pt := range pts {
+			fields, err := pt.Fields()
+			if err != nil {
+				log.Printf("input error from %s: %s\n", from, err)
+				continue
+			}
+			if err := send(string(pt.Name()), pt.Tags().Map(), fields, pt.Time()); err != nil {
+				log.Printf("input send error from %s: %s\n", from, err)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("input error from %s: %s\n", from, err)
+	}
+}
+
+// readFifo reads points from the named pipe, creating it if needed.
+// It is reopened whenever the last writer closes it
+func readFifo(path string, send SendFunc, c InputConfig) {
+	fi, err := os.Lstat(path)
+	switch {
+	case os.IsNotExist(err):
+		if err := syscall.Mkfifo(path, 0620); err != nil {
+			log.Printf("input cannot create %s: %s\n", path, err)
+			return
+		}
+	case err != nil:
+		log.Printf("input cannot use %s: %s\n", path, err)
+		return
+	case fi.Mode()&os.ModeNamedPipe == 0:
+		log.Printf("input cannot use %s: it is not a named pipe\n", path)
+		return
+	}
+	for !stopping() {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Printf("input cannot open %s: %s\n", path, err)
+			time.Sleep(retry)
+			continue
+		}
+		readPoints(f, path, send, c, "")
+		f.Close()
+	}
+}
+
+// inputSender returns the sender of input points, which are checked
+// and tagged the same way as polled ones
+func inputSender(sender Sender, c InputConfig) SendFunc {
+	send := sendFunc(sender, c.Retention)
+	return ValidSender(ConformSender(TenantSender(send, senderTenant(c.influxName()))))
+}
+
+// inputListener accepts points from other programs and writes them with the sender
+func inputListener(sender Sender) {
+	c := cfg.Input
+	scheme, addr, err := inputScheme(c.Listen)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	send := inputSender(sender, c)
+	if scheme == "fifo" {
+		readFifo(addr, send, c)
+		return
+	}
+	if scheme == "unix" {
+		// remove the socket left by a previous run, but nothing else
+		if fi, err := os.Lstat(addr); err == nil {
+			if fi.Mode()&os.ModeSocket == 0 {
+				log.Printf("input cannot listen on %s: it exists and is not a socket\n", c.Listen)
+				return
+			}
+			os.Remove(addr)
+		}
+	}
+	l, err := net.Listen(scheme, addr)
+	if err != nil {
+		log.Printf("input cannot listen on %s: %s\n", c.Listen, err)
+		return
+	}
+	var token string
+	if scheme == "tcp" {
+		token = c.Token
+	}
+	log.Printf("accepting points on %s\n", c.Listen)
+	for !stopping() {
+		conn, err := l.Accept()
+		if err != nil {
+			log.Printf("input error: %s\n", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			readPoints(conn, conn.RemoteAddr().String(), send, c, token)
+		}(conn)
+	}
+	l.Close()
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// inventoryOID is the ENTITY-MIB table of physical components
+const inventoryOID = "entPhysicalEntry"
+
+// inventory is the latest hardware inventory of each host
+var inventory = newSnapshot("inventory")
+
+// inventoryPoller periodically walks the ENTITY-MIB physical table
+func inventoryPoller(send SendFunc, p snmp.Profile, c *SnmpConfig, stop chan struct{}) {
+	inventory.poll(send, p, c, inventoryOID, c.Inventory, stop)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// indexTag is the tag holding the index of a table row
+const indexTag = "index"
+
+// defaultJoinRefresh is how many seconds between walks of the join tables,
+// which change far less often than the rows they name
+const defaultJoinRefresh = 600
+
+// join enriches rows with a name looked up through an indirection table.
+// The key column shares the polled table's index, and its value
+// is used as the index into the lookup column. The result is saved as the tag
+type join struct {
+	key    string // column indexed like the polled table
+	column string // column of the lookup table
+	tag    string // tag to add to the polled row
+
+	profile snmp.Profile // agent the tables are walked on
+	config  *SnmpConfig  // snmp config the agent is polled with
+
+	sync.Mutex
+	keys  map[string]string // polled table index to key
+	names map[string]string // lookup table index to name
+}
+
+// joins parses the mib config's join specs, e.g., "cbQosConfigIndex cbQosPolicyMapName policy"
+func joins(m *MibConfig) ([]*join, error) {
+	var list []*join
+	for _, spec := range m.Joins {
+		f := strings.Fields(spec)
+		if len(f) != 3 {
+			return nil, fmt.Errorf("invalid join: %q", spec)
+		}
+		list = append(list, &join{
+			key:    f[0],
+			column: f[1],
+			tag:    f[2],
+			keys:   make(map[string]string),
+			names:  make(map[string]string),
+		})
+	}
+	return list, nil
+}
+
+// walk returns the values of a column by index
+func walk(p snmp.Profile, c *SnmpConfig, column string) (map[string]string, error) {
+	values := make(map[string]string)
+	var m sync.Mutex
+	sender := func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+		m.Lock()
+		values[tags[indexTag]] = fmt.Sprint(value)
+		m.Unlock()
+		return nil
+	}
+	err := sampleAgent(p, c, snmp.Criteria{OID: column}, sender)
+	return values, err
+}
+
+// refresh walks the key and lookup columns
+func (j *join) refresh(p snmp.Profile) error {
+	keys, err := walk(p, j.config, j.key)
+	if err != nil {
+		return err
+	}
+	names, err := walk(p, j.config, j.column)
+	if err != nil {
+		return err
+	}
+	j.Lock()
+	j.keys = keys
+	j.names = names
+	j.Unlock()
+	return j.save(p.Host)
+}
+
+// joinCache is a join's tables as saved between runs
+type joinCache struct {
+	Keys  map[string]string `json:"keys"`
+	Names map[string]string `json:"names"`
+}
+
+// save caches the tables so they can be used as soon as the collector restarts
+func (j *join) save(host string) error {
+	j.Lock()
+	c := joinCache{j.keys, j.names}
+	err := saveMapping(c, host, j.key, j.column)
+	j.Unlock()
+	return err
+}
+
+// load uses the tables cached by an earlier run until they are walked again
+func (j *join) load(host string) {
+	var c joinCache
+	if !loadMapping(&c, host, j.key, j.column) {
+		return
+	}
+	j.Lock()
+	if len(j.keys) == 0 && c.Keys != nil && c.Names != nil {
+		j.keys = c.Keys
+		j.names = c.Names
+	}
+	j.Unlock()
+}
+
+// refresher updates the lookup names every refresh interval until stop is closed
+func (j *join) refresher(p snmp.Profile, refresh int, stop chan struct{}) {
+	for !stopping() && !stopped(stop) {
+		if err := j.refresh(p); err != nil {
+			log.Printf("join lookup of %s/%s for %s failed: %s\n", j.key, j.column, p.Host, err)
+		}
+		pause(stop, time.Duration(refresh)*time.Second)
+	}
+}
+
+// joinRefresh returns how many seconds between walks of the mib config's join tables,
+// which are walked no more often than the table they name
+func joinRefresh(m *MibConfig, freq int) int {
+	refresh := m.JoinRefresh
+	if refresh <= 0 {
+		refresh = defaultJoinRefresh
+	}
+	if refresh < freq {
+		refresh = freq
+	}
+	return refresh
+}
+
+// lookup returns the tag value for the polled row
+func (j *join) lookup(tags map[string]string) (string, bool) {
+	j.Lock()
+	defer j.Unlock()
+	key, ok := j.keys[tags[indexTag]]
+	if !ok {
+		return "", false
+	}
+	v, ok := j.names[key]
+	return v, ok
+}
+
+// removeJoins drops the host's joins
+func removeJoins(host string) {
+	joinTables.Lock()
+	for key := range joinTables.joins {
+		if strings.HasPrefix(key, host+"/") {
+			delete(joinTables.joins, key)
+		}
+	}
+	joinTables.Unlock()
+}
+
+// JoinSender adds tags resolved through the mib config's joins,
+// whose tables are walked until stop is closed
+func JoinSender(sender snmp.Sender, p snmp.Profile, agent string, c *SnmpConfig, m *MibConfig, freq int, stop chan struct{}) snmp.Sender {
+	list, err := joins(m)
+	if err != nil {
+		log.Println(err)
+		return sender
+	}
+	if len(list) == 0 {
+		return sender
+	}
+	for _, j := range list {
+		j.profile = p
+		j.config = c
+		j.load(p.Host)
+		addCache(p.Host, j)
+		joinTables.Lock()
+		joinTables.joins[p.Host+"/"+j.key+"/"+j.column] = j
+		joinTables.Unlock()
+		go j.refresher(p, joinRefresh(m, freq), stop)
+	}
+	// indexes may be renumbered when the device reboots
+	onReboot(p.Host, agent, func() {
+		for _, j := range list {
+			if err := j.refresh(p); err != nil {
+				log.Printf("join lookup of %s/%s for %s failed: %s\n", j.key, j.column, p.Host, err)
+			}
+		}
+	})
+	return func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+		for _, j := range list {
+			if v, ok := j.lookup(tags); ok {
+				tags = withTag(tags, j.tag, v)
+			}
+		}
+		return sender(name, tags, value, ts)
+	}
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/gcfg.v1"
+)
+
+// serviceAccount is where kubernetes mounts the pod's api credentials
+const serviceAccount = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// configMapRetry is how long to wait before watching again after the watch ends
+const configMapRetry = 5 * time.Second
+
+// configMap is the kubernetes ConfigMap that agent definitions are read from.
+// Every key ending in .gcfg is appended to the config file, in key order
+type configMap struct {
+	namespace string
+	name      string
+	base      string
+	token     string
+	client    *http.Client
+	file      string // the config file it adds to
+	text      string // the config as last read
+	applied   string // the config in use
+}
+
+// kubeObject is the part of a ConfigMap that is used
+type kubeObject struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Data map[string]string `json:"data"`
+}
+
+// kubeEvent is an event from a watch
+type kubeEvent struct {
+	Type   string     `json:"type"`
+	Object kubeObject `json:"object"`
+}
+
+// gcfgText returns the config in the ConfigMap
+func (o kubeObject) gcfgText() string {
+	keys := make([]string, 0, len(o.Data))
+	for k := range o.Data {
+		if strings.HasSuffix(k, ".gcfg") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var b bytes.Buffer
+	for _, k := range keys {
+		b.WriteString("\n")
+		b.WriteString(o.Data[k])
+	}
+	return b.String()
+}
+
+// newConfigMap connects to the kubernetes api with the pod's service account.
+// The ConfigMap is given as [namespace/]name, in the pod's namespace by default
+func newConfigMap(spec string) (*configMap, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if len(host) == 0 {
+		return nil, fmt.Errorf("not running in kubernetes")
+	}
+	if len(port) == 0 {
+		port = "443"
+	}
+	token, err := ioutil.ReadFile(filepath.Join(serviceAccount, "token"))
+	if err != nil {
+		return nil, err
+	}
+	ca, err := ioutil.ReadFile(filepath.Join(serviceAccount, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates in %s", filepath.Join(serviceAccount, "ca.crt"))
+	}
+	c := &configMap{
+		name:  spec,
+		base:  "https://" + net.JoinHostPort(host, port),
+		token: strings.TrimSpace(string(token)),
+		client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}
+	if i := strings.Index(spec, "/"); i >= 0 {
+		c.namespace, c.name = spec[:i], spec[i+1:]
+	} else {
+		ns, err := ioutil.ReadFile(filepath.Join(serviceAccount, "namespace"))
+		if err != nil {
+			return nil, err
+		}
+		c.namespace = strings.TrimSpace(string(ns))
+	}
+	return c, nil
+}
+
+// get makes a request of the kubernetes api
+func (c *configMap) get(path string, timeout time.Duration) (*http.Response, error) {
+	req, err := http.NewRequest("GET", c.base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	client := *c.client
+	client.Timeout = timeout
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("configmap %s/%s: %s", c.namespace, c.name, resp.Status)
+	}
+	return resp, nil
+}
+
+// fetch returns the current ConfigMap
+func (c *configMap) fetch() (kubeObject, error) {
+	var o kubeObject
+	resp, err := c.get(fmt.Sprintf("/api/v1/namespaces/%s/configmaps/%s", c.namespace, c.name), 30*time.Second)
+	if err != nil {
+		return o, err
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(&o)
+	return o, err
+}
+
+// watch follows changes to the ConfigMap from the given version,
+// returning the new config when it changes, or when the watch ends
+func (c *configMap) watch(version string) (string, bool, error) {
+	q := url.Values{
+		"watch":           {"1"},
+		"fieldSelector":   {"metadata.name=" + c.name},
+		"resourceVersion": {version},
+	}
+	resp, err := c.get(fmt.Sprintf("/api/v1/namespaces/%s/configmaps?%s", c.namespace, q.Encode()), 0)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var e kubeEvent
+		if err := dec.Decode(&e); err != nil {
+			return "", false, err
+		}
+		switch e.Type {
+		case "ADDED", "MODIFIED":
+			if text := e.Object.gcfgText(); text != c.text {
+				return text, true, nil
+			}
+		case "DELETED":
+			log.Printf("configmap %s/%s deleted, keeping the current config\n", c.namespace, c.name)
+		case "ERROR":
+			// usually the version is too old, so start again from the current one
+			return "", false, nil
+		}
+	}
+}
+
+// follow watches the ConfigMap, reloading when its config changes
+func (c *configMap) follow() {
+	for {
+		o, err := c.fetch()
+		if err == nil {
+			if text := o.gcfgText(); text != c.text {
+				c.apply(text)
+			}
+			var text string
+			var changed bool
+			if text, changed, err = c.watch(o.Metadata.ResourceVersion); changed {
+				c.apply(text)
+			}
+		}
+		if err != nil && debugging() {
+			log.Printf("configmap %s/%s: %s\n", c.namespace, c.name, err)
+		}
+		time.Sleep(configMapRetry)
+	}
+}
+
+// apply uses the new config, unless it is invalid. Changes to snmp and mibs
+// configs are applied in place, anything else restarts the collector
+func (c *configMap) apply(text string) {
+	if err := c.check(text); err != nil {
+		log.Printf("configmap %s/%s is invalid, keeping the current config: %s\n", c.namespace, c.name, err)
+		c.text = text
+		return
+	}
+	c.save(text)
+	c.text = text
+	prior, err := c.parse(c.applied)
+	if err != nil {
+		reload()
+		return
+	}
+	next, err := c.parse(text)
+	if err != nil {
+		reload()
+		return
+	}
+	snmps, mibs, ok := changedSections(prior, next)
+	if !ok {
+		reload()
+		return
+	}
+	c.applied = text
+	reconfigure(next, snmps, mibs)
+}
+
+// parse reads the config file and the text from the ConfigMap into a new config,
+// so it can be compared with another read the same way
+func (c *configMap) parse(text string) (reflect.Value, error) {
+	conf := reflect.New(reflect.TypeOf(cfg))
+	err := gcfg.ReadStringInto(conf.Interface(), expandEnv(c.file+text))
+	return conf.Elem(), err
+}
+
+// sections returns the snmp and mibs configs of a parsed config
+func sections(conf reflect.Value) (map[string]*SnmpConfig, map[string]*MibConfig) {
+	return conf.FieldByName("Snmp").Interface().(map[string]*SnmpConfig),
+		conf.FieldByName("Mibs").Interface().(map[string]*MibConfig)
+}
+
+// changedSections returns the names of the snmp configs that were added, removed
+// or changed, directly or by the mibs configs they use, and of the mibs configs
+// that changed. It is false if anything else changed, or an snmp config that
+// discovered agents are copied from, as the collector must then be restarted
+func changedSections(prior, next reflect.Value) ([]string, []string, bool) {
+	for i := 0; i < prior.NumField(); i++ {
+		switch prior.Type().Field(i).Name {
+		case "Snmp", "Mibs":
+			continue
+		}
+		if !reflect.DeepEqual(prior.Field(i).Interface(), next.Field(i).Interface()) {
+			return nil, nil, false
+		}
+	}
+	oldSnmp, oldMibs := sections(prior)
+	newSnmp, newMibs := sections(next)
+	mibChanged := make(map[string]bool)
+	var mibs []string
+	for _, list := range []map[string]*MibConfig{oldMibs, newMibs} {
+		for name := range list {
+			if !mibChanged[name] && !reflect.DeepEqual(oldMibs[name], newMibs[name]) {
+				mibChanged[name] = true
+				mibs = append(mibs, name)
+			}
+		}
+	}
+	// uses returns whether the snmp config uses a changed mibs config, as found by configAgents
+	uses := func(name string, c *SnmpConfig) bool {
+		if c == nil {
+			return false
+		}
+		if len(c.Mibs) == 0 {
+			return mibChanged[name] || mibChanged["*"]
+		}
+		for _, m := range strings.Fields(c.Mibs) {
+			if mibChanged[m] {
+				return true
+			}
+		}
+		return false
+	}
+	sources := make(map[string]bool)
+	for _, d := range cfg.Discovery {
+		sources[d.Snmp] = true
+	}
+	agentLock.Lock()
+	for _, from := range discovered {
+		sources[from] = true
+	}
+	agentLock.Unlock()
+	seen := make(map[string]bool)
+	var snmps []string
+	for _, list := range []map[string]*SnmpConfig{oldSnmp, newSnmp} {
+		for name := range list {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			o, n := oldSnmp[name], newSnmp[name]
+			if reflect.DeepEqual(o, n) && !uses(name, o) && !uses(name, n) {
+				continue
+			}
+			if sources[name] {
+				return nil, nil, false
+			}
+			snmps = append(snmps, name)
+		}
+	}
+	sort.Strings(snmps)
+	return snmps, mibs, true
+}
+
+// reconfigure replaces the snmp and mibs configs that changed with those of the new
+// config and restarts their agents, leaving the rest of the collector running
+func reconfigure(next reflect.Value, snmps, mibs []string) {
+	newSnmp, newMibs := sections(next)
+	agentLock.Lock()
+	snmpList := make(map[string]*SnmpConfig, len(cfg.Snmp))
+	for k, c := range cfg.Snmp {
+		snmpList[k] = c
+	}
+	var retired, started []string
+	for _, name := range snmps {
+		if _, ok := snmpList[name]; ok {
+			retired = append(retired, name)
+			delete(snmpList, name)
+		}
+		if c, ok := newSnmp[name]; ok {
+			snmpList[name] = c
+			started = append(started, name)
+		}
+	}
+	// the mibs configs that didn't change are kept, as their names were expanded
+	mibList := make(map[string]*MibConfig, len(cfg.Mibs))
+	for k, m := range cfg.Mibs {
+		mibList[k] = m
+	}
+	for _, name := range mibs {
+		if m, ok := newMibs[name]; ok {
+			mibList[name] = m
+		} else {
+			delete(mibList, name)
+		}
+	}
+	cfg.Snmp = snmpList
+	cfg.Mibs = mibList
+	agentLock.Unlock()
+
+	for _, name := range retired {
+		retireAgent(name)
+	}
+	var agents []snmpInfo
+	for _, name := range started {
+		list, err := configAgents(name, newSnmp[name])
+		if err == nil {
+			err = checkDiscovered(list, mibOIDs)
+		}
+		if err != nil {
+			log.Printf("configmap: not polling %s: %s\n", name, err)
+			continue
+		}
+		agents = append(agents, list...)
+	}
+	startAgents(agents)
+	log.Printf("config changed, snmp configs stopped: %s, started: %s\n", strings.Join(retired, " "), strings.Join(started, " "))
+}
+
+// check runs the collector with -check on the config file and the new text,
+// which loads and validates the config the same way as starting with it
+func (c *configMap) check(text string) error {
+	f, err := ioutil.TempFile("", "influxsnmp-*.gcfg")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(c.file + text)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	out, err := exec.Command(exe, "-config", f.Name(), "-check").CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); len(msg) > 0 {
+			return fmt.Errorf("%s", msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// cachePath is where the last valid config from the ConfigMap is kept,
+// in the cacheDir of the config file, or the temp dir without one
+func (c *configMap) cachePath() string {
+	dir := os.TempDir()
+	base := reflect.New(reflect.TypeOf(cfg))
+	if err := gcfg.ReadStringInto(base.Interface(), expandEnv(c.file)); err == nil {
+		if d := base.Elem().FieldByName("Common").FieldByName("CacheDir").String(); len(d) > 0 {
+			dir = d
+		}
+	}
+	return filepath.Join(dir, fmt.Sprintf("configmap-%s-%s.gcfg", c.namespace, c.name))
+}
+
+// save keeps the config so it can be used if the ConfigMap can't be read at startup
+func (c *configMap) save(text string) {
+	if err := ioutil.WriteFile(c.cachePath(), []byte(text), 0600); err != nil {
+		log.Printf("cannot save configmap %s/%s: %s\n", c.namespace, c.name, err)
+	}
+}
+
+// load returns the config in the ConfigMap, or the one last saved
+// if the ConfigMap can't be read
+func (c *configMap) load() (string, error) {
+	o, err := c.fetch()
+	if err == nil {
+		text := o.gcfgText()
+		c.save(text)
+		return text, nil
+	}
+	saved, serr := ioutil.ReadFile(c.cachePath())
+	if serr != nil {
+		return "", err
+	}
+	log.Printf("configmap %s/%s: %s, using the config saved at %s\n", c.namespace, c.name, err, c.cachePath())
+	return string(saved), nil
+}
+
+// reload finishes the polls in progress and flushes the senders,
+// then restarts the collector in place to apply the new config
+func reload() {
+	log.Println("config changed, reloading")
+	if err := annotate("influxsnmp reloading", "collector"); err != nil {
+		log.Println("annotation error:", err)
+	}
+	shutdown(shutdownTimeout())
+	exe, err := os.Executable()
+	if err == nil {
+		err = syscall.Exec(exe, os.Args, os.Environ())
+	}
+	fatal(exitFailed, "reload failed: %s", err)
+}
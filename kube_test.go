@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChangedSections(t *testing.T) {
+	c := &configMap{file: `
+[influx "*"]
+url = http://localhost:8086
+database = snmp
+
+[mibs "traffic"]
+name = ifHCInOctets ifHCOutOctets
+`}
+	const base = `
+[snmp "core"]
+host = core1
+mibs = traffic
+
+[snmp "edge"]
+host = edge1
+`
+	tests := []struct {
+		name  string
+		text  string
+		snmps []string
+		mibs  []string
+		ok    bool
+	}{
+		{"same", base, nil, nil, true},
+		{"host", base + "\n[snmp \"lab\"]\nhost = lab1\n", []string{"lab"}, nil, true},
+		{"community", `
+[snmp "core"]
+host = core1
+mibs = traffic
+community = private
+
+[snmp "edge"]
+host = edge1
+`, []string{"core"}, nil, true},
+		{"mibs", base + "\n[mibs \"traffic\"]\nfreq = 30\n", []string{"core"}, []string{"traffic"}, true},
+		{"default mibs", base + "\n[mibs \"*\"]\nname = sysUpTime\n", []string{"edge"}, []string{"*"}, true},
+		{"common", base + "\n[common]\nhttpPort = 8080\n", nil, nil, false},
+	}
+	prior, err := c.parse(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		next, err := c.parse(tt.text)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		snmps, mibs, ok := changedSections(prior, next)
+		if ok != tt.ok || !reflect.DeepEqual(snmps, tt.snmps) || !reflect.DeepEqual(mibs, tt.mibs) {
+			t.Errorf("%s: got %v %v %t, want %v %v %t", tt.name, snmps, mibs, ok, tt.snmps, tt.mibs, tt.ok)
+		}
+	}
+}
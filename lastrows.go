@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lastRow is the most recent point of a series
+type lastRow struct {
+	Tags   map[string]string      `json:"tags"`
+	Fields map[string]interface{} `json:"fields"`
+	Time   time.Time              `json:"time"`
+}
+
+// lastRows keeps the most recent point of every series, by host and measurement
+type lastRows struct {
+	sync.Mutex
+	expire time.Duration
+	hosts  map[string]map[string]map[string]*lastRow
+}
+
+var latest = &lastRows{hosts: make(map[string]map[string]map[string]*lastRow)}
+
+func (l *lastRows) save(name string, tags map[string]string, fields map[string]interface{}, ts time.Time) {
+	host := tags["host"]
+	f := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		f[k] = v
+	}
+	key := seriesKey("", tags)
+	l.Lock()
+	measurements, ok := l.hosts[host]
+	if !ok {
+		measurements = make(map[string]map[string]*lastRow)
+		l.hosts[host] = measurements
+	}
+	series, ok := measurements[name]
+	if !ok {
+		series = make(map[string]*lastRow)
+		measurements[name] = series
+	}
+	series[key] = &lastRow{Tags: tags, Fields: f, Time: ts}
+	l.Unlock()
+}
+
+// rows returns the current rows of the host's measurement, dropping expired ones,
+// and only those of the tenant if there is one. It returns false if nothing has
+// been saved for them
+func (l *lastRows) rows(host, name, tenant string) ([]*lastRow, bool) {
+	cutoff := time.Now().Add(-l.expire)
+	l.Lock()
+	defer l.Unlock()
+	series, ok := l.hosts[host][name]
+	if !ok {
+		return nil, false
+	}
+	list := make([]*lastRow, 0, len(series))
+	for key, row := range series {
+		if l.expire > 0 && row.Time.Before(cutoff) {
+			delete(series, key)
+			continue
+		}
+		if len(tenant) > 0 && row.Tags[tenantTag] != tenant {
+			continue
+		}
+		list = append(list, row)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return seriesKey("", list[i].Tags) < seriesKey("", list[j].Tags)
+	})
+	return list, true
+}
+
+// measurements returns the names of the host's measurements
+func (l *lastRows) measurements(host string) ([]string, bool) {
+	l.Lock()
+	defer l.Unlock()
+	measurements, ok := l.hosts[host]
+	if !ok {
+		return nil, false
+	}
+	list := make([]string, 0, len(measurements))
+	for name := range measurements {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list, true
+}
+
+// ServeHTTP returns the most recent rows as json from /api/last/{host}/{measurement},
+// or the host's measurements from /api/last/{host}. It needs the same token as
+// /api/query, and a tenant may only read the rows of its own agents
+func (l *lastRows) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := queryTenant(r)
+	if !ok {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	parts := strings.SplitN(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/last/"), "/"), "/", 2)
+	if len(tenant) > 0 {
+		if _, ok := hostAgent(parts[0], tenant); !ok {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	var reply interface{}
+	var found bool
+	switch {
+	case len(parts[0]) == 0:
+	case len(parts) == 1:
+		reply, found = l.measurements(parts[0])
+	default:
+		reply, found = l.rows(parts[0], parts[1], tenant)
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(reply); err != nil {
+		log.Printf("last error:%s\n", err)
+	}
+}
+
+// LastSender keeps the most recent points sent to serve on /api/last
+func LastSender(send SendFunc) SendFunc {
+	if !cfg.Common.LastValues {
+		return send
+	}
+	expire := cfg.Common.MetricsExpire
+	if expire == 0 {
+		expire = defaultMetricsExpire
+	}
+	latest.expire = time.Duration(expire) * time.Second
+	return func(name string, tags map[string]string, fields map[string]interface{}, ts time.Time) error {
+		latest.save(name, tags, fields, ts)
+		return send(name, tags, fields, ts)
+	}
+}
+
+// remove drops the rows kept for the host
+func (l *lastRows) remove(host string) {
+	l.Lock()
+	delete(l.hosts, host)
+	l.Unlock()
+}
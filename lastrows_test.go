@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLastAPI(t *testing.T) {
+	snmps, tenants, influx, token := cfg.Snmp, cfg.Tenant, cfg.Influx, cfg.Common.AdminToken
+	defer func() { cfg.Snmp, cfg.Tenant, cfg.Influx, cfg.Common.AdminToken = snmps, tenants, influx, token }()
+	cfg.Snmp = map[string]*SnmpConfig{
+		"acme-core": {Host: "last1"},
+		"ops":       {Host: "last1 last2"},
+	}
+	cfg.Tenant = map[string]*TenantConfig{
+		"acme": {Agents: "acme-core", Senders: "acme", Token: "acme-token"},
+	}
+	cfg.Influx = map[string]*InfluxConfig{"acme": {}, "*": {}}
+	cfg.Common.AdminToken = "admin-token"
+	l := &lastRows{hosts: make(map[string]map[string]map[string]*lastRow)}
+	now := time.Now()
+	l.save("ifXTable", map[string]string{"host": "last1", "index": "1", tenantTag: "acme"}, map[string]interface{}{"value": 1}, now)
+	l.save("ifXTable", map[string]string{"host": "last1", "index": "2"}, map[string]interface{}{"value": 2}, now)
+	l.save("ifXTable", map[string]string{"host": "last2", "index": "1"}, map[string]interface{}{"value": 3}, now)
+	tests := []struct {
+		path, token string
+		code, rows  int
+	}{
+		{"/api/last/last1/ifXTable", "", http.StatusForbidden, 0},
+		{"/api/last/last1/ifXTable", "admin-token", http.StatusOK, 2},
+		{"/api/last/last1/ifXTable", "acme-token", http.StatusOK, 1},
+		{"/api/last/last2/ifXTable", "acme-token", http.StatusNotFound, 0},
+		{"/api/last/last2/ifXTable", "admin-token", http.StatusOK, 1},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.path, nil)
+		if len(tt.token) > 0 {
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		l.ServeHTTP(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s with %q: got %d, want %d", tt.path, tt.token, w.Code, tt.code)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var rows []lastRow
+		if err := json.Unmarshal(w.Body.Bytes(), &rows); err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != tt.rows {
+			t.Errorf("%s with %q: got %d rows, want %d", tt.path, tt.token, len(rows), tt.rows)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// LinkConfig is a link shown for each agent on the status page, to where its data
+// can be seen (e.g., Grafana or Chronograf). {host}, {group} (the snmp config name),
+// {database} and {station} in the urls are replaced with the agent's values
+type LinkConfig struct {
+	URL     string `gcfg:"url"`
+	Preview string `gcfg:"preview"` // embedded in the status page, e.g., a Grafana d-solo panel
+}
+
+// agentLink is a link for an agent
+type agentLink struct {
+	Name    string
+	URL     string
+	Preview string
+}
+
+// agentLinks returns the configured links for an agent, named as host/group
+func agentLinks(agent string) []agentLink {
+	if len(cfg.Link) == 0 {
+		return nil
+	}
+	parts := strings.SplitN(agent, "/", 2)
+	host, group := parts[0], ""
+	if len(parts) > 1 {
+		group = parts[1]
+	}
+	database := ""
+	if c, ok := influxFor(group); ok {
+		database = c.Database
+	}
+	r := strings.NewReplacer(
+		"{host}", url.QueryEscape(host),
+		"{group}", url.QueryEscape(group),
+		"{database}", url.QueryEscape(database),
+		"{station}", url.QueryEscape(cfg.Common.Station),
+	)
+	names := make([]string, 0, len(cfg.Link))
+	for name := range cfg.Link {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	links := make([]agentLink, 0, len(names))
+	for _, name := range names {
+		c := cfg.Link[name]
+		l := agentLink{Name: name, URL: r.Replace(c.URL)}
+		if len(c.Preview) > 0 {
+			l.Preview = r.Replace(c.Preview)
+		}
+		links = append(links, l)
+	}
+	return links
+}
@@ -1,14 +1,23 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	client "github.com/influxdata/influxdb/client/v2"
@@ -35,6 +44,52 @@ type SnmpConfig struct {
 	Mibs      string `gcfg:"mibs"`
 	Tags      string `gcfg:"tags"`
 	Disabled  bool   `gcfg:"disabled"`
+	Align     bool   `gcfg:"align"`     // align timestamps to the start of the polling interval
+	Timestamp string `gcfg:"timestamp"` // time of the poll to timestamp points with: start, middle, stop, or uptime
+	Async     bool   `gcfg:"async"`     // process values in the background while the walk continues
+	Parallel  int    `gcfg:"parallel"`  // walks to run at once against each host, defaults to 1
+	Uptime    bool   `gcfg:"uptime"`    // check sysUpTime for reboots and clock jumps
+	Inventory int    `gcfg:"inventory"` // seconds between ENTITY-MIB inventory walks
+	Topology  int    `gcfg:"topology"`  // seconds between LLDP neighbor walks
+	CDP       bool   `gcfg:"cdp"`       // include CDP neighbors in topology walks
+	// Processors lists the processing steps applied to polled data, in order
+	Processors string `gcfg:"processors"`
+	// ProxyHost is an snmp proxy (host or host:port) to poll the hosts through
+	ProxyHost string `gcfg:"proxyHost"`
+	// ProxyCommunity maps each host to the proxy community that selects it, as host=community
+	ProxyCommunity string `gcfg:"proxyCommunity"`
+	// ProxySetCommunity maps each host to the proxy community that selects it for SNMP sets,
+	// as host=community. Sets through the proxy are refused for hosts without one
+	ProxySetCommunity string `gcfg:"proxySetCommunity"`
+	// Class is the write class, to batch its points differently from others written to the same influxdb
+	Class string `gcfg:"class"`
+	// Resolve is how to poll hosts given by name: ipv4 or ipv6 to prefer that address family,
+	// or all to poll each address as a separate target with an address tag
+	Resolve string `gcfg:"resolve"`
+	// Escalate are further attempts at a failed poll, each "timeout [retries]",
+	// e.g., a final attempt with a long timeout for a device that is sometimes busy
+	Escalate []string `gcfg:"escalate"`
+	// SetCommunity is the write community, to allow SNMP sets through the admin API
+	SetCommunity string `gcfg:"setCommunity"`
+	// SetAllow are the numeric OIDs that can be set, including those below them
+	SetAllow []string `gcfg:"setAllow"`
+	// Credential names the credential to use instead of the community, or the SNMPv3 passwords, so they can be changed while running
+	Credential string `gcfg:"credential"`
+	// SecurityName is the SNMPv3 user, used instead of the community with version = 3
+	SecurityName string `gcfg:"securityName"`
+	// AuthProtocol is the SNMPv3 authentication protocol: MD5, SHA, SHA224, SHA256, SHA384 or SHA512
+	AuthProtocol string `gcfg:"authProtocol"`
+	AuthPassword string `gcfg:"authPassword"`
+	// PrivProtocol is the SNMPv3 privacy protocol: DES, AES, AES192 or AES256
+	PrivProtocol string `gcfg:"privProtocol"`
+	PrivPassword string `gcfg:"privPassword"`
+	// Context is the SNMPv3 context name, e.g., to poll a VLAN's bridge tables
+	Context string `gcfg:"context"`
+	// Schedule changes the polling frequency by time of day, each "[days] HH:MM-HH:MM freq",
+	// e.g., "mon-fri 08:00-18:00 30"; freq applies outside them
+	Schedule []string `gcfg:"schedule"`
+	// tags are added to Tags by discovery, for agents found with their own
+	tags map[string]string
 }
 
 // CommonConfig specifies general parameters
@@ -44,6 +99,62 @@ type CommonConfig struct {
 	Mibs     string `gcfg:"mibs"`
 	MibFile  string `gcfg:"mibfile"`
 	Elapsed  bool   `gcfg:"elapsed"`
+	Events   bool   `gcfg:"events"`
+	// EventHold is how many seconds a state must persist before it is reported
+	EventHold int `gcfg:"eventHold"`
+	// MaxSeries is the limit of distinct series per measurement (0 is unlimited)
+	MaxSeries int `gcfg:"maxSeries"`
+	// DropSeries stops new series beyond the limit, rather than only warning
+	DropSeries bool `gcfg:"dropSeries"`
+	// AliasFile maps host and index to names, as csv (host,index,name) or json
+	AliasFile string `gcfg:"aliasFile"`
+	// AliasRefresh is how often, in seconds, to reload the alias file
+	AliasRefresh int `gcfg:"aliasRefresh"`
+	// Invalid is the policy for NaN and Inf values: drop, null, zero, or log
+	Invalid string `gcfg:"invalid"`
+	// Conformance checks points follow the line protocol rules before they are queued:
+	// sanitize fixes what it can, reject drops the point
+	Conformance string `gcfg:"conformance"`
+	// SelfMetrics saves the collector's polling statistics for each agent
+	SelfMetrics bool `gcfg:"selfMetrics"`
+	// OpenMetrics serves the last polled values on /metrics
+	OpenMetrics bool `gcfg:"openMetrics"`
+	// LastValues serves the most recent rows of each host's measurements on /api/last
+	LastValues bool `gcfg:"lastValues"`
+	// MetricsExpire is how many seconds a value is served without being polled again
+	MetricsExpire int `gcfg:"metricsExpire"`
+	// Workers is the size of a pool of pollers shared by all walks,
+	// rather than each walk having its own
+	Workers int `gcfg:"workers"`
+	// ShutdownTimeout is how many seconds to wait for polls to finish
+	// and queued points to be written when stopping
+	ShutdownTimeout int `gcfg:"shutdownTimeout"`
+	// Station names this collector on the status page and in its self-metrics
+	Station string `gcfg:"station"`
+	// CollectorTag is a tag added to every point with the station (or hostname),
+	// so the points of collectors that poll the same devices can be told apart
+	CollectorTag string `gcfg:"collectorTag"`
+	// Favicon is an icon file to serve instead of the built in one
+	Favicon string `gcfg:"favicon"`
+	// ErrorHistory is how many errors to keep for each agent
+	ErrorHistory int `gcfg:"errorHistory"`
+	// CacheDir saves resolved names between runs, so points are tagged correctly right after a restart
+	CacheDir string `gcfg:"cacheDir"`
+	// AdminToken authorizes changes through the web interface, which are disabled without it
+	AdminToken string `gcfg:"adminToken"`
+	// QueryProxy serves data read back from influxdb on /api/query
+	QueryProxy bool `gcfg:"queryProxy"`
+	// DumpFile is where SIGUSR1 writes the state served on /api/dump
+	DumpFile string `gcfg:"dumpFile"`
+	// Peers are the base urls of other collectors, to show the coverage of the whole fleet
+	Peers string `gcfg:"peers"`
+	// MemoryLimit is the memory (in MB) the collector keeps under, by dropping
+	// queued points and cached values, e.g., to stay within a container's limit
+	MemoryLimit int `gcfg:"memoryLimit"`
+	// Probe checks every agent responds before polling starts
+	Probe bool `gcfg:"probe"`
+	// MinReachable is the percentage of agents that must respond to the probe
+	MinReachable float64 `gcfg:"minReachable"`
 }
 
 // MibConfig specifies what OIDs to query
@@ -53,28 +164,70 @@ type MibConfig struct {
 	Regexps []string `gcfg:"regexp"`
 	Keep    bool     `gcfg:"keep"`
 	Count   int      `gcfg:"count"`
+	// Freq is how often to poll these OIDs (in seconds), if not as often as the rest of the agent
+	Freq int `gcfg:"freq"`
+	// Retention is the retention policy to save the data in, if not the sender default
+	Retention string `gcfg:"retention"`
+	// Joins add tags looked up through another table, as "keyColumn lookupColumn tag"
+	Joins []string `gcfg:"join"`
+	// Indexes names the parts of a composite index, e.g., "addr:ip port:int"
+	Indexes string `gcfg:"indexes"`
+	// Dedup suppresses unchanged values, sending them at least this often (in seconds)
+	Dedup int `gcfg:"dedup"`
+	// KeyBy replaces the index tag with the name from this column, e.g., ifName or ifAlias
+	KeyBy string `gcfg:"keyBy"`
+	// JoinRefresh is how many seconds between walks of the join tables (default 600)
+	JoinRefresh int `gcfg:"joinRefresh"`
+	// Deltas are counter columns sent with their change since the previous poll, as a delta field
+	Deltas string `gcfg:"deltas"`
+	// Top sends only the top rows of each poll by a column, as "N column", and the sum of the rest
+	// as a row tagged "other", e.g., "20 ifHCInOctets" for the busiest interfaces
+	Top string `gcfg:"top"`
 }
 
 // InfluxConfig defines connection requirements
 type InfluxConfig struct {
-	URL         string `gcfg:"url"`
-	Database    string `gcfg:"database"`
-	Username    string `gcfg:"username"`
-	Password    string `gcfg:"password"`
-	Retention   string `gcfg:"retention"`
-	Consistency string `gcfg:"consistency"`
-	SkipVerify  bool   `gcfg:"skip_verify"`
-	Timeout     int    `gcfg:"timeout"`
-	BatchSize   int    `gcfg:"batchSize"`
-	QueueSize   int    `gcfg:"queueSize"`
-	Flush       int    `gcfg:"flush"`
+	URL         string  `gcfg:"url"`
+	Database    string  `gcfg:"database"`
+	Username    string  `gcfg:"username"`
+	Password    string  `gcfg:"password"`
+	Retention   string  `gcfg:"retention"`
+	Consistency string  `gcfg:"consistency"`
+	SkipVerify  bool    `gcfg:"skip_verify"`
+	Timeout     int     `gcfg:"timeout"`
+	BatchSize   int     `gcfg:"batchSize"`
+	QueueSize   int     `gcfg:"queueSize"`
+	Flush       int     `gcfg:"flush"`
+	Writers     int     `gcfg:"writers"`
+	Ordered     bool    `gcfg:"ordered"`
+	FailSoft    bool    `gcfg:"failSoft"`
+	Verify      float64 `gcfg:"verify"` // fraction of batches to read back after writing
+	Gzip        bool    `gcfg:"gzip"`   // compress writes
+	// EventFlush gives events their own sender, flushed this often (in seconds)
+	EventFlush     int    `gcfg:"eventFlush"`
+	EventBatchSize int    `gcfg:"eventBatchSize"`
+	Downsample     string `gcfg:"downsample"`
+	Precision      string `gcfg:"precision"`
+	Proxy          string `gcfg:"proxy"`
+	TLSCA          string `gcfg:"tlsCA"`
+	TLSCert        string `gcfg:"tlsCert"`
+	TLSKey         string `gcfg:"tlsKey"`
+	Tags           string `gcfg:"tags"` // added to every point, {version} is the collector version
 }
 
 type snmpStats struct {
-	GetCnt    int
-	ErrCnt    int
-	LastError error
-	LastTime  time.Time
+	GetCnt      int
+	ErrCnt      int
+	Values      int64 // values received
+	Bytes       int64 // estimated size of the values received
+	MaxPoll     int64 // most bytes received in one poll of the walk, over all of its requests
+	TooBig      int   // polls failed as the responses were too big for the agent
+	Repetitions int   // values asked for per request since the agent answered tooBig
+	Freq        int   // seconds between polls
+	LastOK      time.Time
+	Recent      []errorEntry // most recent errors first
+	LastError   error
+	LastTime    time.Time
 }
 
 type statsFunc func() snmpStats
@@ -83,10 +236,12 @@ type snmpInfo struct {
 	Name   string
 	Config *SnmpConfig
 	MIB    *MibConfig
+	Group  string // name of the mibs config, or preset
 }
 
 // SystemStatus provides operating statistics
 type SystemStatus struct {
+	Station   string
 	Period    string
 	Started   string
 	Uptime    string
@@ -94,18 +249,42 @@ type SystemStatus struct {
 	SNMP      map[string]*SnmpConfig
 	Influx    map[string]*InfluxConfig
 	SnmpStats map[string]snmpStats
+	Senders   map[string]SenderStats
+	Series    map[string]int
+	Invalid   int64
+	// Quarantined are the agent/OIDs not being walked, and when they will be retried
+	Quarantined map[string]time.Time
+	// Fleet is the coverage of this collector and its peers, if it has any
+	Fleet *fleetStatus
+	// Memory is the approximate memory use
+	Memory memoryStats
 }
 
 // TimeStamp contains the start and stop time of PDU collection
 type TimeStamp snmp.TimeStamp
 
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
 var (
 	startTime  = time.Now()
 	quit       sync.WaitGroup
 	verbose    bool
 	sample     bool
+	sampleFmt  string
+	baseline   string
 	dump       bool
 	filter     bool
+	agentScope string
+	mibScope   string
+	grafana    string
+	schemaFmt  string
+	selfTest   bool
+	benchmark  int
+	printCfg   bool
+	check      bool
+	kubeConfig string
+	kubeMap    *configMap
 	httpPort   = 8080
 	appdir, _  = osext.ExecutableFolder()
 	configFile = filepath.Join(appdir, "config.gcfg")
@@ -114,29 +293,49 @@ var (
 	logger     *log.Logger
 	commonTags map[string]string
 	sLock      sync.Mutex
+	senders    map[string]Sender
 
 	cfg = struct {
-		Snmp   map[string]*SnmpConfig
-		Mibs   map[string]*MibConfig
-		Influx map[string]*InfluxConfig
-		Common CommonConfig
+		Snmp       map[string]*SnmpConfig
+		Mibs       map[string]*MibConfig
+		Influx     map[string]*InfluxConfig
+		Threshold  map[string]*ThresholdConfig
+		Common     CommonConfig
+		Grafana    GrafanaConfig
+		Enrich     map[string]*EnrichConfig
+		Exec       ExecConfig
+		Script     ScriptConfig
+		Aggregate  map[string]*AggregateConfig
+		Tenant     map[string]*TenantConfig
+		Relabel    map[string]*RelabelConfig
+		Credential map[string]*CredentialConfig
+		Link       map[string]*LinkConfig
+		Class      map[string]*ClassConfig
+		Input      InputConfig
+		Discovery  map[string]*DiscoveryConfig
 	}{}
 )
 
-func getSenders() map[string]Sender {
+func getSenders() (map[string]Sender, error) {
 	s := map[string]Sender{}
 	for name, c := range cfg.Influx {
-		sender, err := makeSender(c)
+		sender, err := newSender(c)
 		if err != nil {
-			panic(err)
+			return nil, fmt.Errorf("influx config %s: %s", name, err)
 		}
 		s[name] = sender
 	}
-	return s
+	if err := eventSenders(s); err != nil {
+		return nil, err
+	}
+	if err := classSenders(s); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
 func (c *SnmpConfig) profiles() []snmp.Profile {
-	hosts := strings.Fields(c.Host)
+	hosts := expandHosts(c)
 	list := make([]snmp.Profile, 0, len(hosts))
 	for _, host := range hosts {
 		p := snmp.Profile{
@@ -147,7 +346,7 @@ func (c *SnmpConfig) profiles() []snmp.Profile {
 			Retries:   c.Retries,
 			Timeout:   c.Timeout,
 		}
-		list = append(list, p)
+		list = append(list, withV3(p, c))
 	}
 	return list
 }
@@ -167,17 +366,24 @@ func criteria(s *SnmpConfig, m *MibConfig) []snmp.Criteria {
 		if m.Count > 0 {
 			count = m.Count
 		}
+		freq := s.Freq
+		if m.Freq > 0 {
+			freq = m.Freq
+		}
 		crit := snmp.Criteria{
 			OID:     name,
 			Index:   m.Index,
 			Regexps: regexps,
 			Keep:    m.Keep,
 			Tags:    pairs(s.Tags),
-			Freq:    s.Freq,
+			Freq:    freq,
 			Aliases: pairs(s.Aliases),
 			Rename:  pairs(s.Rename),
 			Count:   count,
 		}
+		for k, v := range s.tags {
+			crit.Tags[k] = v
+		}
 
 		for k, v := range commonTags {
 			crit.Tags[k] = v
@@ -190,11 +396,18 @@ func criteria(s *SnmpConfig, m *MibConfig) []snmp.Criteria {
 
 func status() SystemStatus {
 	return SystemStatus{
-		Started:   startTime.Format(layout),
-		Uptime:    time.Now().Sub(startTime).String(),
-		SNMP:      cfg.Snmp,
-		Influx:    cfg.Influx,
-		SnmpStats: getStats(),
+		Station:     cfg.Common.Station,
+		Started:     startTime.Format(layout),
+		Uptime:      time.Now().Sub(startTime).String(),
+		SNMP:        snmpConfigs(),
+		Influx:      cfg.Influx,
+		SnmpStats:   getStats(),
+		Senders:     senderStats(),
+		Series:      guard.cardinality(),
+		Invalid:     atomic.LoadInt64(&invalidCount),
+		Quarantined: quarantineList(),
+		Fleet:       fleet(),
+		Memory:      memoryUse(),
 	}
 }
 
@@ -208,63 +421,166 @@ func pairs(list string) map[string]string {
 	return t
 }
 
-func init() {
+// loadConfig parses the command line and loads the config
+func loadConfig() {
 	log.SetOutput(os.Stderr)
 
 	flag.BoolVar(&sample, "sample", sample, "print a sample of collected values and exit")
+	flag.StringVar(&sampleFmt, "format", sampleFmt, "format of -sample output: 'text', 'json' or 'csv'")
+	flag.StringVar(&baseline, "compare", baseline, "compare -sample with one saved with -format json, exiting with 1 if they differ")
 	flag.BoolVar(&dump, "dump", dump, "print output of parsed mibs and exit")
 	flag.BoolVar(&filter, "filter", filter, "(filtered by used OIDs) output of dump option")
+	flag.StringVar(&agentScope, "agent", agentScope, "limit the dump to the snmp config or host")
+	flag.StringVar(&mibScope, "mibgroup", mibScope, "limit the dump to the mibs group")
+	flag.StringVar(&grafana, "grafana", grafana, "print a grafana dashboard with rows by 'device' or 'mib' and exit")
+	flag.StringVar(&schemaFmt, "schema", schemaFmt, "print the measurement schema as 'json' or 'markdown', or as 'dedupe' queries for redundant collectors, and exit")
+	flag.BoolVar(&selfTest, "selftest", selfTest, "poll once, write to and read back from influxdb, report problems and exit")
+	flag.IntVar(&benchmark, "bench", benchmark, "poll each host this many times without saving, report throughput and exit")
 	flag.StringVar(&configFile, "config", configFile, "config file")
+	flag.BoolVar(&printCfg, "print-config", printCfg, "print the effective config, with defaults and secrets redacted, and exit")
+	flag.BoolVar(&check, "check", check, "check the config, and the names it polls, are valid and exit")
+	flag.StringVar(&kubeConfig, "configmap", kubeConfig, "kubernetes ConfigMap ([namespace/]name) with more config, reloaded when it changes")
 	flag.BoolVar(&verbose, "verbose", verbose, "verbose mode")
 	flag.IntVar(&httpPort, "http", httpPort, "http port")
 	flag.StringVar(&mibs, "mibs", mibs, "mibs to use")
 	flag.Parse()
 
-	// now load up config settings
-	if _, err := os.Stat(configFile); err != nil {
-		log.Fatal(err)
-	}
-	data, err := ioutil.ReadFile(configFile)
+	// now load up config settings, from the environment if there is no config file
+	var data []byte
+	_, err := os.Stat(configFile)
 	if err != nil {
-		log.Fatal(err)
+		text, ok := envConfig()
+		if !ok {
+			fatal(exitConfig, "%s", err)
+		}
+		data = []byte(text)
+	} else if data, err = ioutil.ReadFile(configFile); err != nil {
+		fatal(exitConfig, "%s", err)
 	}
-	err = gcfg.ReadStringInto(&cfg, string(data))
+	if len(kubeConfig) > 0 {
+		if kubeMap, err = newConfigMap(kubeConfig); err != nil {
+			fatal(exitConfig, "%s", err)
+		}
+		kubeMap.file = string(data)
+		if kubeMap.text, err = kubeMap.load(); err != nil {
+			fatal(exitConfig, "%s", err)
+		}
+		kubeMap.applied = kubeMap.text
+		data = append(data, kubeMap.text...)
+	}
+	err = gcfg.ReadStringInto(&cfg, expandEnv(string(data)))
 	if err != nil {
-		log.Fatalf("Failed to parse gcfg data: %s", err)
+		fatal(exitConfig, "Failed to parse gcfg data: %s", err)
 	}
 	httpPort = cfg.Common.HTTPPort
 
 	commonTags = pairs(cfg.Common.Tags)
+	if len(cfg.Common.CollectorTag) > 0 {
+		commonTags[cfg.Common.CollectorTag] = collectorID()
+	}
 
 	if len(mibs) == 0 {
 		mibs = cfg.Common.Mibs
 	}
 
-	if verbose {
-		logger = log.New(os.Stderr, "", 0)
-	}
+	// the logger is always available so verbose mode can be toggled at runtime
+	logger = log.New(ioutil.Discard, "", 0)
+	setVerbose(verbose)
 }
 
 func errFn(err error) {
 	log.Println(err)
 }
 
-func makeSender(cfg *InfluxConfig) (Sender, error) {
+// httpConfig returns the client connection settings
+func (c *InfluxConfig) httpConfig() client.HTTPConfig {
 	conf := client.HTTPConfig{
-		Addr:               cfg.URL,
-		Username:           cfg.Username,
-		Password:           cfg.Password,
-		Timeout:            (time.Duration(cfg.Timeout) * time.Second),
-		InsecureSkipVerify: cfg.SkipVerify,
+		Addr:               c.URL,
+		Username:           c.Username,
+		Password:           c.Password,
+		Timeout:            (time.Duration(c.Timeout) * time.Second),
+		InsecureSkipVerify: c.SkipVerify,
+		Proxy:              c.proxy(),
+		TLSConfig:          c.tlsConfig(),
 	}
-	batch := client.BatchPointsConfig{
-		Precision:        "s",
-		Database:         cfg.Database,
-		RetentionPolicy:  cfg.Retention,
-		WriteConsistency: cfg.Consistency,
+	if socket, ok := c.socket(); ok {
+		// the host is a placeholder, all connections go to the socket
+		conf.Addr = "http://localhost"
+		conf.Proxy = nil
+		conf.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
 	}
+	return conf
+}
 
-	return NewSender(conf, batch, cfg.BatchSize, cfg.QueueSize, cfg.Flush, errFn)
+// socket returns the path of a unix:///path url
+func (c *InfluxConfig) socket() (string, bool) {
+	if !strings.HasPrefix(c.URL, "unix://") {
+		return "", false
+	}
+	return strings.TrimPrefix(c.URL, "unix://"), true
+}
+
+// tlsConfig returns the tls settings for a private CA or client certificate
+func (c *InfluxConfig) tlsConfig() *tls.Config {
+	if len(c.TLSCA) == 0 && len(c.TLSCert) == 0 {
+		return nil
+	}
+	conf := &tls.Config{InsecureSkipVerify: c.SkipVerify}
+	if len(c.TLSCA) > 0 {
+		pem, err := ioutil.ReadFile(c.TLSCA)
+		if err != nil {
+			fatal(exitConfig, "cannot read tlsCA: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			fatal(exitConfig, "no certificates found in tlsCA: %s", c.TLSCA)
+		}
+		conf.RootCAs = pool
+	}
+	if len(c.TLSCert) > 0 {
+		cert, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+		if err != nil {
+			fatal(exitConfig, "cannot load tlsCert/tlsKey: %s", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf
+}
+
+// proxy returns the configured proxy, or the proxy specified by
+// the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables
+func (c *InfluxConfig) proxy() func(*http.Request) (*url.URL, error) {
+	if len(c.Proxy) == 0 {
+		return http.ProxyFromEnvironment
+	}
+	u, err := url.Parse(c.Proxy)
+	if err != nil {
+		fatal(exitConfig, "invalid proxy url %s: %s", c.Proxy, err)
+	}
+	return http.ProxyURL(u)
+}
+
+// batchConfig returns the client batch settings
+func (c *InfluxConfig) batchConfig() client.BatchPointsConfig {
+	precision := c.Precision
+	if len(precision) == 0 {
+		precision = "s"
+	}
+	return client.BatchPointsConfig{
+		Precision:        precision,
+		Database:         c.Database,
+		RetentionPolicy:  c.Retention,
+		WriteConsistency: c.Consistency,
+	}
+}
+
+// influxFor returns the influx config used by the named snmp config
+func influxFor(name string) (*InfluxConfig, bool) {
+	c, ok := cfg.Influx[senderName(name)]
+	return c, ok
 }
 
 func addStats(name string, fn statsFunc) {
@@ -273,6 +589,26 @@ func addStats(name string, fn statsFunc) {
 	sLock.Unlock()
 }
 
+// removeStats stops reporting the stats added with the name,
+// or below it, such as host/mib for all the walks of a host
+func removeStats(name string) {
+	sLock.Lock()
+	for k := range statsMap {
+		if k == name || strings.HasPrefix(k, name+"/") {
+			delete(statsMap, k)
+		}
+	}
+	sLock.Unlock()
+}
+
+func senderStats() map[string]SenderStats {
+	m := make(map[string]SenderStats)
+	for name, s := range senders {
+		m[name] = s.Stats()
+	}
+	return m
+}
+
 func getStats() map[string]snmpStats {
 	m := make(map[string]snmpStats)
 	sLock.Lock()
@@ -283,80 +619,263 @@ func getStats() map[string]snmpStats {
 	return m
 }
 
-func gather(send Sender, p snmp.Profile, crit snmp.Criteria, mibID string) {
-	if crit.Freq < 1 {
-		panic("invalid polling frequency for: " + p.Host)
+// stamper returns the function that determines the timestamp of a point:
+// the start, middle or end (the default) of the poll, or its start on the
+// host's clock, from its last sysUpTime reading
+func stamper(align bool, source, host string, freq int) func(snmp.TimeStamp) time.Time {
+	if align {
+		interval := time.Duration(freq) * time.Second
+		return func(ts snmp.TimeStamp) time.Time {
+			return ts.Start.Truncate(interval)
+		}
 	}
-	var sender snmp.Sender
-	if cfg.Common.Elapsed {
-		sender = func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
-			elapsed := int(ts.Stop.Sub(ts.Start).Nanoseconds() / 1000000)
-			values := map[string]interface{}{"value": value, "elapsed": elapsed}
-			return send(name, tags, values, ts.Stop)
+	switch source {
+	case "start":
+		return func(ts snmp.TimeStamp) time.Time {
+			return ts.Start
 		}
-	} else {
-		sender = func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
-			values := map[string]interface{}{"value": value}
-			return send(name, tags, values, ts.Stop)
+	case "middle":
+		return func(ts snmp.TimeStamp) time.Time {
+			return ts.Start.Add(ts.Stop.Sub(ts.Start) / 2)
 		}
+	case "uptime":
+		return func(ts snmp.TimeStamp) time.Time {
+			// the start of the poll until sysUpTime has been read
+			t, _ := uptimeTime(host, ts.Start)
+			return t
+		}
+	}
+	return func(ts snmp.TimeStamp) time.Time {
+		return ts.Stop
+	}
+}
+
+// collector returns the sender and error function that process a walk's results
+func collector(w tableWalk) (snmp.Sender, snmp.ErrFunc) {
+	send, p, crit, a := w.send, w.profile, w.crit, w.info
+	mibID := a.Name
+	stamp := stamper(a.Config.Align, a.Config.Timestamp, p.Host, crit.Freq)
+	counters := newDeltas(p.Host, a.Name, a.MIB.Deltas)
+	top := newTopRows(a.MIB.Top, a.MIB.Deltas)
+	if top != nil {
+		addCache(p.Host, top)
+	}
+	var sender snmp.Sender = func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+		values := getFields()
+		values["value"] = value
+		if delta, ok := counters.delta(name, tags, value); ok {
+			values[deltaField] = delta
+		}
+		if top != nil {
+			top.add(name, tags, values, stamp(ts))
+			putFields(values)
+			return nil
+		}
+		err := send(name, tags, values, stamp(ts))
+		putFields(values)
+		return err
+	}
+	sender, err := pipeline(sender, stage{send, p, a, crit.Freq, w.stop})
+	if err != nil {
+		// the processors were validated at startup
+		fatal(exitConfig, "snmp config %s: %s", a.Name, err)
+	}
+	var timer walkTimer
+	if cfg.Common.Elapsed {
+		sender = timer.wrap(sender)
 	}
-	// influxdb saves uint64 as a string
-	// so this is a workaround for now
-	sender = snmp.IntegerSender(sender)
 
-	var stats snmpStats
+	stats := snmpStats{Freq: crit.Freq}
+	var recent errorRing
+	var polledBytes int64
 	var m sync.Mutex
+	sender = TrafficSender(sender, func(values, bytes int64) {
+		m.Lock()
+		stats.Values += values
+		stats.Bytes += bytes
+		m.Unlock()
+	})
+	var avail func(error)
+	if cfg.Common.Events {
+		avail = availability(send, p.Host, mibID)
+	}
 
 	errFn := func(err error) {
+		if top != nil {
+			// a failed walk's rows are incomplete, so none are sent
+			if err != nil {
+				top.reset()
+			} else if err := top.flush(send); err != nil {
+				log.Printf("top rows error for %s: %s\n", p.Host, err)
+			}
+		}
+		if avail != nil {
+			avail(err)
+		}
 		m.Lock()
 		if err == nil {
 			stats.GetCnt++
+			stats.LastOK = time.Now()
+			if polled := stats.Bytes - polledBytes; polled > stats.MaxPoll {
+				stats.MaxPoll = polled
+			}
 		} else {
 			stats.ErrCnt++
 			stats.LastError = err
 			stats.LastTime = time.Now()
+			recent.add(err)
+			if tooBig(err) {
+				stats.TooBig++
+			}
 		}
+		polledBytes = stats.Bytes
+		s := stats
 		m.Unlock()
+		if s.TooBig == 1 && tooBig(err) && len(crit.Aliases) > 0 {
+			log.Printf("%s answered tooBig for %s, which has aliases so can't be asked for fewer values per request\n", p.Host, crit.OID)
+		}
+		if fields, start, ok := timer.done(); ok && err == nil {
+			if err := send(elapsedMeasurement, elapsedTags(p.Host, crit.OID), fields, start); err != nil {
+				log.Printf("elapsed time error for %s: %s\n", p.Host, err)
+			}
+		}
+		if cfg.Common.SelfMetrics {
+			name, tags, fields := trafficPoint(p.Host, mibID, crit.OID, s)
+			if err := send(name, tags, fields, time.Now()); err != nil {
+				log.Printf("self-metrics error for %s: %s\n", p.Host, err)
+			}
+		}
 	}
 	name := fmt.Sprintf("%s/%s", p.Host, mibID)
 	addStats(name, func() snmpStats {
 		m.Lock()
 		s := stats
+		s.Recent = recent.list()
 		m.Unlock()
+		s.Repetitions = int(repetitions(p.Host))
 		return s
 	})
-	if err := snmp.Poller(p, crit, sender, errFn, logger); err != nil {
-		log.Printf("error polling host %s: %s\n", p.Host, err)
+	return sender, errFn
+}
+
+// gather polls a table, passing the results to every walk sharing it
+func gather(walks []tableWalk) {
+	w := walks[0]
+	crit := walkCrit(walks)
+	scheduled(w, time.Now())
+	sender, errFn := collector(w)
+	if len(walks) > 1 {
+		senders := []snmp.Sender{ownColumns(w, crit.OID, sender)}
+		errFns := []snmp.ErrFunc{errFn}
+		stops := []chan struct{}{w.stop}
+		for _, w := range walks[1:] {
+			sender, errFn := collector(w)
+			senders = append(senders, ownColumns(w, crit.OID, sender))
+			errFns = append(errFns, errFn)
+			stops = append(stops, w.stop)
+		}
+		sender, errFn = fanout(senders, errFns, stops)
+	}
+	sender, errFn = cycleGate(sender, errFn)
+	stop := walkStop(walks)
+	if w.info.Config.Async {
+		sender, errFn = asyncSender(sender, errFn, stop)
+	}
+	slots := slotsFor(w.profile.Host, w.info.Config.Parallel)
+	if sched != nil {
+		// the worker pool calls quit.Done when the polls are complete
+		schedule(w.profile, w.info.Config, crit, slots, stop, sender, errFn)
+		return
+	}
+	// walks are started a cycle at a time, so OIDs that keep failing can be quarantined
+	cyclePoll(w.profile, w.info.Config, crit, slots, stop, sender, errFn)
+}
+
+// agentChain wraps the agent's sender with the steps every point it sends goes through
+func agentChain(send SendFunc, agent string) SendFunc {
+	return ScriptSender(ValidSender(ConformSender(TenantSender(LastSender(MetricsSender(send)), tenantOf(agent)))))
+}
+
+// startAgents starts the walks of the agents, and the per host pollers they need
+func startAgents(agents []snmpInfo) {
+	walks := newWalkCache()
+	for _, a := range agents {
+		// validate ensures there is one
+		sender := senders[classSenderName(a.Name)]
+		events := senders[senderName(a.Name)+eventsName]
+		send := EventRouter(sendFunc(sender, a.MIB.Retention), events, a.MIB.Retention)
+		send = agentChain(send, a.Name)
+		dest := fmt.Sprintf("%p/%s", sender, a.MIB.Retention)
+		stop := agentStop(a.Name)
+		for _, profile := range a.Config.profiles() {
+			polling(a.Name, profile.Host)
+			p, c := profile, a.Config
+			if c.Uptime || c.Timestamp == "uptime" || needsUptime(a.MIB) {
+				startPoller("uptime", p.Host, a.Name, func(stop chan struct{}) { uptimeCheck(send, p, c, stop) })
+			}
+			if c.Inventory > 0 {
+				startPoller("inventory", p.Host, a.Name, func(stop chan struct{}) { inventoryPoller(send, p, c, stop) })
+			}
+			if c.Topology > 0 {
+				startPoller("topology", p.Host, a.Name, func(stop chan struct{}) { topologyPoller(send, p, c, stop) })
+			}
+			for _, crit := range criteria(a.Config, a.MIB) {
+				walks.add(tableWalk{send, profile, crit, a, dest, stop})
+			}
+		}
+	}
+	for _, list := range walks.list() {
+		quit.Add(1)
+		go gather(list)
 	}
-	quit.Done()
 }
 
 // agentList returns an array of snmp hosts and their associated mib info
 func agentList() ([]snmpInfo, error) {
-	info := make([]snmpInfo, 0, len(cfg.Snmp))
-	for name, c := range cfg.Snmp {
-		if c.Disabled {
-			continue
-		}
-		if len(c.Mibs) > 0 {
-			for _, m := range strings.Fields(c.Mibs) {
-				mib, ok := cfg.Mibs[m]
-				if !ok {
+	snmps := snmpConfigs()
+	info := make([]snmpInfo, 0, len(snmps))
+	for name, c := range snmps {
+		agents, err := configAgents(name, c)
+		if err != nil {
+			return info, err
+		}
+		info = append(info, agents...)
+	}
+	return info, nil
+}
+
+// configAgents returns the hosts of the snmp config with each of its mib configs
+func configAgents(name string, c *SnmpConfig) ([]snmpInfo, error) {
+	if c.Disabled {
+		return nil, nil
+	}
+	var info []snmpInfo
+	if len(c.Mibs) > 0 {
+		for _, m := range strings.Fields(c.Mibs) {
+			mib, ok := mibConfigs()[m]
+			if !ok {
+				if mib, ok = presets[m]; !ok {
 					return info, fmt.Errorf("no mib config found for:%s", m)
 				}
-				info = append(info, snmpInfo{name, c, mib})
 			}
-			continue
+			info = append(info, snmpInfo{name, c, mib, m})
 		}
-		mib, ok := cfg.Mibs[name]
-		if !ok {
-			if mib, ok = cfg.Mibs["*"]; !ok {
-				return info, fmt.Errorf("no mib config found for:%s", name)
-			}
+		return info, nil
+	}
+	// discovered agents use the mibs of the snmp config they were copied from
+	group := name
+	if from, ok := discoveredFrom(name); ok {
+		group = from
+	}
+	mibs := mibConfigs()
+	mib, ok := mibs[group]
+	if !ok {
+		group = "*"
+		if mib, ok = mibs[group]; !ok {
+			return info, fmt.Errorf("no mib config found for:%s", name)
 		}
-		info = append(info, snmpInfo{name, c, mib})
 	}
-	return info, nil
+	return append(info, snmpInfo{name, c, mib, group}), nil
 }
 
 // filtered returns a list of all OIDs encountered by
@@ -368,12 +887,13 @@ func filtered(a []snmpInfo) []string {
 		for _, name := range strings.Fields(s.MIB.Name) {
 			for _, profile := range s.Config.profiles() {
 				wg.Add(1)
-				go func(p snmp.Profile, oid string) {
+				go func(p snmp.Profile, c *SnmpConfig, oid string) {
+					p, _ = viaProxy(p, c)
 					if err := coll.Poll(p, oid); err != nil {
 						log.Println("poller error:", err)
 					}
 					wg.Done()
-				}(profile, name)
+				}(profile, s.Config, name)
 			}
 		}
 	}
@@ -390,12 +910,12 @@ func sampler(agents []snmpInfo) {
 		for _, profile := range a.Config.profiles() {
 			for _, crit := range criteria(a.Config, a.MIB) {
 				wg.Add(1)
-				go func(p snmp.Profile, crit snmp.Criteria) {
-					if err := snmp.Sampler(p, crit, sender); err != nil {
+				go func(p snmp.Profile, c *SnmpConfig, crit snmp.Criteria) {
+					if err := sampleAgent(p, c, crit, sender); err != nil {
 						log.Printf("error sampling host %s: %s\n", p.Host, err)
 					}
 					wg.Done()
-				}(profile, crit)
+				}(profile, a.Config, crit)
 			}
 		}
 	}
@@ -407,61 +927,168 @@ func dumper(agents []snmpInfo) error {
 	if len(mibs) == 0 {
 		return fmt.Errorf("error: no MIBs specified")
 	}
+	printMibCache(os.Stderr)
+	scope, err := scoped(agents, agentScope, mibScope)
+	if err != nil {
+		return err
+	}
 	var oids []string
-	if filter {
-		oids = filtered(agents)
+	switch {
+	case filter:
+		oids = filtered(scope)
+	case len(agentScope) > 0 || len(mibScope) > 0:
+		oids = scopeNames(scope)
 	}
 	return snmp.OIDList(mibs, oids, os.Stdout)
 }
 
 func main() {
+	loadConfig()
+	if printCfg {
+		printConfig(os.Stdout)
+		return
+	}
+
+	sources, err := discover()
+	if err != nil {
+		fatal(exitConfig, "%s", err)
+	}
 	agents, err := agentList()
 	if err != nil {
-		panic(err)
+		fatal(exitConfig, "%s", err)
+	}
+	if err := validate(agents); err != nil {
+		fatal(exitConfig, "%s", err)
 	}
 
 	if dump {
 		if err := dumper(agents); err != nil {
-			panic(err)
+			fatal(exitSNMP, "%s", err)
 		}
 		return
 	}
 
 	// Load or generate mib data
-	if len(cfg.Common.MibFile) == 0 {
-		fmt.Println("no mibfile specified")
-		os.Exit(1)
+	if err := loadMibs(); err != nil {
+		fatal(exitConfig, "%s", err)
 	}
-	for _, file := range strings.Fields(cfg.Common.MibFile) {
-		if err := snmp.LoadMIBs(file, mibs); err != nil {
-			panic(err)
+	names, err := mibNames()
+	if err != nil {
+		fatal(exitConfig, "cannot read mib names: %s", err)
+	}
+	setMibOIDs(names)
+	if err := expandNames(agents, names); err != nil {
+		fatal(exitConfig, "%s", err)
+	}
+	if err := checkOIDs(agents, names); err != nil {
+		fatal(exitConfig, "%s", err)
+	}
+	if check {
+		return
+	}
+
+	if len(grafana) > 0 {
+		// after the mibs are loaded, so tables are graphed by their columns
+		if err := grafanaDump(agents, os.Stdout); err != nil {
+			fatal(exitConfig, "%s", err)
 		}
+		return
 	}
 
+	if sample && len(baseline) > 0 {
+		differ, err := sampleCompare(agents, baseline, os.Stdout)
+		if err != nil {
+			fatal(exitConfig, "%s", err)
+		}
+		if differ > 0 {
+			os.Exit(exitFailed)
+		}
+		return
+	}
 	if sample {
-		sampler(agents)
+		if len(sampleFmt) == 0 || sampleFmt == "text" {
+			sampler(agents)
+		} else if err := sampleDump(agents, sampleFmt, os.Stdout); err != nil {
+			fatal(exitConfig, "%s", err)
+		}
 		return
 	}
 
-	senders := getSenders()
-	for _, a := range agents {
-		send, ok := senders[a.Name]
-		if !ok {
-			send, ok = senders["*"]
-			if !ok {
-				panic("No sender for: " + a.Name)
-			}
+	if benchmark > 0 {
+		bencher(agents, benchmark, os.Stdout)
+		return
+	}
+
+	if selfTest {
+		if selftest(agents) > 0 {
+			os.Exit(exitFailed)
 		}
-		for _, profile := range a.Config.profiles() {
-			for _, crit := range criteria(a.Config, a.MIB) {
-				quit.Add(1)
-				go gather(send, profile, crit, a.Name)
-			}
+		return
+	}
+
+	if len(schemaFmt) > 0 {
+		if err := schemaDump(agents, schemaFmt, os.Stdout); err != nil {
+			fatal(exitConfig, "%s", err)
+		}
+		return
+	}
+
+	if len(cfg.Common.AliasFile) > 0 {
+		loadAliases(cfg.Common.AliasFile)
+		if cfg.Common.AliasRefresh > 0 {
+			go aliasRefresher(cfg.Common.AliasFile, cfg.Common.AliasRefresh)
+		}
+	}
+
+	loadEnrichers()
+
+	if cfg.Common.Probe {
+		if pct := reachability(agents, os.Stdout); pct < cfg.Common.MinReachable {
+			fatal(exitSNMP, "only %.0f%% of agents are reachable, %.0f%% are required", pct, cfg.Common.MinReachable)
 		}
 	}
 
+	if senders, err = getSenders(); err != nil {
+		fatal(exitDB, "%s", err)
+	}
+	if cfg.Common.Workers > 0 {
+		sched = newScheduler(cfg.Common.Workers)
+	}
+	startAgents(agents)
+
 	if httpPort > 0 {
 		go webServer(httpPort)
 	}
+	if peers := strings.Fields(cfg.Common.Peers); len(peers) > 0 {
+		go peerExchange(peers)
+	}
+	if cfg.Common.MemoryLimit > 0 || cfg.Common.SelfMetrics {
+		go memoryMonitor()
+	}
+	if len(cfg.Input.Listen) > 0 {
+		// validate ensures there is one
+		go inputListener(senders[cfg.Input.influxName()])
+	}
+	if len(sources) > 0 {
+		// keep running while the sources are watched, even with no agents to poll
+		quit.Add(1)
+		watchDiscovery(sources)
+	}
+	if kubeMap != nil {
+		go kubeMap.follow()
+	}
+
+	go signalHandler()
+	annotator("influxsnmp started", "collector")
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		sig := <-c
+		if err := annotate("influxsnmp stopped: "+sig.String(), "collector"); err != nil {
+			log.Println("annotation error:", err)
+		}
+		shutdown(shutdownTimeout())
+		os.Exit(0)
+	}()
 	quit.Wait()
 }
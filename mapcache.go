@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// cacheFile returns the path of the named mapping in the cache directory
+func cacheFile(parts ...string) string {
+	name := strings.Join(parts, "_")
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == os.PathSeparator || r == ':' {
+			return '-'
+		}
+		return r
+	}, name)
+	return filepath.Join(cfg.Common.CacheDir, name+".json")
+}
+
+// saveMapping writes the mapping to the cache directory, if there is one.
+// It is written to a temporary file first so a crash can't leave it partial
+func saveMapping(v interface{}, parts ...string) error {
+	if len(cfg.Common.CacheDir) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	path := cacheFile(parts...)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadMapping reads a mapping saved by an earlier run.
+// It returns false if there is none
+func loadMapping(v interface{}, parts ...string) bool {
+	if len(cfg.Common.CacheDir) == 0 {
+		return false
+	}
+	data, err := ioutil.ReadFile(cacheFile(parts...))
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(data, v) == nil
+}
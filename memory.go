@@ -0,0 +1,264 @@
+package main
+
+import (
+	"log"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// pointBytes is the approximate memory used by a queued point or a cached value
+	pointBytes = 300
+	// memoryCheck is how often memory use is checked against the limit
+	memoryCheck = 5 * time.Second
+	// shedHigh is the percentage of the limit at which memory is shed,
+	// and shedLow where the heap must fall to before it is shed again
+	shedHigh = 90
+	shedLow  = 70
+	// memoryMeasurement records the collector's memory use with the self-metrics
+	memoryMeasurement = "influxsnmp_memory"
+)
+
+// shedCount is the number of queued points dropped to stay under the memory limit
+var shedCount int64
+
+// memoryStats is the collector's approximate memory use
+type memoryStats struct {
+	HeapMB   float64 `json:"heap_mb"`
+	QueuedMB float64 `json:"queued_mb"` // points waiting to be written
+	CachedMB float64 `json:"cached_mb"` // values kept for /metrics, /api/last, deltas, top rows and name tables
+	LimitMB  int     `json:"limit_mb,omitempty"`
+	Shed     int64   `json:"shed"`
+}
+
+// shedder is a sender that can drop queued points to free memory
+type shedder interface {
+	shed(fraction float64) int
+}
+
+// shed drops the oldest of the queued points, to free memory
+func (s *influxSender) shed(fraction float64) int {
+	dropped := 0
+	for _, q := range s.pts {
+		for n := int(float64(len(q)) * fraction); n > 0; n-- {
+			select {
+			case <-q:
+				dropped++
+			default:
+				n = 0
+			}
+		}
+	}
+	if dropped > 0 {
+		s.record(0, dropped, nil)
+	}
+	return dropped
+}
+
+// size returns the number of rows kept
+func (l *lastRows) size() int {
+	l.Lock()
+	defer l.Unlock()
+	n := 0
+	for _, measurements := range l.hosts {
+		for _, series := range measurements {
+			n += len(series)
+		}
+	}
+	return n
+}
+
+// reset drops all rows, which are kept again as they are polled
+func (l *lastRows) reset() {
+	l.Lock()
+	l.hosts = make(map[string]map[string]map[string]*lastRow)
+	l.Unlock()
+}
+
+// size returns the number of values kept
+func (g *gateway) size() int {
+	g.Lock()
+	defer g.Unlock()
+	return len(g.gauges)
+}
+
+// reset drops all values, which are kept again as they are polled
+func (g *gateway) reset() {
+	g.Lock()
+	g.gauges = make(map[string]*gauge)
+	g.Unlock()
+}
+
+// cache is state kept for an agent, which counts towards its memory use
+type cache interface {
+	size() int // number of values kept
+}
+
+// caches are the caches of each host, included in the memory estimate.
+// They aren't dropped when memory is shed, as deltas, top rows and the name
+// tables would be wrong or missing until they were rebuilt
+var caches = struct {
+	sync.Mutex
+	hosts map[string][]cache
+}{hosts: make(map[string][]cache)}
+
+// addCache includes the host's cache in the memory estimate
+func addCache(host string, c cache) {
+	caches.Lock()
+	caches.hosts[host] = append(caches.hosts[host], c)
+	caches.Unlock()
+}
+
+// removeCaches drops the host's caches from the memory estimate
+func removeCaches(host string) {
+	caches.Lock()
+	delete(caches.hosts, host)
+	caches.Unlock()
+}
+
+// cacheList returns all of the caches
+func cacheList() []cache {
+	caches.Lock()
+	defer caches.Unlock()
+	var list []cache
+	for _, c := range caches.hosts {
+		list = append(list, c...)
+	}
+	return list
+}
+
+// size returns the number of counters kept
+func (d *deltas) size() int {
+	d.Lock()
+	defer d.Unlock()
+	return len(d.last)
+}
+
+// reset drops the counters, so the next poll has no deltas
+func (d *deltas) reset() {
+	d.Lock()
+	d.last = make(map[string]uint64)
+	d.Unlock()
+}
+
+// size returns the number of rows held
+func (t *topRows) size() int {
+	t.Lock()
+	defer t.Unlock()
+	return len(t.rows)
+}
+
+// reset drops the rows held for the poll in progress
+func (t *topRows) reset() {
+	t.Lock()
+	t.rows = make(map[string]*topRow)
+	t.Unlock()
+}
+
+// size returns the number of names kept
+func (t *ifTable) size() int {
+	t.Lock()
+	defer t.Unlock()
+	return len(t.names)
+}
+
+// size returns the number of keys and names kept
+func (j *join) size() int {
+	j.Lock()
+	defer j.Unlock()
+	return len(j.keys) + len(j.names)
+}
+
+// megabytes converts bytes to MB
+func megabytes(n uint64) float64 {
+	return float64(n) / (1 << 20)
+}
+
+// memoryUse returns the collector's approximate memory use
+func memoryUse() memoryStats {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	queued := 0
+	for _, s := range senderStats() {
+		queued += s.Queued
+	}
+	cached := latest.size() + metrics.size()
+	for _, c := range cacheList() {
+		cached += c.size()
+	}
+	return memoryStats{
+		HeapMB:   megabytes(ms.HeapAlloc),
+		QueuedMB: megabytes(uint64(queued * pointBytes)),
+		CachedMB: megabytes(uint64(cached * pointBytes)),
+		LimitMB:  cfg.Common.MemoryLimit,
+		Shed:     atomic.LoadInt64(&shedCount),
+	}
+}
+
+// shedMemory frees memory by dropping half of the queued points, oldest first,
+// and the values kept for /metrics and /api/last, which are kept again as they are polled
+func shedMemory() {
+	dropped := 0
+	for _, s := range senders {
+		if sh, ok := s.(shedder); ok {
+			dropped += sh.shed(0.5)
+		}
+	}
+	atomic.AddInt64(&shedCount, int64(dropped))
+	latest.reset()
+	metrics.reset()
+	debug.FreeOSMemory()
+	log.Printf("ALERT: memory limit reached, dropped %d queued points and the last values\n", dropped)
+}
+
+// memoryMonitor keeps the collector under the memory limit, if there is one,
+// and saves its memory use with the self-metrics
+func memoryMonitor() {
+	limit := uint64(cfg.Common.MemoryLimit) << 20
+	if limit > 0 {
+		// collect garbage harder as the limit is approached, before anything is dropped
+		debug.SetMemoryLimit(int64(limit))
+	}
+	var last time.Time
+	shed := false
+	for !stopping() {
+		time.Sleep(memoryCheck)
+		if limit > 0 {
+			var ms runtime.MemStats
+			runtime.ReadMemStats(&ms)
+			// once shed, wait for the heap to come down before shedding again
+			switch {
+			case !shed && ms.HeapAlloc > limit*shedHigh/100:
+				shedMemory()
+				shed = true
+			case shed && ms.HeapAlloc < limit*shedLow/100:
+				shed = false
+			}
+		}
+		if !cfg.Common.SelfMetrics || time.Since(last) < time.Minute {
+			continue
+		}
+		last = time.Now()
+		s, ok := senders[senderName("")]
+		if !ok {
+			continue
+		}
+		m := memoryUse()
+		tags := map[string]string{}
+		if len(cfg.Common.Station) > 0 {
+			tags["station"] = cfg.Common.Station
+		}
+		fields := map[string]interface{}{
+			"heap_mb":   m.HeapMB,
+			"queued_mb": m.QueuedMB,
+			"cached_mb": m.CachedMB,
+			"shed":      m.Shed,
+		}
+		if err := s.Send("", memoryMeasurement, tags, fields, last); err != nil {
+			log.Printf("memory self-metrics error: %s\n", err)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultMetricsExpire is how many seconds an unrefreshed value is served
+const defaultMetricsExpire = 600
+
+// gauge is the last value of a series field
+type gauge struct {
+	name   string
+	labels map[string]string
+	value  float64
+	ts     time.Time
+}
+
+// gateway keeps the last value of every series to serve as OpenMetrics
+type gateway struct {
+	sync.Mutex
+	expire time.Duration
+	gauges map[string]*gauge
+}
+
+var metrics = &gateway{gauges: make(map[string]*gauge)}
+
+// metricName converts a name to a valid metric or label name
+func metricName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c == ':':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// labelValue escapes a label value
+var labelValue = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (g *gateway) save(name string, tags map[string]string, fields map[string]interface{}, ts time.Time) {
+	g.Lock()
+	defer g.Unlock()
+	for field, value := range fields {
+		var f float64
+		switch v := value.(type) {
+		case bool:
+			if v {
+				f = 1
+			}
+		default:
+			var ok bool
+			if f, ok = toFloat(value); !ok {
+				continue
+			}
+		}
+		metric := name
+		if field != "value" {
+			metric += "_" + field
+		}
+		metric = metricName(metric)
+		key := seriesKey(metric, tags)
+		gg, ok := g.gauges[key]
+		if !ok {
+			gg = &gauge{name: metric, labels: tags}
+			g.gauges[key] = gg
+		}
+		gg.value = f
+		gg.ts = ts
+	}
+}
+
+// write outputs the current values in OpenMetrics text format,
+// dropping those not updated within the expiry period
+func (g *gateway) write(w *bufio.Writer) {
+	cutoff := time.Now().Add(-g.expire)
+	g.Lock()
+	list := make([]*gauge, 0, len(g.gauges))
+	for key, gg := range g.gauges {
+		if g.expire > 0 && gg.ts.Before(cutoff) {
+			delete(g.gauges, key)
+			continue
+		}
+		list = append(list, gg)
+	}
+	g.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].name != list[j].name {
+			return list[i].name < list[j].name
+		}
+		return seriesKey("", list[i].labels) < seriesKey("", list[j].labels)
+	})
+	last := ""
+	for _, gg := range list {
+		if gg.name != last {
+			fmt.Fprintf(w, "# TYPE %s gauge\n", gg.name)
+			last = gg.name
+		}
+		w.WriteString(gg.name)
+		if len(gg.labels) > 0 {
+			keys := make([]string, 0, len(gg.labels))
+			for k := range gg.labels {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for i, k := range keys {
+				sep := ","
+				if i == 0 {
+					sep = "{"
+				}
+				fmt.Fprintf(w, `%s%s="%s"`, sep, metricName(k), labelValue.Replace(gg.labels[k]))
+			}
+			w.WriteString("}")
+		}
+		ts := float64(gg.ts.UnixNano()) / 1e9
+		fmt.Fprintf(w, " %s %s\n", strconv.FormatFloat(gg.value, 'g', -1, 64), strconv.FormatFloat(ts, 'f', 3, 64))
+	}
+	w.WriteString("# EOF\n")
+}
+
+// ServeHTTP serves the last polled values in OpenMetrics format
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	g.write(bw)
+	if err := bw.Flush(); err != nil {
+		log.Printf("metrics error:%s\n", err)
+	}
+}
+
+// MetricsSender keeps the last values sent to serve on /metrics
+func MetricsSender(send SendFunc) SendFunc {
+	if !cfg.Common.OpenMetrics {
+		return send
+	}
+	expire := cfg.Common.MetricsExpire
+	if expire == 0 {
+		expire = defaultMetricsExpire
+	}
+	metrics.expire = time.Duration(expire) * time.Second
+	return func(name string, tags map[string]string, fields map[string]interface{}, ts time.Time) error {
+		metrics.save(name, tags, fields, ts)
+		return send(name, tags, fields, ts)
+	}
+}
+
+// remove drops the values of the host's series
+func (g *gateway) remove(host string) {
+	g.Lock()
+	for key, gg := range g.gauges {
+		if gg.labels["host"] == host {
+			delete(g.gauges, key)
+		}
+	}
+	g.Unlock()
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// the states of a mib file
+const (
+	mibStatic = "static" // provided rather than generated, so loaded as it is
+	mibHit    = "hit"    // generated from the current MIB sources
+	mibMiss   = "miss"   // not generated yet
+	mibStale  = "stale"  // generated from MIB sources that have since changed
+)
+
+// mibCacheEntry is the state of a mib file
+type mibCacheEntry struct {
+	File     string `json:"file"`
+	Path     string `json:"path"` // where it is loaded from
+	Checksum string `json:"checksum,omitempty"`
+	Status   string `json:"status"`
+}
+
+// mibCacheStatus is the state of each mib file when it was loaded
+var mibCacheStatus []mibCacheEntry
+
+// mibDirs returns the directories net-snmp reads MIBs from when the mib file is generated
+func mibDirs() []string {
+	dirs := []string{filepath.Join(os.Getenv("HOME"), ".snmp", "mibs"), "/usr/share/snmp/mibs"}
+	env := os.Getenv("MIBDIRS")
+	switch {
+	case len(env) == 0:
+	case env[0] == '+':
+		dirs = append(dirs, filepath.SplitList(env[1:])...)
+	case env[0] == '-':
+		dirs = append(filepath.SplitList(env[1:]), dirs...)
+	default:
+		dirs = filepath.SplitList(env)
+	}
+	return dirs
+}
+
+// mibChecksum returns the checksum of the mibs list and the MIB sources
+// that a mib file would be generated from
+func mibChecksum(mibs string) (string, error) {
+	h := sha256.New()
+	io.WriteString(h, mibs)
+	for _, dir := range mibDirs() {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			io.WriteString(h, path)
+			_, err = io.Copy(h, f)
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
+
+// mibCache returns where to load the mib file from, and whether it must be generated.
+// A file that exists but was not generated by the collector is used as it is.
+// Otherwise it is keyed by the checksum of its sources: in the cache directory,
+// if there is one, or with the checksum saved alongside it
+func mibCache(file, mibs string) mibCacheEntry {
+	e := mibCacheEntry{File: file, Path: file}
+	_, err := os.Stat(file)
+	exists := err == nil
+	if _, err := os.Stat(file + ".sum"); err != nil && exists {
+		e.Status = mibStatic
+		return e
+	}
+	sum, err := mibChecksum(mibs)
+	if err != nil {
+		log.Printf("cannot checksum MIB sources for %s: %s\n", file, err)
+		e.Status = mibStatic
+		return e
+	}
+	e.Checksum = sum
+	if len(cfg.Common.CacheDir) > 0 {
+		e.Path = cacheFile("mibs", sum)
+		_, err := os.Stat(e.Path)
+		exists = err == nil
+	}
+	switch saved, _ := ioutil.ReadFile(e.Path + ".sum"); {
+	case !exists:
+		e.Status = mibMiss
+	case len(cfg.Common.CacheDir) > 0 || strings.TrimSpace(string(saved)) == sum:
+		e.Status = mibHit
+	default:
+		e.Status = mibStale
+	}
+	return e
+}
+
+// loadMibs loads the mib files, generating those that are missing or stale
+func loadMibs() error {
+	for _, file := range strings.Fields(cfg.Common.MibFile) {
+		e := mibCache(file, mibs)
+		if e.Status == mibStale {
+			if err := os.Remove(e.Path); err != nil {
+				return err
+			}
+		}
+		if err := snmp.LoadMIBs(e.Path, mibs); err != nil {
+			return fmt.Errorf("cannot load mibs from %s: %s", e.Path, err)
+		}
+		if e.Status == mibMiss || e.Status == mibStale {
+			if err := ioutil.WriteFile(e.Path+".sum", []byte(e.Checksum+"\n"), 0644); err != nil {
+				log.Printf("cannot save checksum of %s: %s\n", e.Path, err)
+			}
+		}
+		mibCacheStatus = append(mibCacheStatus, e)
+	}
+	return nil
+}
+
+// printMibCache writes the state of each mib file
+func printMibCache(w io.Writer) {
+	for _, file := range strings.Fields(cfg.Common.MibFile) {
+		e := mibCache(file, mibs)
+		fmt.Fprintf(w, "mib file %s: %s %s %s\n", e.File, e.Status, e.Path, e.Checksum)
+	}
+}
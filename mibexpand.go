@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// oidLess orders oids numerically, by sub-identifier
+func oidLess(a, b string) bool {
+	x, y := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(x) && i < len(y); i++ {
+		m, _ := strconv.Atoi(x[i])
+		n, _ := strconv.Atoi(y[i])
+		if m != n {
+			return m < n
+		}
+	}
+	return len(x) < len(y)
+}
+
+// subtree returns the leaves below the oid, i.e., the columns of a table or entry,
+// by name where they have one
+func subtree(oid string, names map[string]string) []string {
+	byOID := make(map[string]string)
+	var below []string
+	for name, o := range names {
+		o = strings.Trim(o, ".")
+		if strings.HasPrefix(o, oid+".") {
+			byOID[o] = name
+			below = append(below, o)
+		}
+	}
+	sort.Slice(below, func(i, j int) bool { return oidLess(below[i], below[j]) })
+	var leaves []string
+	for i, o := range below {
+		// sorted, so a node's descendants follow it
+		if i+1 < len(below) && strings.HasPrefix(below[i+1], o+".") {
+			continue
+		}
+		leaves = append(leaves, byOID[o])
+	}
+	return leaves
+}
+
+// subRange expands a sub-identifier range, e.g., "[6-10]" or "[1,3,5-7]"
+func subRange(spec string) ([]string, error) {
+	if !strings.HasPrefix(spec, "[") || !strings.HasSuffix(spec, "]") {
+		if _, err := strconv.ParseUint(spec, 10, 32); err != nil {
+			return nil, fmt.Errorf("invalid sub-identifier: %s", spec)
+		}
+		return []string{spec}, nil
+	}
+	var list []string
+	for _, part := range strings.Split(strings.Trim(spec, "[]"), ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		lo, err := strconv.ParseUint(bounds[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range: %s", spec)
+		}
+		hi := lo
+		if len(bounds) == 2 {
+			if hi, err = strconv.ParseUint(bounds[1], 10, 32); err != nil || hi < lo {
+				return nil, fmt.Errorf("invalid range: %s", spec)
+			}
+		}
+		for n := lo; n <= hi; n++ {
+			list = append(list, strconv.FormatUint(n, 10))
+		}
+	}
+	return list, nil
+}
+
+// oidRange expands the ranges of a numeric oid, e.g., "1.3.6.1.2.1.31.1.1.1.[6-10]"
+func oidRange(spec string) ([]string, error) {
+	list := []string{""}
+	for _, sub := range strings.Split(strings.Trim(spec, "."), ".") {
+		values, err := subRange(sub)
+		if err != nil {
+			return nil, err
+		}
+		var next []string
+		for _, prefix := range list {
+			for _, v := range values {
+				next = append(next, strings.TrimPrefix(prefix+"."+v, "."))
+			}
+		}
+		list = next
+	}
+	return list, nil
+}
+
+// expandName expands a wildcard (name.* or oid.*) to the columns below it,
+// or a numeric oid with ranges to its oids. The oids are named where the mibs define them
+func expandName(spec string, names map[string]string) ([]string, error) {
+	if base := strings.TrimSuffix(spec, ".*"); base != spec {
+		oid, ok := names[base]
+		if !ok && numeric(base) {
+			oid, ok = base, true
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown name %s", base)
+		}
+		return subtree(strings.Trim(oid, "."), names), nil
+	}
+	if !strings.Contains(spec, "[") {
+		return []string{spec}, nil
+	}
+	oids, err := oidRange(spec)
+	if err != nil {
+		return nil, err
+	}
+	byOID := make(map[string]string, len(names))
+	for name, o := range names {
+		byOID[strings.Trim(o, ".")] = name
+	}
+	for i, oid := range oids {
+		if name, ok := byOID[oid]; ok {
+			oids[i] = name
+		}
+	}
+	return oids, nil
+}
+
+// expandNames replaces the wildcards and ranges in the names of the mib configs
+// polled by the agents with the columns they match
+func expandNames(agents []snmpInfo, names map[string]string) error {
+	for _, key := range usedMibs(agents) {
+		m := mibConfig(key)
+		if !strings.ContainsAny(m.Name, "*[") {
+			continue
+		}
+		var list []string
+		seen := make(map[string]bool)
+		for _, spec := range strings.Fields(m.Name) {
+			expanded, err := expandName(spec, names)
+			if err != nil {
+				return fmt.Errorf("mib config %s: %s", key, err)
+			}
+			if len(expanded) == 0 {
+				return fmt.Errorf("mib config %s: %s matches nothing in the loaded mibs", key, spec)
+			}
+			for _, name := range expanded {
+				if !seen[name] {
+					seen[name] = true
+					list = append(list, name)
+				}
+			}
+		}
+		m.Name = strings.Join(list, " ")
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSubRange(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []string
+		fail bool
+	}{
+		{spec: "6", want: []string{"6"}},
+		{spec: "[6-8]", want: []string{"6", "7", "8"}},
+		{spec: "[1,3,5-7]", want: []string{"1", "3", "5", "6", "7"}},
+		{spec: "[4-4]", want: []string{"4"}},
+		{spec: "[8-6]", fail: true},
+		{spec: "[a-b]", fail: true},
+		{spec: "[1,]", fail: true},
+		{spec: "x", fail: true},
+		{spec: "-1", fail: true},
+	}
+	for _, tt := range tests {
+		got, err := subRange(tt.spec)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %v", tt.spec, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.spec, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestOIDRange(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []string
+		fail bool
+	}{
+		{spec: "1.3.6.1.2.1.1.3", want: []string{"1.3.6.1.2.1.1.3"}},
+		{spec: ".1.3.6.1.2.1.31.1.1.1.[6-8]", want: []string{
+			"1.3.6.1.2.1.31.1.1.1.6", "1.3.6.1.2.1.31.1.1.1.7", "1.3.6.1.2.1.31.1.1.1.8",
+		}},
+		{spec: "1.3.[1,2].[5-6]", want: []string{"1.3.1.5", "1.3.1.6", "1.3.2.5", "1.3.2.6"}},
+		{spec: "1.3.[2-1]", fail: true},
+		{spec: "1.3.x", fail: true},
+		{spec: "1..3", fail: true},
+	}
+	for _, tt := range tests {
+		got, err := oidRange(tt.spec)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %v", tt.spec, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.spec, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// maxSuggestions limits the closest matches suggested for an unknown name
+const maxSuggestions = 3
+
+// mibNames reads the names defined in the mib files, with their oids. The files list
+// a name and its oid per line, as generated by snmptranslate -Tz
+func mibNames() (map[string]string, error) {
+	names := make(map[string]string)
+	for _, e := range mibCacheStatus {
+		f, err := os.Open(e.Path)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if fields := strings.Fields(scanner.Text()); len(fields) >= 2 {
+				names[strings.Trim(fields[0], `"`)] = strings.Trim(fields[1], `"`)
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+// mibColumns returns the oid names used by the mib config. Deltas and the top column
+// are matched after the agent's renames, so renamed names are mapped back to their oids
+func mibColumns(m *MibConfig, renamed map[string]string) []string {
+	list := strings.Fields(m.Name)
+	if len(m.KeyBy) > 0 {
+		list = append(list, m.KeyBy)
+	}
+	after := strings.Fields(m.Deltas)
+	if _, column, _ := parseTop(m.Top); len(column) > 0 {
+		after = append(after, column)
+	}
+	for _, name := range after {
+		if oid, ok := renamed[name]; ok {
+			name = oid
+		}
+		list = append(list, name)
+	}
+	for _, spec := range m.Joins {
+		if f := strings.Fields(spec); len(f) == 3 {
+			list = append(list, f[0], f[1])
+		}
+	}
+	return list
+}
+
+// numeric returns true if the oid is given by number, which isn't looked up
+func numeric(oid string) bool {
+	_, err := numericOID(oid)
+	return err == nil
+}
+
+// distance returns the edit distance between two names, ignoring case
+func distance(a, b string) int {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// suggestions returns the defined names closest to the unknown one
+func suggestions(name string, names map[string]string) []string {
+	limit := len(name)/3 + 1
+	type match struct {
+		name string
+		dist int
+	}
+	var matches []match
+	for n := range names {
+		if d := distance(name, n); d <= limit {
+			matches = append(matches, match{n, d})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].dist != matches[j].dist {
+			return matches[i].dist < matches[j].dist
+		}
+		return matches[i].name < matches[j].name
+	})
+	var list []string
+	for i := 0; i < len(matches) && i < maxSuggestions; i++ {
+		list = append(list, matches[i].name)
+	}
+	return list
+}
+
+// mibConfig returns the named mib config, which may be a preset
+func mibConfig(name string) *MibConfig {
+	if m, ok := mibConfigs()[name]; ok {
+		return m
+	}
+	return presets[name]
+}
+
+// usedMibs returns the names of the mib configs polled by the agents, sorted
+func usedMibs(agents []snmpInfo) []string {
+	used := make(map[*MibConfig]bool)
+	for _, a := range agents {
+		used[a.MIB] = true
+	}
+	mibs := mibConfigs()
+	var list []string
+	for name, m := range presets {
+		if _, ok := mibs[name]; !ok && used[m] {
+			list = append(list, name)
+		}
+	}
+	for name, m := range mibs {
+		if used[m] {
+			list = append(list, name)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// checkOIDs verifies the names in the mib configs of the agents are defined in the loaded mibs,
+// rather than silently collecting nothing for a mistyped name
+func checkOIDs(agents []snmpInfo, names map[string]string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	// the renames of the agents polling each mib config, from the new name to the oid
+	renames := make(map[*MibConfig]map[string]string)
+	for _, a := range agents {
+		renamed, ok := renames[a.MIB]
+		if !ok {
+			renamed = make(map[string]string)
+			renames[a.MIB] = renamed
+		}
+		for oid, name := range pairs(a.Config.Rename) {
+			renamed[name] = oid
+		}
+	}
+	var problems []string
+	for _, key := range usedMibs(agents) {
+		m := mibConfig(key)
+		for _, name := range mibColumns(m, renames[m]) {
+			if _, ok := names[name]; ok || numeric(name) {
+				continue
+			}
+			problem := fmt.Sprintf("mib config %s: unknown name %s", key, name)
+			if close := suggestions(name, names); len(close) > 0 {
+				problem += " (did you mean " + strings.Join(close, ", ") + "?)"
+			}
+			problems = append(problems, problem)
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"sync"
+)
+
+// hostSlots limit how many walks run at once against each agent
+var hostSlots = struct {
+	sync.Mutex
+	slots map[string]chan struct{}
+}{slots: make(map[string]chan struct{})}
+
+// slotsFor returns the agent's walk slots, creating them with the given size
+func slotsFor(host string, size int) chan struct{} {
+	if size < 1 {
+		size = 1
+	}
+	hostSlots.Lock()
+	defer hostSlots.Unlock()
+	slots, ok := hostSlots.slots[host]
+	if !ok {
+		slots = make(chan struct{}, size)
+		hostSlots.slots[host] = slots
+	}
+	return slots
+}
+
+// withSlot waits for one of the agent's slots to be free before
+// starting the walk, releasing it when the walk ends
+func withSlot(slots chan struct{}, walk func() error) error {
+	slots <- struct{}{}
+	defer func() { <-slots }()
+	return walk()
+}
+
+// removeSlots drops the host's walk slots
+func removeSlots(host string) {
+	hostSlots.Lock()
+	delete(hostSlots.slots, host)
+	hostSlots.Unlock()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// stage is the context a processor is created in
+type stage struct {
+	send    SendFunc
+	profile snmp.Profile
+	info    snmpInfo
+	freq    int
+	stop    chan struct{} // closed when the agent is stopped, nil if it runs until exit
+}
+
+// Processor wraps a sender with a processing step
+type Processor func(snmp.Sender, stage) snmp.Sender
+
+// processors are the available processing steps, by name
+var processors = map[string]Processor{
+	"template":    func(s snmp.Sender, st stage) snmp.Sender { return TemplateSender(s, st.profile, st.info.Config) },
+	"cardinality": func(s snmp.Sender, _ stage) snmp.Sender { return CardinalitySender(s) },
+	"exec":        func(s snmp.Sender, _ stage) snmp.Sender { return ExecSender(s) },
+	"enrich":      func(s snmp.Sender, _ stage) snmp.Sender { return EnrichSender(s) },
+	"alias":       func(s snmp.Sender, st stage) snmp.Sender { return AliasSender(s, st.profile.Host) },
+	"join": func(s snmp.Sender, st stage) snmp.Sender {
+		return JoinSender(s, st.profile, st.info.Name, st.info.Config, st.info.MIB, st.freq, st.stop)
+	},
+	"index": func(s snmp.Sender, st stage) snmp.Sender { return IndexSender(s, st.info.MIB.Indexes) },
+	"key": func(s snmp.Sender, st stage) snmp.Sender {
+		return KeySender(s, st.profile, st.info.Config, st.info.MIB.KeyBy)
+	},
+	"relabel":   func(s snmp.Sender, _ stage) snmp.Sender { return RelabelSender(s) },
+	"sensors":   func(s snmp.Sender, _ stage) snmp.Sender { return SensorSender(s) },
+	"state":     func(s snmp.Sender, st stage) snmp.Sender { return StateSender(s, st.send) },
+	"threshold": func(s snmp.Sender, st stage) snmp.Sender { return ThresholdSender(s, st.send) },
+	"aggregate": func(s snmp.Sender, st stage) snmp.Sender { return AggregateSender(s, st.send) },
+	"dedup":     func(s snmp.Sender, st stage) snmp.Sender { return DedupSender(s, st.info.MIB.Dedup) },
+	// influxdb saves uint64 as a string
+	// so this is a workaround for now
+	"integer": func(s snmp.Sender, _ stage) snmp.Sender { return snmp.IntegerSender(s) },
+}
+
+// defaultProcessors are applied in this order when an snmp config does not specify them
+const defaultProcessors = "template cardinality exec enrich alias join index key relabel sensors state threshold aggregate dedup integer"
+
+// processorList returns the names of the processors in the order data passes through them
+func processorList(c *SnmpConfig) ([]string, error) {
+	spec := c.Processors
+	if len(spec) == 0 {
+		spec = defaultProcessors
+	}
+	names := strings.Fields(spec)
+	for _, name := range names {
+		if _, ok := processors[name]; !ok {
+			return nil, fmt.Errorf("unknown processor: %s", name)
+		}
+	}
+	return names, nil
+}
+
+// pipeline wraps the sender with the configured processors,
+// so the first listed is the first to see the data
+func pipeline(sender snmp.Sender, st stage) (snmp.Sender, error) {
+	names, err := processorList(st.info.Config)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(names) - 1; i >= 0; i-- {
+		sender = processors[names[i]](sender, st)
+	}
+	return sender, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// fieldPool reuses the field maps of polled values.
+// A SendFunc must not keep the fields after it returns
+var fieldPool = sync.Pool{
+	New: func() interface{} { return make(map[string]interface{}, 2) },
+}
+
+func getFields() map[string]interface{} {
+	return fieldPool.Get().(map[string]interface{})
+}
+
+func putFields(fields map[string]interface{}) {
+	for k := range fields {
+		delete(fields, k)
+	}
+	fieldPool.Put(fields)
+}
+
+// keyBuf is scratch space for building series keys
+type keyBuf struct {
+	keys []string
+	buf  []byte
+}
+
+var keyPool = sync.Pool{
+	New: func() interface{} { return new(keyBuf) },
+}
+
+// seriesKey returns a unique key for a measurement and its tags
+func seriesKey(name string, tags map[string]string) string {
+	kb := keyPool.Get().(*keyBuf)
+	kb.keys = kb.keys[:0]
+	for k := range tags {
+		kb.keys = append(kb.keys, k)
+	}
+	sort.Strings(kb.keys)
+	kb.buf = append(kb.buf[:0], name...)
+	for _, k := range kb.keys {
+		kb.buf = append(kb.buf, ',')
+		kb.buf = append(kb.buf, k...)
+		kb.buf = append(kb.buf, '=')
+		kb.buf = append(kb.buf, tags[k]...)
+	}
+	key := string(kb.buf)
+	keyPool.Put(kb)
+	return key
+}
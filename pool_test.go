@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+var benchTags = map[string]string{
+	"host":    "router1.example.com",
+	"ifName":  "GigabitEthernet0/0/1",
+	"ifAlias": "uplink to core",
+	"mib":     "ifXTable",
+}
+
+func BenchmarkSeriesKey(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		seriesKey("ifHCInOctets", benchTags)
+	}
+}
+
+func BenchmarkCollector(b *testing.B) {
+	send := func(name string, tags map[string]string, fields map[string]interface{}, ts time.Time) error {
+		return nil
+	}
+	w := tableWalk{
+		send:    send,
+		profile: snmp.Profile{Host: "router1.example.com"},
+		crit:    snmp.Criteria{OID: "ifXTable", Freq: 60},
+		info:    snmpInfo{Name: "bench", Config: &SnmpConfig{}, MIB: &MibConfig{}},
+	}
+	sender, _ := collector(w)
+	now := time.Now()
+	ts := snmp.TimeStamp{Start: now, Stop: now}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tags := map[string]string{"ifName": "GigabitEthernet0/0/1", "ifAlias": "uplink to core"}
+		if err := sender("ifHCInOctets", tags, uint64(i), ts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+// presets are built-in mib configs that can be referenced
+// by an snmp config's mibs without being defined
+var presets = map[string]*MibConfig{
+	// BGP4-MIB peer sessions
+	"bgp": {
+		Name: "bgpPeerState bgpPeerAdminStatus bgpPeerRemoteAs bgpPeerInUpdates bgpPeerOutUpdates" +
+			" bgpPeerFsmEstablishedTransitions bgpPeerFsmEstablishedTime",
+	},
+	// CISCO-BGP4-MIB prefix counts
+	"bgp-prefixes": {
+		Name: "cbgpPeerAcceptedPrefixes cbgpPeerDeniedPrefixes cbgpPeerAdvertisedPrefixes",
+	},
+	// ENTITY-SENSOR-MIB sensors, scaled by type, scale and precision
+	"sensors": {
+		Name: "entPhySensorEntry",
+	},
+	// CISCO-ENVMON-MIB temperature, voltage and fan status
+	"cisco-envmon": {
+		Name: "ciscoEnvMonTemperatureStatusEntry ciscoEnvMonVoltageStatusEntry ciscoEnvMonFanStatusEntry",
+	},
+	// LM-SENSORS-MIB (net-snmp) temperatures, fans and voltages
+	"lm-sensors": {
+		Name: "lmTempSensorsEntry lmFanSensorsEntry lmVoltSensorsEntry",
+	},
+	// IP-MIB ARP table, mapping IP to MAC addresses by interface
+	"arp": {
+		Name:    "ipNetToMediaPhysAddress ipNetToMediaType",
+		Indexes: "ifIndex:int ip:ip",
+		Freq:    900,
+	},
+	// Q-BRIDGE-MIB forwarding database, mapping MAC addresses to ports by VLAN
+	"fdb": {
+		Name:    "dot1qTpFdbPort dot1qTpFdbStatus",
+		Indexes: "vlan:int mac:mac",
+		Joins:   []string{"dot1qTpFdbPort dot1dBasePortIfIndex ifIndex"},
+		Freq:    900,
+	},
+	// OSPF-MIB neighbors
+	"ospf": {
+		Name: "ospfNbrState ospfNbrEvents ospfNbrLsRetransQLen",
+	},
+}
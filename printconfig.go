@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// redacted replaces secrets when printing the config
+const redacted = "<redacted>"
+
+// secretKeys are the config keys whose values are never printed
+var secretKeys = map[string]bool{
+	"community":      true,
+	"proxyCommunity": true,
+	"setCommunity":   true,
+	"authPassword":   true,
+	"privPassword":   true,
+	"password":       true,
+	"token":          true,
+	"adminToken":     true,
+}
+
+// configDefaults are the values used for settings that are not given, by section.key
+var configDefaults = map[string]string{
+	"common.invalid":         "drop",
+	"common.metricsExpire":   strconv.Itoa(defaultMetricsExpire),
+	"common.shutdownTimeout": strconv.Itoa(defaultShutdown),
+	"common.errorHistory":    strconv.Itoa(defaultErrorHistory),
+	"snmp.port":              "161",
+	"snmp.timestamp":         "stop",
+	"snmp.parallel":          "1",
+	"snmp.processors":        defaultProcessors,
+	"influx.batchSize":       strconv.Itoa(DefaultBatchSize),
+	"influx.queueSize":       strconv.Itoa(DefaultQueueSize),
+	"influx.flush":           strconv.Itoa(DefaultFlush),
+	"influx.writers":         "1",
+	"influx.precision":       "s",
+	"relabel.separator":      ";",
+	"relabel.regex":          "(.*)",
+	"relabel.replacement":    "$1",
+	"relabel.action":         "replace",
+	"aggregate.window":       strconv.Itoa(DefaultFlush),
+	"script.steps":           strconv.Itoa(defaultSteps),
+}
+
+// envVar matches ${NAME} references in the config file
+var envVar = regexp.MustCompile(`\${([A-Za-z_][A-Za-z0-9_]*)}`)
+
+// expandEnv replaces ${NAME} in the config text with the environment variable's value.
+// Only the braced form is expanded, so a '$' in a community string is left alone
+func expandEnv(text string) string {
+	return envVar.ReplaceAllStringFunc(text, func(ref string) string {
+		return os.Getenv(ref[2 : len(ref)-1])
+	})
+}
+
+// configKey returns the name of the struct field as used in the config file
+func configKey(f reflect.StructField) string {
+	if tag := f.Tag.Get("gcfg"); len(tag) > 0 {
+		return tag
+	}
+	return strings.ToLower(f.Name)
+}
+
+// configValue formats a value as gcfg would read it back
+func configValue(v string) string {
+	if len(v) == 0 || strings.TrimSpace(v) != v || strings.ContainsAny(v, `;#"\`) {
+		return strconv.Quote(v)
+	}
+	return v
+}
+
+// redact hides the value of a secret setting, and any password in a url
+func redact(key, value string) string {
+	if secretKeys[key] {
+		return redacted
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			return strings.Replace(value, ":"+pass+"@", ":"+redacted+"@", 1)
+		}
+	}
+	return value
+}
+
+// printSection writes the non-empty settings of a config section
+func printSection(w io.Writer, header, section string, v reflect.Value) {
+	fmt.Fprintln(w, header)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath != "" {
+			// not part of the config file
+			continue
+		}
+		key := configKey(t.Field(i))
+		f := v.Field(i)
+		var values []string
+		switch f.Kind() {
+		case reflect.Slice:
+			for j := 0; j < f.Len(); j++ {
+				values = append(values, fmt.Sprint(f.Index(j).Interface()))
+			}
+		case reflect.Bool:
+			if f.Bool() {
+				values = append(values, "true")
+			}
+		case reflect.Ptr:
+			if !f.IsNil() {
+				values = append(values, fmt.Sprint(f.Elem().Interface()))
+			}
+		default:
+			if !reflect.DeepEqual(f.Interface(), reflect.Zero(f.Type()).Interface()) {
+				values = append(values, fmt.Sprint(f.Interface()))
+			}
+		}
+		if len(values) == 0 {
+			if def, ok := configDefaults[section+"."+key]; ok {
+				values = append(values, def)
+			}
+		}
+		for _, value := range values {
+			fmt.Fprintf(w, "\t%s = %s\n", key, configValue(redact(key, value)))
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+// printConfig writes the effective configuration, with defaults filled in
+// and secrets redacted, with sections and names in sorted order
+func printConfig(w io.Writer) {
+	v := reflect.ValueOf(cfg)
+	t := v.Type()
+	order := make([]int, t.NumField())
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return t.Field(order[i]).Name < t.Field(order[j]).Name
+	})
+	for _, i := range order {
+		section := strings.ToLower(t.Field(i).Name)
+		f := v.Field(i)
+		switch f.Kind() {
+		case reflect.Struct:
+			printSection(w, "["+section+"]", section, f)
+		case reflect.Map:
+			names := make([]string, 0, f.Len())
+			for _, k := range f.MapKeys() {
+				names = append(names, k.String())
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				header := fmt.Sprintf("[%s %s]", section, strconv.Quote(name))
+				printSection(w, header, section, f.MapIndex(reflect.ValueOf(name)).Elem())
+			}
+		}
+	}
+}
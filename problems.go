@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// problemPolls is how many polling intervals an error is recent for an agent
+// to be failing, and an agent can go without a successful poll before it is silent
+const problemPolls = 3
+
+// problemWindow is used for agents without a polling frequency
+const problemWindow = 15 * time.Minute
+
+// agent states
+const (
+	stateHealthy = "healthy"
+	stateFailing = "failing"
+	stateSilent  = "silent"
+)
+
+// agentState classifies an agent by its recent polls
+func agentState(s snmpStats, now time.Time) string {
+	window := problemWindow
+	if s.Freq > 0 {
+		window = time.Duration(problemPolls*s.Freq) * time.Second
+	}
+	cutoff := now.Add(-window)
+	switch {
+	case s.LastOK.Before(cutoff) && startTime.Before(cutoff):
+		return stateSilent
+	case s.LastTime.After(cutoff):
+		return stateFailing
+	}
+	return stateHealthy
+}
+
+// problem is an agent that is failing or silent
+type problem struct {
+	Name      string       `json:"name"`
+	State     string       `json:"state"`
+	Errors    int          `json:"errors"`
+	LastError string       `json:"last_error,omitempty"`
+	LastTime  time.Time    `json:"last_time"`
+	LastOK    time.Time    `json:"last_ok"`
+	Recent    []errorEntry `json:"recent_errors"`
+}
+
+// problemReport summarizes the health of all agents
+type problemReport struct {
+	Station  string    `json:"station,omitempty"`
+	Total    int       `json:"total"`
+	Healthy  int       `json:"healthy"`
+	Failing  int       `json:"failing"`
+	Silent   int       `json:"silent"`
+	Problems []problem `json:"problems"`
+}
+
+func problems() problemReport {
+	now := time.Now()
+	r := problemReport{Station: cfg.Common.Station, Problems: []problem{}}
+	for name, s := range getStats() {
+		r.Total++
+		state := agentState(s, now)
+		switch state {
+		case stateHealthy:
+			r.Healthy++
+			continue
+		case stateFailing:
+			r.Failing++
+		case stateSilent:
+			r.Silent++
+		}
+		p := problem{Name: name, State: state, Errors: s.ErrCnt, LastTime: s.LastTime, LastOK: s.LastOK, Recent: s.Recent}
+		if s.LastError != nil {
+			p.LastError = s.LastError.Error()
+		}
+		r.Problems = append(r.Problems, p)
+	}
+	sort.Slice(r.Problems, func(i, j int) bool { return r.Problems[i].Name < r.Problems[j].Name })
+	return r
+}
+
+// problemsPage lists only the agents with problems, as html or as json
+// if requested with ?format=json or an Accept header of application/json
+func problemsPage(w http.ResponseWriter, r *http.Request) {
+	report := problems()
+	if r.URL.Query().Get("format") == "json" || r.Header.Get("Accept") == "application/json" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			log.Printf("problems error:%s\n", err)
+		}
+		return
+	}
+	if err := problemsTmpl.Execute(w, report); err != nil {
+		log.Printf("problems error:%s\n", err)
+	}
+}
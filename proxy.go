@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// viaProxy returns the profile to reach the agent through the snmp proxy of its
+// snmp config, if it has one. The proxy selects the agent by community
+func viaProxy(p snmp.Profile, c *SnmpConfig) (snmp.Profile, bool) {
+	if len(c.ProxyHost) == 0 {
+		return p, false
+	}
+	via := p
+	via.Host = c.ProxyHost
+	if h, port, err := net.SplitHostPort(c.ProxyHost); err == nil {
+		via.Host = h
+		via.Port, _ = strconv.Atoi(port)
+	}
+	if community, ok := pairs(c.ProxyCommunity)[p.Host]; ok {
+		via.Community = community
+	}
+	return via, true
+}
+
+// proxied tags the points with the agent rather than the proxy that returned them
+func proxied(sender snmp.Sender, host, proxy string) snmp.Sender {
+	return func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+		if tags["host"] == proxy {
+			tags = withTag(tags, "host", host)
+		}
+		return sender(name, tags, value, ts)
+	}
+}
+
+// pollAgent samples the agent every polling interval until stop is closed
+func pollAgent(p snmp.Profile, c *SnmpConfig, crit snmp.Criteria, stop chan struct{}, sender snmp.Sender, errFn snmp.ErrFunc) {
+	for !stopping() && !stopped(stop) {
+		start := time.Now()
+		errFn(sampleAgent(p, c, crit, sender))
+		pause(stop, time.Until(start.Add(time.Duration(crit.Freq)*time.Second)))
+	}
+}
+
+// sampleAgent gets a single sample from the agent, through its proxy if it has one,
+// or at the address chosen by its resolution policy, escalating its timeout if it fails
+// as its snmp config allows
+func sampleAgent(p snmp.Profile, c *SnmpConfig, crit snmp.Criteria, sender snmp.Sender) error {
+	// agents that answered tooBig are asked for fewer values per request
+	reps := repetitions(p.Host)
+	if len(crit.Aliases) > 0 {
+		reps = 0
+	}
+	return escalate(p, c, func(p snmp.Profile) error {
+		p = withCredential(p, c)
+		if via, ok := viaProxy(p, c); ok {
+			if reps > 0 {
+				return nativeWalk(via, c, crit, proxied(sender, p.Host, via.Host), reps)
+			}
+			return snmp.Sampler(via, crit, proxied(sender, p.Host, via.Host))
+		}
+		p, sender := resolved(p, sender)
+		if reps > 0 {
+			return nativeWalk(p, c, crit, sender, reps)
+		}
+		if c.nativeV3() {
+			return nativeWalk(p, c, crit, sender, defaultRepetitions)
+		}
+		return snmp.Sampler(p, crit, sender)
+	})
+}
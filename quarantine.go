@@ -0,0 +1,109 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// quarantineAfter is how many walks of an OID must fail in a row,
+	// while other walks of the agent succeed, before it is quarantined
+	quarantineAfter = 3
+	// quarantineMin and quarantineMax bound how long an OID is skipped,
+	// which doubles each time it is quarantined again
+	quarantineMin = 10 * time.Minute
+	quarantineMax = 4 * time.Hour
+)
+
+// oidHealth tracks the failures of an OID on an agent
+type oidHealth struct {
+	failures   int
+	firstFail  time.Time
+	until      time.Time
+	period     time.Duration
+	quarantine bool
+}
+
+// quarantine skips OIDs that fail on an agent that otherwise responds
+var quarantine = struct {
+	sync.Mutex
+	oids   map[string]*oidHealth
+	hostOK map[string]time.Time
+}{
+	oids:   make(map[string]*oidHealth),
+	hostOK: make(map[string]time.Time),
+}
+
+// walked records the outcome of a walk of the OID
+func walked(host, oid string, err error) {
+	now := time.Now()
+	key := host + "/" + oid
+	quarantine.Lock()
+	defer quarantine.Unlock()
+	h, ok := quarantine.oids[key]
+	if !ok {
+		h = &oidHealth{period: quarantineMin}
+		quarantine.oids[key] = h
+	}
+	if err == nil {
+		quarantine.hostOK[host] = now
+		if h.quarantine {
+			log.Printf("%s on %s is working again\n", oid, host)
+		}
+		*h = oidHealth{period: quarantineMin}
+		return
+	}
+	if h.failures == 0 {
+		h.firstFail = now
+	}
+	h.failures++
+	// only the OID is at fault if the agent answered other walks meanwhile
+	if h.failures < quarantineAfter || !quarantine.hostOK[host].After(h.firstFail) {
+		return
+	}
+	if h.quarantine {
+		if h.period *= 2; h.period > quarantineMax {
+			h.period = quarantineMax
+		}
+	}
+	h.quarantine = true
+	h.until = now.Add(h.period)
+	h.failures = 0
+	log.Printf("quarantining %s on %s for %s: %s\n", oid, host, h.period, err)
+}
+
+// quarantined returns true if the OID should not be walked on the agent for now
+func quarantined(host, oid string) bool {
+	quarantine.Lock()
+	defer quarantine.Unlock()
+	h, ok := quarantine.oids[host+"/"+oid]
+	return ok && h.quarantine && time.Now().Before(h.until)
+}
+
+// removeQuarantine forgets the health of the host's OIDs
+func removeQuarantine(host string) {
+	quarantine.Lock()
+	for key := range quarantine.oids {
+		if strings.HasPrefix(key, host+"/") {
+			delete(quarantine.oids, key)
+		}
+	}
+	delete(quarantine.hostOK, host)
+	quarantine.Unlock()
+}
+
+// quarantineList returns the quarantined OIDs, by agent/OID, and when they will be retried
+func quarantineList() map[string]time.Time {
+	now := time.Now()
+	list := make(map[string]time.Time)
+	quarantine.Lock()
+	for key, h := range quarantine.oids {
+		if h.quarantine && now.Before(h.until) {
+			list[key] = h.until
+		}
+	}
+	quarantine.Unlock()
+	return list
+}
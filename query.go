@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	client "github.com/influxdata/influxdb/client/v2"
+)
+
+// maxQueryPoints limits how many intervals a query through the proxy can return
+const maxQueryPoints = 10000
+
+// queryFuncs are the aggregates a query may use
+var queryFuncs = map[string]bool{"mean": true, "min": true, "max": true, "last": true, "sum": true, "count": true}
+
+// querySeries is a series of [time in ms, value] points
+type querySeries struct {
+	Tags   map[string]string `json:"tags"`
+	Points [][]interface{}   `json:"points"`
+}
+
+// identifier quotes a measurement or field name
+var identifier = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// literal quotes a string value
+var literal = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+// queryTime parses an absolute (RFC3339) or relative (e.g., -1h) time
+func queryTime(s string, now time.Time) (time.Time, error) {
+	if len(s) == 0 || s == "now" {
+		return now, nil
+	}
+	if strings.HasPrefix(s, "-") {
+		d, err := time.ParseDuration(s)
+		return now.Add(d), err
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// hostAgent returns the snmp config the host is polled by that the tenant may
+// query, or the first by name for the admin token. A host may be polled by the
+// configs of several tenants, as well as by configs outside of them
+func hostAgent(host, tenant string) (string, bool) {
+	var names []string
+	for name, c := range snmpConfigs() {
+		if hasField(c.Host, host) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if len(tenant) == 0 || tenantOf(name) == tenant && tenantSender(tenant, senderName(name)) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// queryTenant returns the tenant whose token the request has, or "" for the
+// admin token, which may query any host. ok is false if it has neither
+func queryTenant(r *http.Request) (string, bool) {
+	if authorized(r) {
+		return "", true
+	}
+	auth := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	for name, t := range cfg.Tenant {
+		if len(t.Token) > 0 && subtle.ConstantTimeCompare([]byte(auth), []byte(t.Token)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// buildQuery returns the influxql for the request, and its influx config.
+// A tenant may only query the hosts of its own agents, in its own databases
+func buildQuery(q url.Values, tenant string) (string, *InfluxConfig, error) {
+	host, name := q.Get("host"), q.Get("measurement")
+	if len(host) == 0 || len(name) == 0 {
+		return "", nil, fmt.Errorf("host and measurement are required")
+	}
+	agent, ok := hostAgent(host, tenant)
+	if !ok {
+		return "", nil, fmt.Errorf("unknown host: %s", host)
+	}
+	c, ok := influxFor(agent)
+	if !ok {
+		return "", nil, fmt.Errorf("no influx config for host: %s", host)
+	}
+	if strings.HasPrefix(c.URL, "udp:") {
+		return "", nil, fmt.Errorf("%s can't be queried", c.URL)
+	}
+	field := q.Get("field")
+	if len(field) == 0 {
+		field = "value"
+	}
+	fn := q.Get("fn")
+	if len(fn) == 0 {
+		fn = "mean"
+	}
+	if !queryFuncs[fn] {
+		return "", nil, fmt.Errorf("invalid fn: %s", fn)
+	}
+	now := time.Now()
+	since := q.Get("start")
+	if len(since) == 0 {
+		since = "-1h"
+	}
+	start, err := queryTime(since, now)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid start: %s", err)
+	}
+	end, err := queryTime(q.Get("end"), now)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid end: %s", err)
+	}
+	interval := time.Minute
+	if s := q.Get("interval"); len(s) > 0 {
+		if interval, err = time.ParseDuration(s); err != nil || interval < time.Second {
+			return "", nil, fmt.Errorf("invalid interval: %s", s)
+		}
+	}
+	if !end.After(start) {
+		return "", nil, fmt.Errorf("end is not after start")
+	}
+	if end.Sub(start)/interval > maxQueryPoints {
+		return "", nil, fmt.Errorf("more than %d intervals requested", maxQueryPoints)
+	}
+	cmd := fmt.Sprintf(`SELECT %s("%s") FROM "%s" WHERE "host" = '%s' AND time >= %d AND time < %d GROUP BY time(%ds), * fill(none)`,
+		fn, identifier.Replace(field), identifier.Replace(name), literal.Replace(host),
+		start.UnixNano(), end.UnixNano(), int(interval/time.Second))
+	return cmd, c, nil
+}
+
+// queryAPI returns the data of a host's measurement from influxdb as json,
+// so it can be read back without the database's credentials, e.g.,
+// /api/query?host=router1&measurement=ifHCInOctets&start=-6h&interval=5m
+func queryAPI(w http.ResponseWriter, r *http.Request) {
+	if !cfg.Common.QueryProxy {
+		http.NotFound(w, r)
+		return
+	}
+	tenant, ok := queryTenant(r)
+	if !ok {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	q := r.URL.Query()
+	cmd, c, err := buildQuery(q, tenant)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	conn, err := client.NewHTTPClient(c.httpConfig())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer conn.Close()
+	retention := q.Get("rp")
+	if len(retention) == 0 {
+		retention = c.Retention
+	}
+	resp, err := conn.Query(client.NewQueryWithRP(cmd, c.Database, retention, "ms"))
+	if err == nil {
+		err = resp.Error()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	list := []querySeries{}
+	for _, result := range resp.Results {
+		for _, s := range result.Series {
+			list = append(list, querySeries{Tags: s.Tags, Points: s.Values})
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		log.Printf("query error:%s\n", err)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestHostAgent(t *testing.T) {
+	snmps, tenants, influx := cfg.Snmp, cfg.Tenant, cfg.Influx
+	defer func() { cfg.Snmp, cfg.Tenant, cfg.Influx = snmps, tenants, influx }()
+	cfg.Snmp = map[string]*SnmpConfig{
+		"acme-core":   {Host: "shared1 acme1"},
+		"globex-core": {Host: "shared1"},
+		"ops":         {Host: "shared1 ops1"},
+	}
+	cfg.Tenant = map[string]*TenantConfig{
+		"acme":   {Agents: "acme-core", Senders: "acme"},
+		"globex": {Agents: "globex-core", Senders: "globex"},
+	}
+	cfg.Influx = map[string]*InfluxConfig{"acme": {}, "globex": {}, "*": {}}
+	tests := []struct {
+		host, tenant, agent string
+		ok                  bool
+	}{
+		{"shared1", "acme", "acme-core", true},
+		{"shared1", "globex", "globex-core", true},
+		{"shared1", "", "acme-core", true},
+		{"ops1", "", "ops", true},
+		{"ops1", "acme", "", false},
+		{"acme1", "globex", "", false},
+		{"shared1", "initech", "", false},
+	}
+	for _, tt := range tests {
+		agent, ok := hostAgent(tt.host, tt.tenant)
+		if agent != tt.agent || ok != tt.ok {
+			t.Errorf("%s for %q: got %s %t, want %s %t", tt.host, tt.tenant, agent, ok, tt.agent, tt.ok)
+		}
+	}
+}
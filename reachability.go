@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// probeResult is the outcome of checking that an agent responds
+type probeResult struct {
+	host    string
+	config  string
+	elapsed time.Duration
+	err     error
+}
+
+// probe gets sysUpTime from the agent
+func probe(p snmp.Profile, c *SnmpConfig) error {
+	got := false
+	sender := func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+		got = true
+		return nil
+	}
+	crit := snmp.Criteria{OID: "sysUpTime", Freq: 1, Count: 1}
+	if err := sampleAgent(p, c, crit, sender); err != nil {
+		return err
+	}
+	if !got {
+		return fmt.Errorf("no response")
+	}
+	return nil
+}
+
+// reachability probes every agent concurrently and writes a table of the results.
+// It returns the percentage of agents that responded
+func reachability(agents []snmpInfo, w io.Writer) float64 {
+	var wg sync.WaitGroup
+	var m sync.Mutex
+	var results []probeResult
+	seen := make(map[string]bool)
+	for _, a := range agents {
+		for _, p := range a.Config.profiles() {
+			key := fmt.Sprintf("%s:%d", p.Host, p.Port)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			wg.Add(1)
+			go func(p snmp.Profile, c *SnmpConfig, name string) {
+				start := time.Now()
+				err := probe(p, c)
+				m.Lock()
+				results = append(results, probeResult{p.Host, name, time.Since(start), err})
+				m.Unlock()
+				wg.Done()
+			}(p, a.Config, a.Name)
+		}
+	}
+	wg.Wait()
+	if len(results) == 0 {
+		return 100
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].host < results[j].host })
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tCONFIG\tSTATUS\tTIME\tREASON")
+	up := 0
+	for _, r := range results {
+		status, reason := "up", ""
+		if r.err != nil {
+			status, reason = "down", r.err.Error()
+		} else {
+			up++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.host, r.config, status, r.elapsed.Round(time.Millisecond), reason)
+	}
+	tw.Flush()
+	pct := float64(up) * 100 / float64(len(results))
+	log.Printf("%d of %d agents reachable (%.0f%%)\n", up, len(results), pct)
+	return pct
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// dedupeSuffix names the measurements the deduplicated points are written to
+const dedupeSuffix = "_dedupe"
+
+// collectorID identifies this collector in the points it writes: its station, or its hostname
+func collectorID() string {
+	if len(cfg.Common.Station) > 0 {
+		return cfg.Common.Station
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return host
+}
+
+// dedupeFreq is the shortest polling interval of the agents
+func dedupeFreq(agents []snmpInfo) int {
+	freq := 0
+	for _, a := range agents {
+		f := a.Config.Freq
+		if a.MIB.Freq > 0 {
+			f = a.MIB.Freq
+		}
+		if freq == 0 || f < freq {
+			freq = f
+		}
+	}
+	return freq
+}
+
+// dedupeQueries writes a continuous query per measurement that keeps one point
+// per series and polling interval from redundant collectors, by grouping on every
+// tag but the collector's. The queries are written for each database the agents use
+func dedupeQueries(agents []snmpInfo, w io.Writer) error {
+	tag := cfg.Common.CollectorTag
+	if len(tag) == 0 {
+		return fmt.Errorf("no collectorTag in the common config")
+	}
+	groups := make(map[string][]snmpInfo) // database to the agents written to it
+	var databases []string
+	for _, a := range agents {
+		// validate ensures there is one
+		c, _ := influxFor(a.Name)
+		if _, ok := groups[c.Database]; !ok {
+			databases = append(databases, c.Database)
+		}
+		groups[c.Database] = append(groups[c.Database], a)
+	}
+	sort.Strings(databases)
+	for _, db := range databases {
+		shortest := dedupeFreq(groups[db])
+		for _, m := range schema(groups[db]) {
+			fields := make([]string, 0, len(m.Fields))
+			for f := range m.Fields {
+				fields = append(fields, fmt.Sprintf("first(%q) AS %q", f, f))
+			}
+			sort.Strings(fields)
+			freq := m.Freq
+			if freq == 0 {
+				// events and elapsed times aren't polled on an interval of their own
+				freq = shortest
+			}
+			group := []string{fmt.Sprintf("time(%ds)", freq)}
+			for _, t := range m.Tags {
+				if t != tag {
+					group = append(group, fmt.Sprintf("%q", t))
+				}
+			}
+			fmt.Fprintf(w, "CREATE CONTINUOUS QUERY %q ON %q BEGIN SELECT %s INTO %q FROM %q GROUP BY %s END\n",
+				"dedupe_"+m.Name, db, strings.Join(fields, ", "), m.Name+dedupeSuffix, m.Name, strings.Join(group, ", "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// RelabelConfig is a rule to rewrite or filter tags, with the same meaning
+// as a Prometheus relabel_config. Rules are applied in order of their names.
+// The measurement name can be used as the __name__ tag
+type RelabelConfig struct {
+	Source      string `gcfg:"source"`      // tags whose values are joined to match against
+	Separator   string `gcfg:"separator"`   // joins the source values, defaults to ";"
+	Regex       string `gcfg:"regex"`       // must match the whole value, defaults to (.*)
+	Target      string `gcfg:"target"`      // tag to set for replace
+	Replacement string `gcfg:"replacement"` // may refer to regex groups, defaults to $1
+	Action      string `gcfg:"action"`      // replace (default), keep, drop, labeldrop, or labelkeep
+}
+
+// nameLabel refers to the measurement name in a rule
+const nameLabel = "__name__"
+
+// relabelRule is a compiled relabel config
+type relabelRule struct {
+	source      []string
+	separator   string
+	regex       *regexp.Regexp
+	target      string
+	replacement string
+	action      string
+}
+
+var (
+	relabelOnce  sync.Once
+	relabelRules []relabelRule
+	relabelErr   error
+)
+
+// loadRelabels compiles the relabel configs
+func loadRelabels() ([]relabelRule, error) {
+	relabelOnce.Do(func() {
+		names := make([]string, 0, len(cfg.Relabel))
+		for name := range cfg.Relabel {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			c := cfg.Relabel[name]
+			r := relabelRule{
+				source:      strings.Fields(c.Source),
+				separator:   c.Separator,
+				target:      c.Target,
+				replacement: c.Replacement,
+				action:      c.Action,
+			}
+			if len(r.separator) == 0 {
+				r.separator = ";"
+			}
+			if len(r.replacement) == 0 {
+				r.replacement = "$1"
+			}
+			if len(r.action) == 0 {
+				r.action = "replace"
+			}
+			expr := c.Regex
+			if len(expr) == 0 {
+				expr = "(.*)"
+			}
+			var err error
+			if r.regex, err = regexp.Compile("^(?:" + expr + ")$"); err != nil {
+				relabelErr = fmt.Errorf("relabel %s: %s", name, err)
+				return
+			}
+			switch r.action {
+			case "replace":
+				if len(r.target) == 0 {
+					relabelErr = fmt.Errorf("relabel %s: replace needs a target", name)
+					return
+				}
+			case "keep", "drop", "labeldrop", "labelkeep":
+			default:
+				relabelErr = fmt.Errorf("relabel %s: invalid action: %s", name, r.action)
+				return
+			}
+			relabelRules = append(relabelRules, r)
+		}
+	})
+	return relabelRules, relabelErr
+}
+
+// relabel applies the rules to the measurement name and tags,
+// returning false if the point is to be dropped
+func relabel(rules []relabelRule, name string, tags map[string]string) (string, map[string]string, bool) {
+	copied := false
+	copyTags := func() {
+		if !copied {
+			t := make(map[string]string, len(tags)+1)
+			for k, v := range tags {
+				t[k] = v
+			}
+			tags, copied = t, true
+		}
+	}
+	for _, r := range rules {
+		switch r.action {
+		case "labeldrop", "labelkeep":
+			for k := range tags {
+				if r.regex.MatchString(k) == (r.action == "labeldrop") {
+					copyTags()
+					delete(tags, k)
+				}
+			}
+			continue
+		}
+		values := make([]string, len(r.source))
+		for i, s := range r.source {
+			if s == nameLabel {
+				values[i] = name
+			} else {
+				values[i] = tags[s]
+			}
+		}
+		value := strings.Join(values, r.separator)
+		match := r.regex.FindStringSubmatchIndex(value)
+		switch r.action {
+		case "keep":
+			if match == nil {
+				return name, tags, false
+			}
+		case "drop":
+			if match != nil {
+				return name, tags, false
+			}
+		case "replace":
+			if match == nil {
+				continue
+			}
+			result := string(r.regex.ExpandString(nil, r.replacement, value, match))
+			if r.target == nameLabel {
+				if len(result) > 0 {
+					name = result
+				}
+				continue
+			}
+			copyTags()
+			if len(result) == 0 {
+				delete(tags, r.target)
+			} else {
+				tags[r.target] = result
+			}
+		}
+	}
+	return name, tags, true
+}
+
+// RelabelSender rewrites or filters tags with the relabel rules
+func RelabelSender(sender snmp.Sender) snmp.Sender {
+	rules, err := loadRelabels()
+	if err != nil || len(rules) == 0 {
+		return sender
+	}
+	return func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+		name, tags, ok := relabel(rules, name, tags)
+		if !ok {
+			return nil
+		}
+		return sender(name, tags, value, ts)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// resolution policies for hosts given as names
+const (
+	resolveIPv4 = "ipv4" // poll the first IPv4 address, or an IPv6 one if there is none
+	resolveIPv6 = "ipv6" // poll the first IPv6 address, or an IPv4 one if there is none
+	resolveAll  = "all"  // poll every address as a separate target, tagged with its address
+)
+
+// addressTag is the tag holding the address polled, for hosts resolved to all their addresses
+const addressTag = "address"
+
+// resolvedHosts maps the addresses of hosts polled at all their addresses to the host
+var resolvedHosts = struct {
+	sync.Mutex
+	names map[string]string
+}{names: make(map[string]string)}
+
+// checkResolve verifies the resolution policy is valid
+func checkResolve(policy string) error {
+	switch policy {
+	case "", resolveIPv4, resolveIPv6, resolveAll:
+		return nil
+	}
+	return fmt.Errorf("invalid resolve policy: %s", policy)
+}
+
+// resolveHost returns the addresses to poll for the host by the policy.
+// Addresses, and hosts without a policy, are polled as they are
+func resolveHost(host, policy string) ([]string, error) {
+	if len(policy) == 0 || net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+	var v4, v6 []string
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip.String())
+		} else {
+			v6 = append(v6, ip.String())
+		}
+	}
+	switch policy {
+	case resolveIPv4:
+		return append(v4, v6...)[:1], nil
+	case resolveIPv6:
+		return append(v6, v4...)[:1], nil
+	}
+	return append(v4, v6...), nil
+}
+
+// expandHosts returns the hosts to poll, replacing those resolved
+// to all their addresses with their addresses
+func expandHosts(c *SnmpConfig) []string {
+	hosts := strings.Fields(c.Host)
+	if c.Resolve != resolveAll {
+		return hosts
+	}
+	list := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		addrs, err := resolveHost(host, resolveAll)
+		if err != nil || len(addrs) == 0 {
+			log.Printf("cannot resolve %s, polling it by name: %v\n", host, err)
+			list = append(list, host)
+			continue
+		}
+		resolvedHosts.Lock()
+		for _, addr := range addrs {
+			if addr != host {
+				resolvedHosts.names[addr] = host
+			}
+		}
+		resolvedHosts.Unlock()
+		list = append(list, addrs...)
+	}
+	return list
+}
+
+// hostName returns the host an address was resolved from, if it was
+func hostName(addr string) (string, bool) {
+	resolvedHosts.Lock()
+	defer resolvedHosts.Unlock()
+	name, ok := resolvedHosts.names[addr]
+	return name, ok
+}
+
+// snmpConfigFor returns the snmp config the host (or address) is polled with
+func snmpConfigFor(host string) (*SnmpConfig, bool) {
+	if name, ok := hostName(host); ok {
+		host = name
+	}
+	for _, c := range snmpConfigs() {
+		for _, h := range strings.Fields(c.Host) {
+			if h == host {
+				return c, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// retagged tags points with the host, rather than the address polled
+func retagged(sender snmp.Sender, addr, host string, tagAddress bool) snmp.Sender {
+	return func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+		if tags["host"] == addr {
+			tags = withTag(tags, "host", host)
+		}
+		if tagAddress {
+			tags = withTag(tags, addressTag, addr)
+		}
+		return sender(name, tags, value, ts)
+	}
+}
+
+// resolved returns the profile to poll the agent at the address chosen by
+// its resolution policy, and the sender to tag its points with the host
+func resolved(p snmp.Profile, sender snmp.Sender) (snmp.Profile, snmp.Sender) {
+	if name, ok := hostName(p.Host); ok {
+		return p, retagged(sender, p.Host, name, true)
+	}
+	c, ok := snmpConfigFor(p.Host)
+	if !ok || (c.Resolve != resolveIPv4 && c.Resolve != resolveIPv6) {
+		return p, sender
+	}
+	addrs, err := resolveHost(p.Host, c.Resolve)
+	if err != nil || len(addrs) == 0 || addrs[0] == p.Host {
+		// leave it to be resolved when it is polled
+		return p, sender
+	}
+	host := p.Host
+	p.Host = addrs[0]
+	return p, retagged(sender, p.Host, host, false)
+}
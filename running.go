@@ -0,0 +1,183 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// running tracks the agents being polled, so discovery can stop the ones it no longer finds
+var running = struct {
+	sync.Mutex
+	stops   map[string]chan struct{} // agent to the channel closed to stop its polls
+	hosts   map[string][]string      // agent to the hosts it polls
+	pollers map[string]string        // kind/host of a per host poller to the agent that started it
+	wants   map[string][]hostPoller  // kind/host to the agents that need the poller
+}{
+	stops:   make(map[string]chan struct{}),
+	hosts:   make(map[string][]string),
+	pollers: make(map[string]string),
+	wants:   make(map[string][]hostPoller),
+}
+
+// hostPoller starts a per host poller for an agent, until stop is closed
+type hostPoller struct {
+	agent string
+	start func(stop chan struct{})
+}
+
+// agentStop returns the channel that is closed when the agent is stopped
+func agentStop(agent string) chan struct{} {
+	running.Lock()
+	defer running.Unlock()
+	return stopOf(agent)
+}
+
+// stopOf returns the agent's stop channel, with running locked
+func stopOf(agent string) chan struct{} {
+	stop, ok := running.stops[agent]
+	if !ok {
+		stop = make(chan struct{})
+		running.stops[agent] = stop
+	}
+	return stop
+}
+
+// walkStop returns a channel that is closed once every agent sharing the walk is stopped
+func walkStop(walks []tableWalk) chan struct{} {
+	if len(walks) == 1 {
+		return walks[0].stop
+	}
+	stop := make(chan struct{})
+	go func() {
+		for _, w := range walks {
+			<-w.stop
+		}
+		close(stop)
+	}()
+	return stop
+}
+
+// stopped returns true if the channel is closed
+func stopped(stop chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// pause waits for the duration, returning early if stop is closed
+func pause(stop chan struct{}, d time.Duration) {
+	timer := time.NewTimer(d)
+	select {
+	case <-timer.C:
+	case <-stop:
+		timer.Stop()
+	}
+}
+
+// startPoller starts the kind of per host poller for the host, unless it is already
+// running for another agent. Each agent that needs it is recorded, so the poller
+// can be started again for one of the others if the agent it runs for is stopped
+func startPoller(kind, host, agent string, start func(stop chan struct{})) {
+	key := kind + "/" + host
+	running.Lock()
+	defer running.Unlock()
+	running.wants[key] = append(running.wants[key], hostPoller{agent, start})
+	if _, ok := running.pollers[key]; ok {
+		return
+	}
+	running.pollers[key] = agent
+	go start(stopOf(agent))
+}
+
+// polling records that the agent polls the host
+func polling(agent, host string) {
+	running.Lock()
+	running.hosts[agent] = append(running.hosts[agent], host)
+	running.Unlock()
+}
+
+// stopAgent stops the polls of the agent, handing its per host pollers to other
+// agents that need them. It returns the hosts it polled, and those no other agent polls
+func stopAgent(agent string) (hosts, idle []string) {
+	running.Lock()
+	defer running.Unlock()
+	if stop, ok := running.stops[agent]; ok {
+		close(stop)
+		delete(running.stops, agent)
+	}
+	for key, list := range running.wants {
+		kept := list[:0]
+		for _, p := range list {
+			if p.agent != agent {
+				kept = append(kept, p)
+			}
+		}
+		if len(kept) == 0 {
+			delete(running.wants, key)
+		} else {
+			running.wants[key] = kept
+		}
+	}
+	for key, owner := range running.pollers {
+		if owner != agent {
+			continue
+		}
+		delete(running.pollers, key)
+		if list, ok := running.wants[key]; ok {
+			p := list[0]
+			running.pollers[key] = p.agent
+			go p.start(stopOf(p.agent))
+		}
+	}
+	hosts = running.hosts[agent]
+	delete(running.hosts, agent)
+	polled := make(map[string]bool)
+	for _, list := range running.hosts {
+		for _, host := range list {
+			polled[host] = true
+		}
+	}
+	for _, host := range hosts {
+		if !polled[host] {
+			idle = append(idle, host)
+		}
+	}
+	return hosts, idle
+}
+
+// retireAgent stops the agent, and forgets what was kept for the hosts only it polled.
+// Walks of other agents that were held back as duplicates of its walks are started
+func retireAgent(agent string) {
+	unscheduled(agent)
+	hosts, idle := stopAgent(agent)
+	for _, host := range hosts {
+		removeStats(host + "/" + agent)
+	}
+	removeReboots(agent)
+	for _, w := range releaseWalks(agent) {
+		log.Printf("%s on %s is now polled by %s\n", w.crit.OID, w.profile.Host, w.info.Name)
+		quit.Add(1)
+		go gather([]tableWalk{w})
+	}
+	for _, host := range idle {
+		removeStats(host)
+		removeCaches(host)
+		removeIfTables(host)
+		removeJoins(host)
+		removeSlots(host)
+		removeQuarantine(host)
+		removeSysName(host)
+		removeRepetitions(host)
+		removeHostReboots(host)
+		removeUptime(host)
+		latest.remove(host)
+		metrics.remove(host)
+		guard.remove(host)
+		inventory.remove(host)
+		topology.remove(host)
+	}
+}
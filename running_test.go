@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestPollerHandover(t *testing.T) {
+	started := make(chan string, 3)
+	poller := func(agent string) func(stop chan struct{}) {
+		return func(stop chan struct{}) {
+			started <- agent
+			<-stop
+		}
+	}
+	startPoller("uptime", "handover.example.com", "first", poller("first"))
+	startPoller("uptime", "handover.example.com", "second", poller("second"))
+	if agent := <-started; agent != "first" {
+		t.Fatalf("started for %s, want first", agent)
+	}
+	stopAgent("first")
+	if agent := <-started; agent != "second" {
+		t.Fatalf("handed over to %s, want second", agent)
+	}
+	stopAgent("second")
+	running.Lock()
+	defer running.Unlock()
+	if owner, ok := running.pollers["uptime/handover.example.com"]; ok {
+		t.Errorf("poller still running for %s", owner)
+	}
+	if len(started) > 0 {
+		t.Errorf("poller started again for %s", <-started)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// sampledPoint is a sampled point, after names are resolved
+type sampledPoint struct {
+	Name   string                 `json:"name"`
+	Tags   map[string]string      `json:"tags"`
+	Fields map[string]interface{} `json:"fields"`
+	Types  map[string]string      `json:"types"` // of the fields, as influxdb saves them
+}
+
+// statefulProcessors only produce output over several polls, so are skipped when sampling
+var statefulProcessors = map[string]bool{
+	"cardinality": true,
+	"state":       true,
+	"threshold":   true,
+	"aggregate":   true,
+	"dedup":       true,
+}
+
+// samplePipeline wraps the sender with the agent's processors that name and tag its points
+func samplePipeline(sender snmp.Sender, st stage) (snmp.Sender, error) {
+	names, err := processorList(st.info.Config)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(names) - 1; i >= 0; i-- {
+		if !statefulProcessors[names[i]] {
+			sender = processors[names[i]](sender, st)
+		}
+	}
+	return sender, nil
+}
+
+// tagString returns the tags as influxdb writes them, in sorted order
+func tagString(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + tags[k]
+	}
+	return strings.Join(pairs, ",")
+}
+
+// structuredSample samples each agent once, returning the points in sorted order
+// so that samples can be compared
+func structuredSample(agents []snmpInfo) []sampledPoint {
+	var wg sync.WaitGroup
+	var m sync.Mutex
+	var points []sampledPoint
+	send := func(name string, tags map[string]string, fields map[string]interface{}, ts time.Time) error {
+		pt := sampledPoint{name, make(map[string]string), make(map[string]interface{}), make(map[string]string)}
+		for k, v := range tags {
+			pt.Tags[k] = v
+		}
+		for k, v := range fields {
+			pt.Fields[k] = v
+			pt.Types[k] = fieldType(v)
+		}
+		m.Lock()
+		points = append(points, pt)
+		m.Unlock()
+		return nil
+	}
+	for _, a := range agents {
+		for _, profile := range a.Config.profiles() {
+			for _, crit := range criteria(a.Config, a.MIB) {
+				var sender snmp.Sender = func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+					return send(name, tags, map[string]interface{}{"value": value}, ts.Stop)
+				}
+				sender, err := samplePipeline(sender, stage{send, profile, a, crit.Freq, nil})
+				if err != nil {
+					log.Printf("error sampling host %s: %s\n", profile.Host, err)
+					continue
+				}
+				wg.Add(1)
+				go func(p snmp.Profile, c *SnmpConfig, crit snmp.Criteria) {
+					if err := sampleAgent(p, c, crit, sender); err != nil {
+						log.Printf("error sampling host %s: %s\n", p.Host, err)
+					}
+					wg.Done()
+				}(profile, a.Config, crit)
+			}
+		}
+	}
+	wg.Wait()
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].Name != points[j].Name {
+			return points[i].Name < points[j].Name
+		}
+		return tagString(points[i].Tags) < tagString(points[j].Tags)
+	})
+	return points
+}
+
+// sampleDump writes a sample of each agent's points as json or csv.
+// Timestamps are left out so samples taken at different times can be compared
+func sampleDump(agents []snmpInfo, format string, w io.Writer) error {
+	if format != "json" && format != "csv" {
+		return fmt.Errorf("unknown sample format: %s", format)
+	}
+	points := structuredSample(agents)
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		for _, pt := range points {
+			if err := enc.Encode(pt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"measurement", "tags", "field", "value"})
+	for _, pt := range points {
+		keys := make([]string, 0, len(pt.Fields))
+		for k := range pt.Fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			cw.Write([]string{pt.Name, tagString(pt.Tags), k, fmt.Sprint(pt.Fields[k])})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// job is a walk that is polled on schedule by the worker pool
+type job struct {
+	due       time.Time
+	remaining int // polls left, or 0 if unlimited
+	profile   snmp.Profile
+	config    *SnmpConfig
+	crit      snmp.Criteria
+	slots     chan struct{} // the agent's walk slots
+	stop      chan struct{} // closed when the agent is no longer polled
+	sender    snmp.Sender
+	errFn     snmp.ErrFunc
+}
+
+// jobQueue is a priority queue of jobs ordered by when they are next due
+type jobQueue []*job
+
+func (q jobQueue) Len() int            { return len(q) }
+func (q jobQueue) Less(i, j int) bool  { return q[i].due.Before(q[j].due) }
+func (q jobQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *jobQueue) Push(x interface{}) { *q = append(*q, x.(*job)) }
+func (q *jobQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	j := old[n-1]
+	*q = old[:n-1]
+	return j
+}
+
+// scheduler dispatches due jobs to a fixed number of workers
+type scheduler struct {
+	sync.Mutex
+	queue jobQueue
+	held  map[chan struct{}][]*job // due jobs waiting for one of their agent's slots
+	work  chan *job
+	wake  chan struct{}
+}
+
+// sched is the worker pool, if one is configured
+var sched *scheduler
+
+func newScheduler(workers int) *scheduler {
+	s := &scheduler{
+		held: make(map[chan struct{}][]*job),
+		work: make(chan *job),
+		wake: make(chan struct{}, 1),
+	}
+	for i := 0; i < workers; i++ {
+		go s.worker()
+	}
+	go s.run()
+	return s
+}
+
+// add schedules the job
+func (s *scheduler) add(j *job) {
+	s.Lock()
+	heap.Push(&s.queue, j)
+	s.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run waits for the next job to be due and hands it to a worker with one of
+// its agent's slots, blocking while all workers are busy. Jobs of agents with
+// no free slot are held back until one is released, rather than taking up a worker
+func (s *scheduler) run() {
+	for {
+		s.Lock()
+		if len(s.queue) == 0 {
+			s.Unlock()
+			<-s.wake
+			continue
+		}
+		next := s.queue[0]
+		wait := next.due.Sub(time.Now())
+		if wait > 0 {
+			s.Unlock()
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-s.wake:
+				timer.Stop()
+			}
+			continue
+		}
+		heap.Pop(&s.queue)
+		if stopping() {
+			s.Unlock()
+			quit.Done()
+			continue
+		}
+		select {
+		case next.slots <- struct{}{}:
+		default:
+			s.held[next.slots] = append(s.held[next.slots], next)
+			s.Unlock()
+			continue
+		}
+		s.Unlock()
+		s.work <- next
+	}
+}
+
+// release frees one of the agent's slots, requeueing the jobs held back for it
+func (s *scheduler) release(slots chan struct{}) {
+	<-slots
+	s.Lock()
+	held := s.held[slots]
+	delete(s.held, slots)
+	for _, j := range held {
+		heap.Push(&s.queue, j)
+	}
+	s.Unlock()
+	if len(held) > 0 {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *scheduler) worker() {
+	for j := range s.work {
+		// run took one of the agent's slots for the job
+		if stopped(j.stop) {
+			s.release(j.slots)
+			quit.Done()
+			continue
+		}
+		if !quarantined(j.profile.Host, j.crit.OID) {
+			err := supervise("poll of "+j.profile.Host, func() error {
+				return sampleWalk(j.profile, j.config, j.crit, j.sender)
+			})
+			walked(j.profile.Host, j.crit.OID, err)
+			j.errFn(err)
+		}
+		s.release(j.slots)
+		if stopping() {
+			quit.Done()
+			continue
+		}
+		if j.remaining > 0 {
+			if j.remaining--; j.remaining == 0 {
+				quit.Done()
+				continue
+			}
+		}
+		// skip cycles missed while waiting for a worker
+		now := time.Now()
+		freq := cycleFreq(j.config, j.crit, now)
+		for !j.due.After(now) {
+			j.due = j.due.Add(freq)
+		}
+		s.add(j)
+	}
+}
+
+// schedule polls the walk with the worker pool
+func schedule(p snmp.Profile, c *SnmpConfig, crit snmp.Criteria, slots, stop chan struct{}, sender snmp.Sender, errFn snmp.ErrFunc) {
+	sched.add(&job{
+		due:       time.Now(),
+		remaining: crit.Count,
+		profile:   p,
+		config:    c,
+		crit:      crit,
+		slots:     slots,
+		stop:      stop,
+		sender:    sender,
+		errFn:     errFn,
+	})
+}
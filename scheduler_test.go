@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHeldJobs(t *testing.T) {
+	s := &scheduler{
+		held: make(map[chan struct{}][]*job),
+		work: make(chan *job),
+		wake: make(chan struct{}, 1),
+	}
+	go s.run()
+	busy := make(chan struct{}, 1)
+	free := make(chan struct{}, 1)
+	now := time.Now()
+	first := &job{due: now, slots: busy}
+	second := &job{due: now.Add(time.Millisecond), slots: busy}
+	other := &job{due: now.Add(2 * time.Millisecond), slots: free}
+	for _, j := range []*job{first, second, other} {
+		s.add(j)
+	}
+	next := func() *job {
+		select {
+		case j := <-s.work:
+			return j
+		case <-time.After(time.Second):
+			return nil
+		}
+	}
+	if j := next(); j != first {
+		t.Fatal("expected the first job")
+	}
+	if j := next(); j != other {
+		t.Fatal("expected the job of the other agent while the first holds the only slot")
+	}
+	s.release(busy)
+	if j := next(); j != second {
+		t.Fatal("expected the held job once the slot was released")
+	}
+}
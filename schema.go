@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// Measurement describes the fields and tags of a measurement
+type Measurement struct {
+	Name   string            `json:"name"`
+	Fields map[string]string `json:"fields"`
+	Tags   []string          `json:"tags"`
+	Freq   int               `json:"freq,omitempty"` // shortest polling interval of the walks that produce it
+}
+
+// fieldType returns the influxdb type of a value
+func fieldType(value interface{}) string {
+	switch value.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return "integer"
+	case float32, float64:
+		return "float"
+	case bool:
+		return "boolean"
+	}
+	return "string"
+}
+
+// schema samples the agents to determine what measurements will be produced.
+// The samples go through the agents' processors and senders, as when polled
+func schema(agents []snmpInfo) []Measurement {
+	var wg sync.WaitGroup
+	var m sync.Mutex
+	found := make(map[string]*Measurement)
+	tagged := make(map[string]map[string]struct{})
+
+	collect := func(freq int, static map[string]string) SendFunc {
+		return func(name string, tags map[string]string, fields map[string]interface{}, ts time.Time) error {
+			m.Lock()
+			defer m.Unlock()
+			meas, ok := found[name]
+			if !ok {
+				meas = &Measurement{Name: name, Fields: map[string]string{}}
+				found[name] = meas
+				tagged[name] = make(map[string]struct{})
+			}
+			for k, v := range fields {
+				meas.Fields[k] = fieldType(v)
+			}
+			if freq > 0 && (meas.Freq == 0 || freq < meas.Freq) {
+				meas.Freq = freq
+			}
+			for k := range tags {
+				tagged[name][k] = struct{}{}
+			}
+			for k := range static {
+				tagged[name][k] = struct{}{}
+			}
+			return nil
+		}
+	}
+
+	for _, a := range agents {
+		var static map[string]string
+		if c, ok := influxFor(a.Name); ok {
+			static = staticTags(c)
+		}
+		counters := make(map[string]bool)
+		for _, column := range strings.Fields(a.MIB.Deltas) {
+			counters[column] = true
+		}
+		top, _, _ := parseTop(a.MIB.Top)
+		for _, profile := range a.Config.profiles() {
+			for _, crit := range criteria(a.Config, a.MIB) {
+				send := agentChain(collect(crit.Freq, static), a.Name)
+				// a single sample has no prior poll to take deltas from, nor rows
+				// left over from the top N to roll up, so their fields are added as sent
+				var sender snmp.Sender = func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+					fields := map[string]interface{}{"value": value}
+					if counters[name] {
+						fields[deltaField] = int64(0)
+						if top > 0 {
+							fields["rows"] = 0
+						}
+					}
+					return send(name, tags, fields, ts.Stop)
+				}
+				sender, err := samplePipeline(sender, stage{send, profile, a, crit.Freq, nil})
+				if err != nil {
+					log.Printf("error sampling host %s: %s\n", profile.Host, err)
+					continue
+				}
+				if cfg.Common.Elapsed {
+					fields := map[string]interface{}{"elapsed": 0, "values": 0}
+					send(elapsedMeasurement, elapsedTags(profile.Host, crit.OID), fields, time.Now())
+				}
+				wg.Add(1)
+				go func(p snmp.Profile, c *SnmpConfig, crit snmp.Criteria) {
+					if err := sampleAgent(p, c, crit, sender); err != nil {
+						log.Printf("error sampling host %s: %s\n", p.Host, err)
+					}
+					wg.Done()
+				}(profile, a.Config, crit)
+			}
+			if cfg.Common.Events || len(cfg.Threshold) > 0 {
+				send := agentChain(collect(0, static), a.Name)
+				tags := map[string]string{"type": "availability", "host": profile.Host, "mib": a.Name, "measurement": a.Name, "status": "up"}
+				fields := map[string]interface{}{"state": "up", "value": 0.0, "status_value": "up", "error": "none"}
+				send(eventMeasurement, tags, fields, time.Now())
+			}
+		}
+	}
+	wg.Wait()
+
+	list := make([]Measurement, 0, len(found))
+	for name, meas := range found {
+		for k := range tagged[name] {
+			meas.Tags = append(meas.Tags, k)
+		}
+		sort.Strings(meas.Tags)
+		list = append(list, *meas)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// schemaDump writes the measurement schema in the given format
+func schemaDump(agents []snmpInfo, format string, w io.Writer) error {
+	if format == "dedupe" {
+		return dedupeQueries(agents, w)
+	}
+	list := schema(agents)
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(list)
+	case "markdown", "md":
+		for _, m := range list {
+			fmt.Fprintf(w, "## %s\n\n", m.Name)
+			fmt.Fprintln(w, "| Field | Type |")
+			fmt.Fprintln(w, "|-------|------|")
+			fields := make([]string, 0, len(m.Fields))
+			for k := range m.Fields {
+				fields = append(fields, k)
+			}
+			sort.Strings(fields)
+			for _, f := range fields {
+				fmt.Fprintf(w, "| %s | %s |\n", f, m.Fields[f])
+			}
+			fmt.Fprintln(w)
+			fmt.Fprintln(w, "| Tag |")
+			fmt.Fprintln(w, "|-----|")
+			for _, t := range m.Tags {
+				fmt.Fprintf(w, "| %s |\n", t)
+			}
+			fmt.Fprintln(w)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid schema format: %s", format)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// scoped returns the agents selected by the -agent and -mibgroup options.
+// An agent is selected by its snmp config name or one of its hosts,
+// and a mibs group by its name, which may be a preset such as arp or fdb
+func scoped(agents []snmpInfo, agent, group string) ([]snmpInfo, error) {
+	if len(agent) == 0 && len(group) == 0 {
+		return agents, nil
+	}
+	list := make([]snmpInfo, 0, len(agents))
+	for _, a := range agents {
+		if len(group) > 0 && a.Group != group {
+			continue
+		}
+		if from, _ := discoveredFrom(a.Name); len(agent) > 0 && a.Name != agent && from != agent && !hasField(a.Config.Host, agent) {
+			continue
+		}
+		list = append(list, a)
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("no agents match -agent %q -mibgroup %q", agent, group)
+	}
+	return list, nil
+}
+
+// hasField returns true if the space separated list includes the item
+func hasField(list, item string) bool {
+	for _, f := range strings.Fields(list) {
+		if f == item {
+			return true
+		}
+	}
+	return false
+}
+
+// scopeNames returns the names of the OIDs polled by the agents, in sorted order
+func scopeNames(agents []snmpInfo) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, a := range agents {
+		for _, name := range strings.Fields(a.MIB.Name) {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+// ScriptConfig specifies a starlark script to transform points.
+// The script must define transform(point), where point is a dict of
+// name, tags, fields and time (unix nanoseconds). It returns None
+// to drop the point, or a point or list of points to send instead
+type ScriptConfig struct {
+	File  string `gcfg:"file"`
+	Steps uint64 `gcfg:"steps"` // maximum execution steps per point
+}
+
+// defaultSteps is the default execution limit per point
+const defaultSteps = 100000
+
+var (
+	scriptOnce sync.Once
+	scriptFn   starlark.Callable
+	scriptErr  error
+)
+
+// loadScript compiles the script and returns its transform function.
+// Its globals are frozen, as transform is called concurrently
+func loadScript(filename string) (starlark.Callable, error) {
+	thread := &starlark.Thread{Name: "load"}
+	globals, err := starlark.ExecFile(thread, filename, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	globals.Freeze()
+	fn, ok := globals["transform"].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("%s does not define transform", filename)
+	}
+	return fn, nil
+}
+
+// toStarlark converts a go value to its starlark equivalent
+func toStarlark(v interface{}) (starlark.Value, error) {
+	switch x := v.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(x), nil
+	case string:
+		return starlark.String(x), nil
+	case int:
+		return starlark.MakeInt(x), nil
+	case int32:
+		return starlark.MakeInt64(int64(x)), nil
+	case int64:
+		return starlark.MakeInt64(x), nil
+	case uint:
+		return starlark.MakeUint(x), nil
+	case uint32:
+		return starlark.MakeUint64(uint64(x)), nil
+	case uint64:
+		return starlark.MakeUint64(x), nil
+	case float32:
+		return starlark.Float(x), nil
+	case float64:
+		return starlark.Float(x), nil
+	}
+	return nil, fmt.Errorf("unsupported value type: %T", v)
+}
+
+// fromStarlark converts a starlark value to its go equivalent
+func fromStarlark(v starlark.Value) (interface{}, error) {
+	switch x := v.(type) {
+	case starlark.Bool:
+		return bool(x), nil
+	case starlark.String:
+		return string(x), nil
+	case starlark.Int:
+		if i, ok := x.Int64(); ok {
+			return i, nil
+		}
+		if u, ok := x.Uint64(); ok {
+			return u, nil
+		}
+		return nil, fmt.Errorf("integer out of range: %s", x)
+	case starlark.Float:
+		return float64(x), nil
+	}
+	return nil, fmt.Errorf("unsupported script value: %s", v.Type())
+}
+
+// pointDict represents a point as a starlark dict
+func pointDict(name string, tags map[string]string, fields map[string]interface{}, ts time.Time) (*starlark.Dict, error) {
+	t := starlark.NewDict(len(tags))
+	for k, v := range tags {
+		t.SetKey(starlark.String(k), starlark.String(v))
+	}
+	f := starlark.NewDict(len(fields))
+	for k, v := range fields {
+		sv, err := toStarlark(v)
+		if err != nil {
+			return nil, err
+		}
+		f.SetKey(starlark.String(k), sv)
+	}
+	d := starlark.NewDict(4)
+	d.SetKey(starlark.String("name"), starlark.String(name))
+	d.SetKey(starlark.String("tags"), t)
+	d.SetKey(starlark.String("fields"), f)
+	d.SetKey(starlark.String("time"), starlark.MakeInt64(ts.UnixNano()))
+	return d, nil
+}
+
+// scriptPoint is a point returned by the script
+type scriptPoint struct {
+	name   string
+	tags   map[string]string
+	fields map[string]interface{}
+	ts     time.Time
+}
+
+// dictPoint converts a starlark dict to a point
+func dictPoint(v starlark.Value) (scriptPoint, error) {
+	var pt scriptPoint
+	d, ok := v.(*starlark.Dict)
+	if !ok {
+		return pt, fmt.Errorf("script returned %s, not a dict", v.Type())
+	}
+	get := func(key string) starlark.Value {
+		v, _, _ := d.Get(starlark.String(key))
+		return v
+	}
+	name, ok := starlark.AsString(get("name"))
+	if !ok {
+		return pt, fmt.Errorf("script point has no name")
+	}
+	pt.name = name
+	pt.tags = make(map[string]string)
+	if t, ok := get("tags").(*starlark.Dict); ok {
+		for _, item := range t.Items() {
+			k, _ := starlark.AsString(item[0])
+			v, _ := starlark.AsString(item[1])
+			pt.tags[k] = v
+		}
+	}
+	pt.fields = make(map[string]interface{})
+	if f, ok := get("fields").(*starlark.Dict); ok {
+		for _, item := range f.Items() {
+			k, _ := starlark.AsString(item[0])
+			v, err := fromStarlark(item[1])
+			if err != nil {
+				return pt, err
+			}
+			pt.fields[k] = v
+		}
+	}
+	if n, ok := get("time").(starlark.Int); ok {
+		if i, ok := n.Int64(); ok {
+			pt.ts = time.Unix(0, i)
+		}
+	}
+	return pt, nil
+}
+
+// runScript calls the script's transform on the point
+func runScript(fn starlark.Callable, steps uint64, name string, tags map[string]string, fields map[string]interface{}, ts time.Time) ([]scriptPoint, error) {
+	d, err := pointDict(name, tags, fields, ts)
+	if err != nil {
+		return nil, err
+	}
+	thread := &starlark.Thread{Name: name}
+	thread.SetMaxExecutionSteps(steps)
+	v, err := starlark.Call(thread, fn, starlark.Tuple{d}, nil)
+	if err != nil {
+		return nil, err
+	}
+	var values []starlark.Value
+	switch x := v.(type) {
+	case starlark.NoneType:
+	case *starlark.List:
+		for i := 0; i < x.Len(); i++ {
+			values = append(values, x.Index(i))
+		}
+	default:
+		values = append(values, v)
+	}
+	pts := make([]scriptPoint, 0, len(values))
+	for _, v := range values {
+		pt, err := dictPoint(v)
+		if err != nil {
+			return nil, err
+		}
+		if pt.ts.IsZero() {
+			pt.ts = ts
+		}
+		pts = append(pts, pt)
+	}
+	return pts, nil
+}
+
+// checkScript loads the configured script, if any
+func checkScript() error {
+	if len(cfg.Script.File) == 0 {
+		return nil
+	}
+	scriptOnce.Do(func() {
+		scriptFn, scriptErr = loadScript(cfg.Script.File)
+	})
+	if scriptErr != nil {
+		return fmt.Errorf("script error: %s", scriptErr)
+	}
+	return nil
+}
+
+// ScriptSender passes points through the configured script before sending.
+// Points the script fails on are dropped
+func ScriptSender(send SendFunc) SendFunc {
+	c := cfg.Script
+	if len(c.File) == 0 {
+		return send
+	}
+	if err := checkScript(); err != nil {
+		log.Println(err)
+		return send
+	}
+	steps := c.Steps
+	if steps == 0 {
+		steps = defaultSteps
+	}
+	return func(name string, tags map[string]string, fields map[string]interface{}, ts time.Time) error {
+		pts, err := runScript(scriptFn, steps, name, tags, fields, ts)
+		if err != nil {
+			return fmt.Errorf("script error for %s: %s", name, err)
+		}
+		for _, pt := range pts {
+			if err := send(pt.name, pt.tags, pt.fields, pt.ts); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
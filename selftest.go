@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	client "github.com/influxdata/influxdb/client/v2"
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// maxSkew is the allowed clock difference between collector and database
+const maxSkew = 5 * time.Second
+
+// serverTime returns the influxdb server's clock via the ping endpoint
+func serverTime(c *InfluxConfig) (time.Time, error) {
+	conf := c.httpConfig()
+	url := strings.TrimSuffix(conf.Addr, "/") + "/ping"
+	hc := http.Client{
+		Transport: &http.Transport{
+			Proxy:           conf.Proxy,
+			DialContext:     conf.DialContext,
+			TLSClientConfig: conf.TLSConfig,
+		},
+	}
+	resp, err := hc.Get(url)
+	if err != nil {
+		return time.Time{}, err
+	}
+	resp.Body.Close()
+	return http.ParseTime(resp.Header.Get("Date"))
+}
+
+// countSince returns how many values of the measurement exist since the given time
+func countSince(conn client.Client, database, retention, name, host string, since time.Time) (int64, error) {
+	cmd := fmt.Sprintf(`SELECT count("value") FROM "%s" WHERE "host" = '%s' AND time >= %d`, name, host, since.UnixNano())
+	resp, err := conn.Query(client.NewQueryWithRP(cmd, database, retention, ""))
+	if err == nil {
+		err = resp.Error()
+	}
+	if err != nil {
+		return 0, err
+	}
+	for _, r := range resp.Results {
+		for _, s := range r.Series {
+			for _, v := range s.Values {
+				if len(v) < 2 {
+					continue
+				}
+				switch n := v[1].(type) {
+				case float64:
+					return int64(n), nil
+				case interface {
+					Int64() (int64, error)
+				}:
+					return n.Int64()
+				}
+			}
+		}
+	}
+	return 0, nil
+}
+
+// selftest polls each agent once, writes the results to the database,
+// and reads them back to verify they were saved.
+// It returns the number of problems found
+func selftest(agents []snmpInfo) int {
+	problems := 0
+	report := func(format string, args ...interface{}) {
+		problems++
+		fmt.Printf("FAIL: "+format+"\n", args...)
+	}
+
+	for name, c := range cfg.Influx {
+		conn, err := client.NewHTTPClient(c.httpConfig())
+		if err != nil {
+			report("influx %s: %s", name, err)
+			continue
+		}
+		if _, version, err := conn.Ping(c.httpConfig().Timeout); err != nil {
+			report("influx %s: cannot ping %s: %s", name, c.URL, err)
+		} else {
+			fmt.Printf("OK: influx %s: version %s\n", name, version)
+		}
+		if err := dbCheck(conn, c.Database); err != nil {
+			report("influx %s: %s", name, err)
+		}
+		if when, err := serverTime(c); err != nil {
+			report("influx %s: cannot determine server time: %s", name, err)
+		} else if skew := time.Since(when); skew > maxSkew || skew < -maxSkew {
+			report("influx %s: clock skew of %s", name, skew)
+		}
+		conn.Close()
+	}
+
+	start := time.Now().Truncate(time.Second)
+	for _, a := range agents {
+		c, ok := influxFor(a.Name)
+		if !ok {
+			report("no sender for: %s", a.Name)
+			continue
+		}
+		conn, err := client.NewHTTPClient(c.httpConfig())
+		if err != nil {
+			report("influx for %s: %s", a.Name, err)
+			continue
+		}
+		for _, profile := range a.Config.profiles() {
+			var m sync.Mutex
+			written := make(map[string]int64)
+			bp, _ := client.NewBatchPoints(c.batchConfig())
+			retention := c.Retention
+			if len(a.MIB.Retention) > 0 {
+				retention = a.MIB.Retention
+				bp.SetRetentionPolicy(retention)
+			}
+			collect := func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+				pt, err := client.NewPoint(name, tags, map[string]interface{}{"value": value}, ts.Stop)
+				if err != nil {
+					return err
+				}
+				m.Lock()
+				bp.AddPoint(pt)
+				written[name]++
+				m.Unlock()
+				return nil
+			}
+			sender := snmp.IntegerSender(collect)
+			for _, crit := range criteria(a.Config, a.MIB) {
+				if err := sampleAgent(profile, a.Config, crit, sender); err != nil {
+					report("snmp %s %s: %s", profile.Host, crit.OID, err)
+				}
+			}
+			if len(written) == 0 {
+				report("snmp %s (%s): no data collected", profile.Host, a.Name)
+				continue
+			}
+			if err := conn.Write(bp); err != nil {
+				report("write %s (%s): %s", profile.Host, a.Name, err)
+				continue
+			}
+			for name, count := range written {
+				got, err := countSince(conn, c.Database, retention, name, profile.Host, start)
+				switch {
+				case err != nil:
+					report("query %s %s: %s", profile.Host, name, err)
+				case got < count:
+					report("%s %s: wrote %d points, read back %d", profile.Host, name, count, got)
+				default:
+					fmt.Printf("OK: %s %s: %d points\n", profile.Host, name, count)
+				}
+			}
+		}
+		conn.Close()
+	}
+	if problems > 0 {
+		log.Printf("selftest found %d problems\n", problems)
+	}
+	return problems
+}
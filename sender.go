@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SendFunc is a function that accepts the components of a datapoint.
+// The fields may be reused once it returns
+type SendFunc func(string, map[string]string, map[string]interface{}, time.Time) error
+
+// Sender is an output backend for datapoints
+type Sender interface {
+	// Send queues a datapoint to be saved in the given retention policy,
+	// or the default retention policy if it is empty.
+	// It must not keep the fields
+	Send(retention, name string, tags map[string]string, fields map[string]interface{}, ts time.Time) error
+	// Flush saves all queued datapoints
+	Flush() error
+	// Close flushes and releases the backend
+	Close() error
+	// Stats returns the backend's operating statistics
+	Stats() SenderStats
+}
+
+// SenderStats provides a sender's operating statistics
+type SenderStats struct {
+	Sent    int64
+	Dropped int64
+	Errors  int64
+	Classes map[string]int64 // errors by class
+	Queued  int
+	// Verified and Unverified count the points read back after writing
+	// that were, and were not, saved as written
+	Verified   int64
+	Unverified int64
+	// ThrottledUntil is when the last pause the server asked for ended
+	ThrottledUntil time.Time
+	LastError      error
+	LastTime       time.Time
+}
+
+// SenderFactory creates a sender from its config
+type SenderFactory func(*InfluxConfig) (Sender, error)
+
+var (
+	factoryLock sync.Mutex
+	factories   = make(map[string]SenderFactory)
+)
+
+// RegisterSender makes a backend available for urls with the given scheme
+func RegisterSender(scheme string, factory SenderFactory) {
+	factoryLock.Lock()
+	factories[scheme] = factory
+	factoryLock.Unlock()
+}
+
+// senderSchemes returns the registered url schemes
+func senderSchemes() []string {
+	factoryLock.Lock()
+	defer factoryLock.Unlock()
+	list := make([]string, 0, len(factories))
+	for k := range factories {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// senderFactory returns the factory registered for the config's url scheme
+func senderFactory(c *InfluxConfig) (SenderFactory, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, err
+	}
+	factoryLock.Lock()
+	factory, ok := factories[u.Scheme]
+	factoryLock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no sender for %s, must be one of %v", c.URL, senderSchemes())
+	}
+	return factory, nil
+}
+
+// newSender creates the sender registered for the config's url scheme
+func newSender(c *InfluxConfig) (Sender, error) {
+	factory, err := senderFactory(c)
+	if err != nil {
+		return nil, err
+	}
+	s, err := factory(c)
+	if err != nil || len(c.Tags) == 0 {
+		return s, err
+	}
+	return &taggedSender{s, staticTags(c)}, nil
+}
+
+// staticTags returns the tags the influx config adds to every point
+func staticTags(c *InfluxConfig) map[string]string {
+	return pairs(strings.Replace(c.Tags, "{version}", version, -1))
+}
+
+// taggedSender adds static tags to every point,
+// unless the point already has a tag of the same name
+type taggedSender struct {
+	Sender
+	tags map[string]string
+}
+
+func (s *taggedSender) Send(retention, name string, tags map[string]string, fields map[string]interface{}, ts time.Time) error {
+	t := make(map[string]string, len(tags)+len(s.tags))
+	for k, v := range s.tags {
+		t[k] = v
+	}
+	for k, v := range tags {
+		t[k] = v
+	}
+	return s.Sender.Send(retention, name, t, fields, ts)
+}
+
+// sendFunc returns a function to send datapoints to the retention policy
+func sendFunc(s Sender, retention string) SendFunc {
+	return func(name string, tags map[string]string, fields map[string]interface{}, ts time.Time) error {
+		return s.Send(retention, name, tags, fields, ts)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"math"
+	"sync"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// sensorUnits are the ENTITY-SENSOR-MIB entPhySensorType units
+var sensorUnits = map[int]string{
+	1:  "other",
+	2:  "unknown",
+	3:  "voltsAC",
+	4:  "voltsDC",
+	5:  "amperes",
+	6:  "watts",
+	7:  "hertz",
+	8:  "celsius",
+	9:  "percentRH",
+	10: "rpm",
+	11: "cmm",
+	12: "truthvalue",
+	13: "specialEnum",
+	14: "dBm",
+}
+
+// sensorScale converts entPhySensorScale to a power of ten
+func sensorScale(scale int) int {
+	// yocto(1) is 10^-24, each step is 10^3, units(9) is 10^0
+	return (scale - 9) * 3
+}
+
+// fixedSensors are columns with fixed units and scaling
+var fixedSensors = map[string]struct {
+	units string
+	scale float64
+}{
+	"ciscoEnvMonTemperatureStatusValue": {"celsius", 1},
+	"ciscoEnvMonVoltageStatusValue":     {"voltsDC", 0.001},
+	"lmTempSensorsValue":                {"celsius", 0.001},
+	"lmFanSensorsValue":                 {"rpm", 1},
+	"lmVoltSensorsValue":                {"voltsDC", 0.001},
+}
+
+// sensorInfo is the scaling information for an entity sensor
+type sensorInfo struct {
+	units     string
+	scale     int
+	precision int
+}
+
+// SensorSender converts sensor readings to engineering units,
+// adding a units tag
+func SensorSender(sender snmp.Sender) snmp.Sender {
+	var m sync.Mutex
+	sensors := make(map[string]*sensorInfo)
+	return func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+		if fixed, ok := fixedSensors[name]; ok {
+			if f, ok := toFloat(value); ok {
+				return sender(name, withTag(tags, "units", fixed.units), f*fixed.scale, ts)
+			}
+			return sender(name, tags, value, ts)
+		}
+
+		var info *sensorInfo
+		switch name {
+		case "entPhySensorType", "entPhySensorScale", "entPhySensorPrecision", "entPhySensorValue":
+			key := seriesKey("", tags)
+			m.Lock()
+			if info = sensors[key]; info == nil {
+				info = &sensorInfo{scale: 9}
+				sensors[key] = info
+			}
+			m.Unlock()
+		default:
+			return sender(name, tags, value, ts)
+		}
+
+		f, ok := toFloat(value)
+		if !ok {
+			return sender(name, tags, value, ts)
+		}
+		m.Lock()
+		switch name {
+		case "entPhySensorType":
+			info.units = sensorUnits[int(f)]
+		case "entPhySensorScale":
+			info.scale = int(f)
+		case "entPhySensorPrecision":
+			info.precision = int(f)
+		case "entPhySensorValue":
+			units := info.units
+			f *= math.Pow10(sensorScale(info.scale) - info.precision)
+			m.Unlock()
+			if len(units) > 0 {
+				tags = withTag(tags, "units", units)
+			}
+			return sender(name, tags, f, ts)
+		}
+		m.Unlock()
+		return sender(name, tags, value, ts)
+	}
+}
+
+// withTag returns a copy of the tags with the additional tag
+func withTag(tags map[string]string, key, value string) map[string]string {
+	t := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		t[k] = v
+	}
+	t[key] = value
+	return t
+}
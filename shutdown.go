@@ -0,0 +1,107 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// defaultShutdown is how many seconds to wait for polls and writes to finish
+const defaultShutdown = 30
+
+var (
+	stopFlag     int32
+	activeCycles int64
+)
+
+// stopping returns true once shutdown has begun
+func stopping() bool {
+	return atomic.LoadInt32(&stopFlag) == 1
+}
+
+// cycleGate tracks the poll cycles in progress. Once shutdown begins
+// cycles already underway are completed but new ones are ignored
+func cycleGate(sender snmp.Sender, errFn snmp.ErrFunc) (snmp.Sender, snmp.ErrFunc) {
+	var m sync.Mutex
+	busy := false
+	gated := func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+		m.Lock()
+		if !busy {
+			if stopping() {
+				m.Unlock()
+				return nil
+			}
+			busy = true
+			atomic.AddInt64(&activeCycles, 1)
+		}
+		m.Unlock()
+		return sender(name, tags, value, ts)
+	}
+	done := func(err error) {
+		m.Lock()
+		if busy {
+			busy = false
+			atomic.AddInt64(&activeCycles, -1)
+		}
+		m.Unlock()
+		errFn(err)
+	}
+	return gated, done
+}
+
+// shutdownTimeout returns how long to wait for polls and writes to finish
+func shutdownTimeout() time.Duration {
+	timeout := cfg.Common.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdown
+	}
+	return time.Duration(timeout) * time.Second
+}
+
+// shutdown lets the polls in progress finish, then flushes the senders,
+// giving up when the timeout is reached
+func shutdown(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	atomic.StoreInt32(&stopFlag, 1)
+	for atomic.LoadInt64(&activeCycles) > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if n := atomic.LoadInt64(&activeCycles); n > 0 {
+		log.Printf("shutdown: %d polls did not finish\n", n)
+	}
+
+	type result struct {
+		name   string
+		before SenderStats
+	}
+	done := make(chan string, len(senders))
+	list := make([]result, 0, len(senders))
+	for name, s := range senders {
+		list = append(list, result{name, s.Stats()})
+		go func(name string, s Sender) {
+			if err := s.Flush(); err != nil {
+				log.Printf("shutdown: flush error for %s: %s\n", name, err)
+			}
+			done <- name
+		}(name, s)
+	}
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+wait:
+	for n := len(list); n > 0; n-- {
+		select {
+		case <-done:
+		case <-timer.C:
+			log.Println("shutdown: timed out flushing senders")
+			break wait
+		}
+	}
+	for _, r := range list {
+		after := senders[r.name].Stats()
+		log.Printf("shutdown: %s flushed %d points, abandoned %d\n", r.name,
+			after.Sent-r.before.Sent, int64(after.Queued)+after.Dropped-r.before.Dropped)
+	}
+}
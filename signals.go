@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"sync/atomic"
+	"syscall"
+)
+
+// verboseFlag is set while verbose logging is on
+var verboseFlag int32
+
+// debugging returns true if verbose logging is on
+func debugging() bool {
+	return atomic.LoadInt32(&verboseFlag) == 1
+}
+
+// setVerbose turns verbose logging on or off
+func setVerbose(on bool) {
+	if on {
+		atomic.StoreInt32(&verboseFlag, 1)
+		logger.SetOutput(os.Stderr)
+	} else {
+		atomic.StoreInt32(&verboseFlag, 0)
+		logger.SetOutput(ioutil.Discard)
+	}
+}
+
+// statusDump logs the operating statistics
+func statusDump() {
+	s := status()
+	log.Printf("started: %s uptime: %s invalid values: %d\n", s.Started, s.Uptime, s.Invalid)
+	names := make([]string, 0, len(s.SnmpStats))
+	for name := range s.SnmpStats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		st := s.SnmpStats[name]
+		log.Printf("snmp %s: gets=%d errors=%d received=%s last error=%v\n",
+			name, st.GetCnt, st.ErrCnt, trafficString(st), st.LastError)
+	}
+	names = names[:0]
+	for name := range s.Senders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		st := s.Senders[name]
+		log.Printf("sender %s: sent=%d queued=%d dropped=%d errors=%d classes=%v last error=%v\n",
+			name, st.Sent, st.Queued, st.Dropped, st.Errors, st.Classes, st.LastError)
+	}
+	names = names[:0]
+	for name := range s.Series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		log.Printf("series %s: %d\n", name, s.Series[name])
+	}
+}
+
+// signalHandler dumps statistics to the log (and the state to the dump file) on SIGUSR1
+// and toggles verbose logging on SIGUSR2
+func signalHandler() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1, syscall.SIGUSR2)
+	for sig := range c {
+		switch sig {
+		case syscall.SIGUSR1:
+			statusDump()
+			dumpFile()
+		case syscall.SIGUSR2:
+			setVerbose(!debugging())
+			log.Printf("verbose logging: %t\n", debugging())
+		}
+	}
+}
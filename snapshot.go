@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// snapshotRow is a table row's columns and values
+type snapshotRow struct {
+	Tags   map[string]string      `json:"tags"`
+	Values map[string]interface{} `json:"values"`
+}
+
+// snapshot polls tables on a slow schedule, saving each row
+// as a point and keeping the rows of the last walk of each table for each host
+type snapshot struct {
+	sync.Mutex
+	measurement string
+	rows        map[string]map[string]map[string]*snapshotRow // host, oid, series
+}
+
+func newSnapshot(measurement string) *snapshot {
+	return &snapshot{
+		measurement: measurement,
+		rows:        make(map[string]map[string]map[string]*snapshotRow),
+	}
+}
+
+// addRow includes the value in the rows of a walk in progress
+func addRow(rows map[string]*snapshotRow, host string, name string, tags map[string]string, value interface{}) {
+	key := seriesKey(host, tags)
+	row, ok := rows[key]
+	if !ok {
+		row = &snapshotRow{Tags: tags, Values: make(map[string]interface{})}
+		rows[key] = row
+	}
+	row.Values[name] = value
+}
+
+// save replaces the rows of the table for the host with those of its last walk,
+// so rows that are gone, such as removed modules or neighbors, are dropped
+func (s *snapshot) save(host, oid string, rows map[string]*snapshotRow) {
+	s.Lock()
+	tables, ok := s.rows[host]
+	if !ok {
+		tables = make(map[string]map[string]*snapshotRow)
+		s.rows[host] = tables
+	}
+	tables[oid] = rows
+	s.Unlock()
+}
+
+// poll walks the table at the given frequency, keeping its rows when a walk succeeds
+func (s *snapshot) poll(send SendFunc, p snmp.Profile, c *SnmpConfig, oid string, freq int, stop chan struct{}) {
+	rows := make(map[string]*snapshotRow)
+	values := 0
+	sender := func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+		addRow(rows, p.Host, name, tags, value)
+		values++
+		return send(s.measurement, tags, map[string]interface{}{name: value}, ts.Start)
+	}
+	stats := snmpStats{Freq: freq}
+	var recent errorRing
+	var m sync.Mutex
+	errFn := func(err error) {
+		if err == nil {
+			s.save(p.Host, oid, rows)
+		} else {
+			log.Printf("%s walk of %s for %s failed: %s\n", s.measurement, oid, p.Host, err)
+		}
+		m.Lock()
+		if err == nil {
+			stats.GetCnt++
+			stats.Values += int64(values)
+			stats.LastOK = time.Now()
+		} else {
+			stats.ErrCnt++
+			stats.LastError = err
+			stats.LastTime = time.Now()
+			recent.add(err)
+		}
+		m.Unlock()
+		rows = make(map[string]*snapshotRow)
+		values = 0
+	}
+	addStats(fmt.Sprintf("%s/%s/%s", p.Host, s.measurement, oid), func() snmpStats {
+		m.Lock()
+		defer m.Unlock()
+		st := stats
+		st.Recent = recent.list()
+		return st
+	})
+	crit := snmp.Criteria{
+		OID:  oid,
+		Freq: freq,
+		Tags: map[string]string{"host": p.Host},
+	}
+	for k, v := range commonTags {
+		crit.Tags[k] = v
+	}
+	pollAgent(p, c, crit, stop, snmp.IntegerSender(sender), errFn)
+}
+
+// latest returns a copy of the current rows for each host
+func (s *snapshot) latest() map[string][]snapshotRow {
+	snap := make(map[string][]snapshotRow)
+	s.Lock()
+	for host, tables := range s.rows {
+		for _, rows := range tables {
+			for _, row := range rows {
+				values := make(map[string]interface{}, len(row.Values))
+				for k, v := range row.Values {
+					values[k] = v
+				}
+				snap[host] = append(snap[host], snapshotRow{Tags: row.Tags, Values: values})
+			}
+		}
+	}
+	s.Unlock()
+	return snap
+}
+
+// ServeHTTP returns the latest snapshot as json
+func (s *snapshot) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.latest()); err != nil {
+		log.Printf("%s error:%s\n", s.measurement, err)
+	}
+}
+
+// remove drops the rows kept for the host
+func (s *snapshot) remove(host string) {
+	s.Lock()
+	delete(s.rows, host)
+	s.Unlock()
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	snmp "github.com/paulstuart/snmputil"
+	"github.com/soniah/gosnmp"
+)
+
+// setMeasurement records each SNMP set, whether or not it succeeded
+const setMeasurement = "influxsnmp_set"
+
+// authorized returns true if the request has the admin token
+func authorized(r *http.Request) bool {
+	token := cfg.Common.AdminToken
+	auth := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return len(token) > 0 && subtle.ConstantTimeCompare([]byte(auth), []byte(token)) == 1
+}
+
+// numericOID returns the oid with a leading dot, as gosnmp expects
+func numericOID(oid string) (string, error) {
+	trimmed := strings.Trim(oid, ".")
+	for _, sub := range strings.Split(trimmed, ".") {
+		if _, err := strconv.ParseUint(sub, 10, 32); err != nil {
+			return "", fmt.Errorf("invalid oid: %s", oid)
+		}
+	}
+	return "." + trimmed, nil
+}
+
+// setAllowed returns true if the oid is in, or below, one of the allowed oids
+func setAllowed(c *SnmpConfig, oid string) bool {
+	for _, list := range c.SetAllow {
+		for _, allow := range strings.Fields(list) {
+			prefix, err := numericOID(allow)
+			if err != nil {
+				continue
+			}
+			if oid == prefix || strings.HasPrefix(oid, prefix+".") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setPDU returns the variable to set
+func setPDU(oid, kind, value string) (gosnmp.SnmpPDU, error) {
+	pdu := gosnmp.SnmpPDU{Name: oid}
+	switch kind {
+	case "integer":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return pdu, fmt.Errorf("invalid integer: %s", value)
+		}
+		pdu.Type, pdu.Value = gosnmp.Integer, n
+	case "unsigned":
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return pdu, fmt.Errorf("invalid unsigned: %s", value)
+		}
+		pdu.Type, pdu.Value = gosnmp.Gauge32, uint32(n)
+	case "string":
+		pdu.Type, pdu.Value = gosnmp.OctetString, []byte(value)
+	case "ip":
+		pdu.Type, pdu.Value = gosnmp.IPAddress, value
+	default:
+		return pdu, fmt.Errorf("invalid type: %s", kind)
+	}
+	return pdu, nil
+}
+
+// setTarget returns the profile a set is sent to, reached the same way as the
+// agent is polled: through its proxy, or at the address chosen by its resolution
+// policy. The write community is used in place of the agent's credential, and
+// through a proxy the write community that selects the agent
+func setTarget(c *SnmpConfig, host string) (snmp.Profile, error) {
+	p := withV3(snmp.Profile{
+		Host:      host,
+		Community: c.SetCommunity,
+		Version:   c.Version,
+		Port:      c.Port,
+		Retries:   c.Retries,
+		Timeout:   c.Timeout,
+	}, c)
+	if via, ok := viaProxy(p, c); ok {
+		if c.Version == "3" {
+			// the user is sent rather than a community
+			return via, nil
+		}
+		// the proxy selects the agent by community, which must be one it writes with
+		community, ok := pairs(c.ProxySetCommunity)[host]
+		if !ok {
+			return via, fmt.Errorf("no proxySetCommunity for %s, sets through its proxy are refused", host)
+		}
+		via.Community = community
+		return via, nil
+	}
+	p, _ = resolved(p, nil)
+	return p, nil
+}
+
+// snmpSet sets the variable on the host, with the snmp config's write community,
+// or its SNMPv3 user
+func snmpSet(c *SnmpConfig, host string, pdu gosnmp.SnmpPDU) error {
+	p, err := setTarget(c, host)
+	if err != nil {
+		return err
+	}
+	g := gosnmpClient(p, c)
+	if err := g.Connect(); err != nil {
+		return err
+	}
+	defer g.Conn.Close()
+	result, err := g.Set([]gosnmp.SnmpPDU{pdu})
+	if err != nil {
+		return err
+	}
+	if result.Error != gosnmp.NoError {
+		return fmt.Errorf("set refused by agent: %v", result.Error)
+	}
+	return nil
+}
+
+// auditSet logs the set, and saves it with the agent's points and as a grafana annotation
+func auditSet(agent, host, oid, kind, value, from string, err error) {
+	result := "ok"
+	if err != nil {
+		result = err.Error()
+	}
+	log.Printf("snmp set of %s %s = %s %q from %s: %s\n", host, oid, kind, value, from, result)
+	if s, ok := senders[senderName(agent)]; ok {
+		tags := map[string]string{"host": host, "oid": oid}
+		fields := map[string]interface{}{"type": kind, "value": value, "from": from, "result": result}
+		if err := s.Send("", setMeasurement, tags, fields, time.Now()); err != nil {
+			log.Printf("snmp set audit error: %s\n", err)
+		}
+	}
+	text := fmt.Sprintf("snmp set %s %s = %s (%s)", host, oid, value, result)
+	if err := annotate(text, "snmp-set", host); err != nil {
+		log.Printf("snmp set annotation error: %s\n", err)
+	}
+}
+
+// setAPI sets a variable on an agent that allows it. The oid must be numeric,
+// and in the snmp config's allow list, e.g., to clear a counter or change an interface's admin status
+func setAPI(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	host, kind, value := r.FormValue("host"), r.FormValue("type"), r.FormValue("value")
+	oid, err := numericOID(r.FormValue("oid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pdu, err := setPDU(oid, kind, value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for name, c := range snmpConfigs() {
+		if c.Disabled || (len(c.SetCommunity) == 0 && !c.v3()) || !hasField(c.Host, host) || !setAllowed(c, oid) {
+			continue
+		}
+		err := snmpSet(c, host, pdu)
+		auditSet(name, host, oid, kind, value, r.RemoteAddr, err)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	auditSet("", host, oid, kind, value, r.RemoteAddr, fmt.Errorf("not allowed"))
+	http.Error(w, "set not allowed", http.StatusForbidden)
+}
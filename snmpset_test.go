@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestSetTarget(t *testing.T) {
+	c := &SnmpConfig{
+		Host:              "branch1 branch2",
+		SetCommunity:      "private",
+		ProxyHost:         "mgmt-proxy:1161",
+		ProxyCommunity:    "branch1=b1-proxy branch2=b2-proxy",
+		ProxySetCommunity: "branch1=b1-write",
+	}
+	p, err := setTarget(c, "branch1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Host != "mgmt-proxy" || p.Port != 1161 || p.Community != "b1-write" {
+		t.Errorf("got %s:%d with %s, want the proxy with the write community", p.Host, p.Port, p.Community)
+	}
+	if _, err := setTarget(c, "branch2"); err == nil {
+		t.Error("expected a set through the proxy without a write community to be refused")
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	snmp "github.com/paulstuart/snmputil"
+	"github.com/soniah/gosnmp"
+)
+
+// usmMinPassword is the shortest password the user security model allows
+const usmMinPassword = 8
+
+var (
+	// authProtocols are the SNMPv3 authentication protocols, by name
+	authProtocols = map[string]gosnmp.SnmpV3AuthProtocol{
+		"MD5":    gosnmp.MD5,
+		"SHA":    gosnmp.SHA,
+		"SHA224": gosnmp.SHA224,
+		"SHA256": gosnmp.SHA256,
+		"SHA384": gosnmp.SHA384,
+		"SHA512": gosnmp.SHA512,
+	}
+	// privProtocols are the SNMPv3 privacy protocols, by name
+	privProtocols = map[string]gosnmp.SnmpV3PrivProtocol{
+		"DES":    gosnmp.DES,
+		"AES":    gosnmp.AES,
+		"AES192": gosnmp.AES192,
+		"AES256": gosnmp.AES256,
+	}
+	// libraryProtocols are the protocols the SNMP library polls with
+	libraryProtocols = map[string]bool{"": true, "MD5": true, "SHA": true, "DES": true, "AES": true}
+)
+
+// v3 returns true if the snmp config uses SNMPv3
+func (c *SnmpConfig) v3() bool {
+	return c.Version == "3"
+}
+
+// nativeV3 returns true if the config's agents are polled with gosnmp rather than the
+// SNMP library, which takes no context name and only the MD5, SHA, DES and AES protocols
+func (c *SnmpConfig) nativeV3() bool {
+	if !c.v3() {
+		return false
+	}
+	return len(c.Context) > 0 || !libraryProtocols[strings.ToUpper(c.AuthProtocol)] ||
+		!libraryProtocols[strings.ToUpper(c.PrivProtocol)]
+}
+
+// secLevel returns the security level of an SNMPv3 config, as net-snmp names it
+func (c *SnmpConfig) secLevel() string {
+	switch {
+	case len(c.PrivPassword) > 0:
+		return "authPriv"
+	case len(c.AuthPassword) > 0:
+		return "authNoPriv"
+	}
+	return "noAuthNoPriv"
+}
+
+// checkV3 verifies the SNMPv3 settings of a config are complete and consistent
+func checkV3(c *SnmpConfig) error {
+	if !c.v3() {
+		if len(c.SecurityName) > 0 || len(c.AuthPassword) > 0 || len(c.PrivPassword) > 0 {
+			return fmt.Errorf("SNMPv3 settings need version = 3")
+		}
+		return nil
+	}
+	if len(c.SecurityName) == 0 {
+		return fmt.Errorf("no securityName for SNMPv3")
+	}
+	if c.nativeV3() && len(c.Aliases) > 0 {
+		return fmt.Errorf("aliases can't be used with a context or the %s/%s protocols", c.AuthProtocol, c.PrivProtocol)
+	}
+	if len(c.PrivPassword) > 0 && len(c.AuthPassword) == 0 {
+		return fmt.Errorf("privacy needs authentication")
+	}
+	if len(c.AuthPassword) > 0 {
+		if _, ok := authProtocols[strings.ToUpper(c.AuthProtocol)]; !ok {
+			return fmt.Errorf("invalid authProtocol: %q", c.AuthProtocol)
+		}
+		if len(c.AuthPassword) < usmMinPassword {
+			return fmt.Errorf("authPassword must be at least %d characters", usmMinPassword)
+		}
+	}
+	if len(c.PrivPassword) > 0 {
+		if _, ok := privProtocols[strings.ToUpper(c.PrivProtocol)]; !ok {
+			return fmt.Errorf("invalid privProtocol: %q", c.PrivProtocol)
+		}
+		if len(c.PrivPassword) < usmMinPassword {
+			return fmt.Errorf("privPassword must be at least %d characters", usmMinPassword)
+		}
+	}
+	return nil
+}
+
+// withV3 adds the SNMPv3 security settings of the config to the profile
+func withV3(p snmp.Profile, c *SnmpConfig) snmp.Profile {
+	if !c.v3() {
+		return p
+	}
+	p.SecLevel = c.secLevel()
+	p.AuthUser = c.SecurityName
+	p.AuthProto = strings.ToUpper(c.AuthProtocol)
+	p.AuthPass = c.AuthPassword
+	p.PrivProto = strings.ToUpper(c.PrivProtocol)
+	p.PrivPass = c.PrivPassword
+	return p
+}
+
+// usm sets up the client with the SNMPv3 security settings of the profile, and the context
+func usm(g *gosnmp.GoSNMP, p snmp.Profile, context string) {
+	g.Version = gosnmp.Version3
+	g.SecurityModel = gosnmp.UserSecurityModel
+	g.ContextName = context
+	params := &gosnmp.UsmSecurityParameters{UserName: p.AuthUser}
+	g.MsgFlags = gosnmp.NoAuthNoPriv
+	if len(p.AuthPass) > 0 {
+		g.MsgFlags = gosnmp.AuthNoPriv
+		params.AuthenticationProtocol = authProtocols[strings.ToUpper(p.AuthProto)]
+		params.AuthenticationPassphrase = p.AuthPass
+	}
+	if len(p.PrivPass) > 0 {
+		g.MsgFlags = gosnmp.AuthPriv
+		params.PrivacyProtocol = privProtocols[strings.ToUpper(p.PrivProto)]
+		params.PrivacyPassphrase = p.PrivPass
+	}
+	g.SecurityParameters = params
+}
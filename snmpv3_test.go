@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+func TestCheckV3(t *testing.T) {
+	tests := []struct {
+		name string
+		c    SnmpConfig
+		fail bool
+	}{
+		{name: "v2c", c: SnmpConfig{Version: "2c"}},
+		{name: "v2c with a user", c: SnmpConfig{Version: "2c", SecurityName: "poller"}, fail: true},
+		{name: "v2c with a password", c: SnmpConfig{AuthPassword: "secret123"}, fail: true},
+		{name: "no user", c: SnmpConfig{Version: "3"}, fail: true},
+		{name: "noAuthNoPriv", c: SnmpConfig{Version: "3", SecurityName: "poller"}},
+		{name: "context", c: SnmpConfig{Version: "3", SecurityName: "poller", Context: "vlan-10"}},
+		{name: "context with aliases", c: SnmpConfig{Version: "3", SecurityName: "poller", Context: "vlan-10",
+			Aliases: "1/4=internet"}, fail: true},
+		{name: "sha-2", c: SnmpConfig{Version: "3", SecurityName: "poller",
+			AuthProtocol: "sha512", AuthPassword: "secret123", PrivProtocol: "AES256", PrivPassword: "private99"}},
+		{name: "authNoPriv", c: SnmpConfig{Version: "3", SecurityName: "poller", AuthProtocol: "sha", AuthPassword: "secret123"}},
+		{name: "authPriv", c: SnmpConfig{Version: "3", SecurityName: "poller",
+			AuthProtocol: "MD5", AuthPassword: "secret123", PrivProtocol: "aes", PrivPassword: "private99"}},
+		{name: "priv without auth", c: SnmpConfig{Version: "3", SecurityName: "poller",
+			PrivProtocol: "AES", PrivPassword: "private99"}, fail: true},
+		{name: "unknown auth", c: SnmpConfig{Version: "3", SecurityName: "poller",
+			AuthProtocol: "SHA3", AuthPassword: "secret123"}, fail: true},
+		{name: "unknown priv", c: SnmpConfig{Version: "3", SecurityName: "poller",
+			AuthProtocol: "SHA", AuthPassword: "secret123", PrivProtocol: "AES512", PrivPassword: "private99"}, fail: true},
+		{name: "short auth", c: SnmpConfig{Version: "3", SecurityName: "poller",
+			AuthProtocol: "SHA", AuthPassword: "secret"}, fail: true},
+		{name: "short priv", c: SnmpConfig{Version: "3", SecurityName: "poller",
+			AuthProtocol: "SHA", AuthPassword: "secret123", PrivProtocol: "DES", PrivPassword: "priv"}, fail: true},
+	}
+	for _, tt := range tests {
+		err := checkV3(&tt.c)
+		if tt.fail && err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		} else if !tt.fail && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
+
+func TestNativeV3(t *testing.T) {
+	tests := []struct {
+		c    SnmpConfig
+		want bool
+	}{
+		{SnmpConfig{Version: "2c", Context: "vlan-10"}, false},
+		{SnmpConfig{Version: "3", AuthProtocol: "sha", PrivProtocol: "aes"}, false},
+		{SnmpConfig{Version: "3"}, false},
+		{SnmpConfig{Version: "3", Context: "vlan-10"}, true},
+		{SnmpConfig{Version: "3", AuthProtocol: "SHA256"}, true},
+		{SnmpConfig{Version: "3", AuthProtocol: "SHA", PrivProtocol: "AES192"}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.c.nativeV3(); got != tt.want {
+			t.Errorf("%+v: got %t, want %t", tt.c, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultPageSize is how many agents are shown per status page
+const defaultPageSize = 50
+
+// agentStat is an agent's polling statistics
+type agentStat struct {
+	Name  string
+	Stats snmpStats
+	Links []agentLink
+}
+
+// statusQuery filters, sorts and pages the agents on the status page
+type statusQuery struct {
+	Host  string // substring of the host
+	Group string // snmp config name
+	State string // "error" or "ok"
+	Sort  string // "name", "errors" or "lasterror"
+	Page  int
+	Size  int
+}
+
+func parseStatusQuery(r *http.Request) statusQuery {
+	v := r.URL.Query()
+	q := statusQuery{
+		Host:  v.Get("host"),
+		Group: v.Get("group"),
+		State: v.Get("state"),
+		Sort:  v.Get("sort"),
+	}
+	q.Page, _ = strconv.Atoi(v.Get("page"))
+	if q.Page < 1 {
+		q.Page = 1
+	}
+	q.Size, _ = strconv.Atoi(v.Get("size"))
+	if q.Size < 1 {
+		q.Size = defaultPageSize
+	}
+	return q
+}
+
+// URL returns the status page link for the page of the query
+func (q statusQuery) URL(page int) string {
+	v := url.Values{}
+	for k, s := range map[string]string{"host": q.Host, "group": q.Group, "state": q.State, "sort": q.Sort} {
+		if len(s) > 0 {
+			v.Set(k, s)
+		}
+	}
+	if q.Size != defaultPageSize {
+		v.Set("size", strconv.Itoa(q.Size))
+	}
+	v.Set("page", strconv.Itoa(page))
+	return "/?" + v.Encode()
+}
+
+// match returns true if the agent passes the filters
+func (q statusQuery) match(a agentStat) bool {
+	host, group := a.Name, ""
+	if i := strings.LastIndex(a.Name, "/"); i >= 0 {
+		host, group = a.Name[:i], a.Name[i+1:]
+	}
+	if len(q.Host) > 0 && !strings.Contains(host, q.Host) {
+		return false
+	}
+	if len(q.Group) > 0 && group != q.Group {
+		return false
+	}
+	switch q.State {
+	case "error":
+		return a.Stats.ErrCnt > 0
+	case "ok":
+		return a.Stats.ErrCnt == 0
+	}
+	return true
+}
+
+// apply returns the page of agents selected, and how many pages there are
+func (q statusQuery) apply(stats map[string]snmpStats) ([]agentStat, int) {
+	list := make([]agentStat, 0, len(stats))
+	for name, s := range stats {
+		a := agentStat{Name: name, Stats: s}
+		if q.match(a) {
+			list = append(list, a)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		switch q.Sort {
+		case "errors":
+			if a.Stats.ErrCnt != b.Stats.ErrCnt {
+				return a.Stats.ErrCnt > b.Stats.ErrCnt
+			}
+		case "lasterror":
+			if !a.Stats.LastTime.Equal(b.Stats.LastTime) {
+				return a.Stats.LastTime.After(b.Stats.LastTime)
+			}
+		}
+		return a.Name < b.Name
+	})
+	pages := (len(list) + q.Size - 1) / q.Size
+	start := (q.Page - 1) * q.Size
+	if start >= len(list) {
+		return nil, pages
+	}
+	end := start + q.Size
+	if end > len(list) {
+		end = len(list)
+	}
+	return list[start:end], pages
+}
+
+// statusPage is the status with the agents selected by the query
+type statusPage struct {
+	SystemStatus
+	Query  statusQuery
+	Agents []agentStat
+	Pages  int
+}
+
+// Prev returns the previous page number, or 0 if there is none
+func (p statusPage) Prev() int {
+	if p.Query.Page > 1 {
+		return p.Query.Page - 1
+	}
+	return 0
+}
+
+// Next returns the next page number, or 0 if there is none
+func (p statusPage) Next() int {
+	if p.Query.Page < p.Pages {
+		return p.Query.Page + 1
+	}
+	return 0
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// sysNameRetry is how long to wait before asking again for a sysName that could not be read
+const sysNameRetry = 10 * time.Minute
+
+// sysNames caches the sysName of each agent
+var sysNames = struct {
+	sync.Mutex
+	names map[string]string
+	tried map[string]time.Time
+}{
+	names: make(map[string]string),
+	tried: make(map[string]time.Time),
+}
+
+// sysName returns the agent's sysName, or its host if it can't be read
+func sysName(p snmp.Profile, c *SnmpConfig) string {
+	sysNames.Lock()
+	if name, ok := sysNames.names[p.Host]; ok {
+		sysNames.Unlock()
+		return name
+	}
+	if time.Since(sysNames.tried[p.Host]) < sysNameRetry {
+		sysNames.Unlock()
+		return p.Host
+	}
+	sysNames.tried[p.Host] = time.Now()
+	sysNames.Unlock()
+
+	var name string
+	sender := func(_ string, _ map[string]string, value interface{}, _ snmp.TimeStamp) error {
+		if s, ok := value.(string); ok {
+			name = s
+		}
+		return nil
+	}
+	if err := sampleAgent(p, c, snmp.Criteria{OID: "sysName", Freq: 1, Count: 1}, sender); err != nil || len(name) == 0 {
+		return p.Host
+	}
+	sysNames.Lock()
+	sysNames.names[p.Host] = name
+	sysNames.Unlock()
+	return name
+}
+
+// cachedSysName returns the agent's sysName if it has been read
+func cachedSysName(p snmp.Profile) (string, bool) {
+	sysNames.Lock()
+	defer sysNames.Unlock()
+	name, ok := sysNames.names[p.Host]
+	return name, ok
+}
+
+// removeSysName forgets the agent's sysName
+func removeSysName(host string) {
+	sysNames.Lock()
+	delete(sysNames.names, host)
+	delete(sysNames.tried, host)
+	sysNames.Unlock()
+}
+
+// resolveSysName reads the agent's sysName before its walk starts,
+// and keeps trying in the background if it can't be read yet
+func resolveSysName(p snmp.Profile, c *SnmpConfig) {
+	sysName(p, c)
+	if _, ok := cachedSysName(p); ok {
+		return
+	}
+	go func() {
+		for !stopping() {
+			time.Sleep(sysNameRetry)
+			sysName(p, c)
+			if _, ok := cachedSysName(p); ok {
+				return
+			}
+		}
+	}()
+}
+
+// expand replaces {host}, {sysName} and {tag} placeholders in the value
+func expand(value string, p snmp.Profile, tags map[string]string) string {
+	return replaceVars(value, func(key string) (string, bool) {
+		switch key {
+		case "host":
+			return p.Host, true
+		case "sysName":
+			if name, ok := cachedSysName(p); ok {
+				return name, true
+			}
+			return p.Host, true
+		}
+		v, ok := tags[key]
+		return v, ok
+	})
+}
+
+// replaceVars replaces each {key} with its value, leaving unknown keys as they are
+func replaceVars(s string, lookup func(string) (string, bool)) string {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '{')
+		if i < 0 {
+			break
+		}
+		j := strings.IndexByte(s[i:], '}')
+		if j < 0 {
+			break
+		}
+		b.WriteString(s[:i])
+		key := s[i+1 : i+j]
+		if v, ok := lookup(key); ok {
+			b.WriteString(v)
+		} else {
+			b.WriteString(s[i : i+j+1])
+		}
+		s = s[i+j+1:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+// TemplateSender expands placeholders in tag values, such as the
+// tags and aliases of an snmp config shared by many hosts.
+// A sysName is read once, before the walk starts
+func TemplateSender(sender snmp.Sender, p snmp.Profile, c *SnmpConfig) snmp.Sender {
+	placeholders := c.Tags + " " + c.Aliases
+	for _, v := range c.tags {
+		placeholders += " " + v
+	}
+	if strings.Contains(placeholders, "{sysName}") {
+		resolveSysName(p, c)
+	}
+	return func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+		var expanded map[string]string
+		for k, v := range tags {
+			if !strings.Contains(v, "{") {
+				continue
+			}
+			if expanded == nil {
+				expanded = make(map[string]string, len(tags))
+				for k, v := range tags {
+					expanded[k] = v
+				}
+			}
+			expanded[k] = expand(v, p, tags)
+		}
+		if expanded != nil {
+			tags = expanded
+		}
+		return sender(name, tags, value, ts)
+	}
+}
@@ -6,15 +6,20 @@ import (
 )
 
 var (
-	tmpl    *template.Template
-	funcMap = template.FuncMap{
+	tmpl         *template.Template
+	problemsTmpl *template.Template
+	calendarTmpl *template.Template
+	funcMap      = template.FuncMap{
 		"dateFmt": dateFmt,
+		"traffic": trafficString,
 	}
 )
 
 func init() {
 	tmpl = template.Must(template.New("home").Funcs(funcMap).Parse(page))
 	tmpl = tmpl.Funcs(funcMap)
+	problemsTmpl = template.Must(template.New("problems").Funcs(funcMap).Parse(problemsHTML))
+	calendarTmpl = template.Must(template.New("calendar").Funcs(funcMap).Parse(calendarHTML))
 }
 
 func dateFmt(when interface{}) string {
@@ -29,7 +34,7 @@ const (
 	page = `<!DOCTYPE html>
 <html lang="en" xml:lang="en">
 <head>
-<title>Netstats</title>
+<title>{{if .Station}}{{.Station}} {{end}}Netstats</title>
 <style>
 p {
     lineheight: 50%;
@@ -47,16 +52,83 @@ div {
 </style>
 </head>
 <body>
-<h1>Netstats</h1>
+<h1>{{if .Station}}{{.Station}} {{end}}Netstats</h1>
 <p>Started: {{.Started}}</p>
 <p>Uptime: {{.Uptime}}</p>
-{{ range $key,$stat := .SnmpStats }}
+<p>Invalid values: {{.Invalid}}</p>
+{{ with .Memory }}
+<p>Memory: {{printf "%.1f" .HeapMB}} MB, queued points ~{{printf "%.1f" .QueuedMB}} MB, cached values ~{{printf "%.1f" .CachedMB}} MB{{ if .LimitMB }}, limit {{.LimitMB}} MB, points dropped {{.Shed}}{{ end }}</p>
+{{ end }}
+<form method="get" action="/">
+Host <input name="host" value="{{.Query.Host}}">
+Group <input name="group" value="{{.Query.Group}}">
+<select name="state">
+<option value="">all</option>
+<option value="error"{{if eq .Query.State "error"}} selected{{end}}>with errors</option>
+<option value="ok"{{if eq .Query.State "ok"}} selected{{end}}>without errors</option>
+</select>
+<select name="sort">
+<option value="name">by name</option>
+<option value="errors"{{if eq .Query.Sort "errors"}} selected{{end}}>by error count</option>
+<option value="lasterror"{{if eq .Query.Sort "lasterror"}} selected{{end}}>by last error</option>
+</select>
+<input type="submit" value="Filter">
+</form>
+{{ range .Agents }}
+<div>
+<p class="snmp">{{.Name}}</p>
+<p>Get count: {{.Stats.GetCnt}}</p>
+<p>Error count: {{.Stats.ErrCnt}}</p>
+{{if .Stats.TooBig}}<p>Responses too big: {{.Stats.TooBig}}{{if .Stats.Repetitions}}, now asked for {{.Stats.Repetitions}} values per request{{end}}</p>{{end}}
+<p>Received: {{traffic .Stats}}</p>
+{{ with .Links }}
+<p>{{ range . }}<a href="{{.URL}}">{{.Name}}</a> {{ end }}</p>
+{{ range . }}{{ if .Preview }}
+<iframe src="{{.Preview}}" width="450" height="150" frameborder="0"></iframe>
+{{ end }}{{ end }}
+{{ end }}
+{{ if .Stats.LastError }}
+<p>Last error: {{.Stats.LastError}} ({{dateFmt .Stats.LastTime}})</p>
+{{ end }}
+{{ if .Stats.Recent }}
+<details>
+<summary>Recent errors</summary>
+{{ range .Stats.Recent }}
+<p>{{dateFmt .Time}}: {{.Error}}</p>
+{{ end }}
+</details>
+{{ end }}
+</div>
+{{ end }}
+<p>
+{{ with .Prev }}<a href="{{$.Query.URL .}}">Previous</a>{{ end }}
+Page {{.Query.Page}} of {{.Pages}}
+{{ with .Next }}<a href="{{$.Query.URL .}}">Next</a>{{ end }}
+</p>
+{{ with .Fleet }}
+<h1>Fleet</h1>
 <div>
-<p class="snmp">{{$key}}</p>
-<p>Get count: {{$stat.GetCnt}}</p>
-<p>Error count: {{$stat.ErrCnt}}</p>
-{{ if $stat.LastError }}
-<p>Last error: {{$stat.LastError}} ({{dateFmt $stat.LastTime}})</p>
+{{ range .Peers }}
+<p>{{if .Station}}{{.Station}} {{end}}({{.URL}}): polling {{len .Polling}} of {{len .Configured}} devices, as of {{dateFmt .Updated}}{{if .Error}}, unreachable: {{.Error}}{{end}}</p>
+{{ end }}
+{{ if .Uncovered }}
+<p>Not polled by any collector: {{range .Uncovered}}{{.}} {{end}}</p>
+{{ end }}
+</div>
+{{ end }}
+{{ if .Quarantined }}
+<h1>Quarantined</h1>
+<div>
+{{ range $oid,$until := .Quarantined }}
+<p>{{$oid}}: until {{dateFmt $until}}</p>
+{{ end }}
+</div>
+{{ end }}
+{{ if .Series }}
+<h1>Series</h1>
+<div>
+{{ range $name,$count := .Series }}
+<p>{{$name}}: {{$count}}</p>
 {{ end }}
 </div>
 {{ end }}
@@ -70,19 +142,129 @@ div {
 <p>Timeout: {{$snmp.Timeout}}</p>
 </div>
 {{ end}}
+{{ $senders := .Senders }}
 {{ range $key,$influx := .Influx }}
 <div>
 <p class="snmp">Influx {{$key}}</p>
 <p>Host: {{$influx.URL}}</p>
 <p>Database: {{$influx.Database}}</p>
-{{/*
-<p>Sent: {{$influx.Sent}}</p>
-<p>Errors: {{$influx.Errors}}</p>
-*/}}
+{{ with index $senders $key }}
+<p>Sent: {{.Sent}}</p>
+<p>Queued: {{.Queued}}</p>
+<p>Dropped: {{.Dropped}}</p>
+<p>Errors: {{.Errors}}</p>
+{{ if not .ThrottledUntil.IsZero }}
+<p>Last throttled until: {{dateFmt .ThrottledUntil}}</p>
+{{ end }}
+{{ if or .Verified .Unverified }}
+<p>Verified: {{.Verified}} Not saved as written: {{.Unverified}}</p>
+{{ end }}
+{{ range $class,$count := .Classes }}
+<p>{{$class}} errors: {{$count}}</p>
+{{ end }}
+{{ if .LastError }}
+<p>Last error: {{.LastError}} ({{dateFmt .LastTime}})</p>
+{{ end }}
+{{ end }}
 </div>
 {{ end }}
+<p><a href="/problems">Problems</a></p>
+<p><a href="/calendar">Polling calendar</a></p>
 <p><a href="/debug/pprof/">Profiler</a></p>
 </body>
 </html>
+`
+
+	problemsHTML = `<!DOCTYPE html>
+<html lang="en" xml:lang="en">
+<head>
+<title>{{if .Station}}{{.Station}} {{end}}Problems</title>
+<meta http-equiv="refresh" content="60">
+<style>
+td, th {
+    padding: 0.2em 1em;
+    text-align: left;
+}
+.failing {
+    color: darkorange;
+}
+.silent {
+    color: red;
+}
+</style>
+</head>
+<body>
+<h1>{{if .Station}}{{.Station}} {{end}}Problems</h1>
+<p>Agents: {{.Total}} Healthy: {{.Healthy}} Failing: {{.Failing}} Silent: {{.Silent}}</p>
+{{ if .Problems }}
+<table>
+<tr><th>Agent</th><th>State</th><th>Errors</th><th>Last error</th><th>Last success</th></tr>
+{{ range .Problems }}
+<tr class="{{.State}}">
+<td>{{.Name}}</td>
+<td>{{.State}}</td>
+<td>{{.Errors}}</td>
+<td>{{.LastError}} {{dateFmt .LastTime}}
+{{ if .Recent }}
+<details>
+<summary>Recent errors</summary>
+{{ range .Recent }}
+<p>{{dateFmt .Time}}: {{.Error}}</p>
+{{ end }}
+</details>
+{{ end }}
+</td>
+<td>{{dateFmt .LastOK}}</td>
+</tr>
+{{ end }}
+</table>
+{{ else }}
+<p>No problems</p>
+{{ end }}
+<p><a href="/">Status</a></p>
+</body>
+</html>
+`
+
+	calendarHTML = `<!DOCTYPE html>
+<html lang="en" xml:lang="en">
+<head>
+<title>{{if .Station}}{{.Station}} {{end}}Polling calendar</title>
+<meta http-equiv="refresh" content="60">
+<style>
+table {
+    border-collapse: collapse;
+}
+td {
+    width: 1em;
+    height: 1em;
+    border: 1px solid #eee;
+}
+th {
+    padding: 0 0.5em;
+    text-align: right;
+    font-weight: normal;
+}
+.l1 { background: #c6e48b; }
+.l2 { background: #7bc96f; }
+.l3 { background: #f0a030; }
+.l4 { background: #d03020; }
+</style>
+</head>
+<body>
+<h1>{{if .Station}}{{.Station}} {{end}}Polling calendar</h1>
+<p>Walks: {{len .Walks}} Interval: {{.Interval}}s Busiest second: {{.Peak}} walks</p>
+<table>
+{{ range .Rows }}
+<tr>
+<th>{{(index . 0).Second}}s</th>
+{{ range . }}<td class="l{{.Level}}" title="{{.Walks}}"></td>{{ end }}
+</tr>
+{{ end }}
+</table>
+<p><a href="/calendar?format=json">JSON</a></p>
+<p><a href="/">Status</a></p>
+</body>
+</html>
 `
 )
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TenantConfig restricts a group of agents to their own senders,
+// and tags all of their points with the tenant name
+type TenantConfig struct {
+	Agents  string `gcfg:"agents"`  // snmp config names
+	Senders string `gcfg:"senders"` // influx config names the agents may use
+	Token   string `gcfg:"token"`   // bearer token for querying the tenant's data
+}
+
+// tenantTag is the tag identifying the tenant of a point
+const tenantTag = "tenant"
+
+// tenantOf returns the tenant the snmp config belongs to, if any
+func tenantOf(agent string) string {
+	if from, ok := discoveredFrom(agent); ok {
+		agent = from
+	}
+	for name, t := range cfg.Tenant {
+		for _, a := range strings.Fields(t.Agents) {
+			if a == agent {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// tenantSender returns true if the tenant may use the influx config
+func tenantSender(tenant, sender string) bool {
+	t, ok := cfg.Tenant[tenant]
+	if !ok {
+		return false
+	}
+	for _, s := range strings.Fields(t.Senders) {
+		if s == sender {
+			return true
+		}
+	}
+	return false
+}
+
+// senderTenant returns the tenant the influx config belongs to, if any
+func senderTenant(sender string) string {
+	for name := range cfg.Tenant {
+		if tenantSender(name, sender) {
+			return name
+		}
+	}
+	return ""
+}
+
+// senderName returns the name of the influx config the snmp config uses:
+// its own, the first of its tenant's, or the default
+func senderName(agent string) string {
+	if from, ok := discoveredFrom(agent); ok {
+		agent = from
+	}
+	if _, ok := cfg.Influx[agent]; ok {
+		return agent
+	}
+	if t, ok := cfg.Tenant[tenantOf(agent)]; ok {
+		if list := strings.Fields(t.Senders); len(list) > 0 {
+			return list[0]
+		}
+	}
+	return "*"
+}
+
+// checkTenants ensures no agent belongs to more than one tenant,
+// and that no sender is shared between tenants or with agents outside of them
+func checkTenants(agents []snmpInfo) error {
+	owner := make(map[string]string) // agent to tenant
+	snmps := snmpConfigs()
+	for name, t := range cfg.Tenant {
+		for _, a := range strings.Fields(t.Agents) {
+			if _, ok := snmps[a]; !ok {
+				return fmt.Errorf("tenant %s: no snmp config %s", name, a)
+			}
+			if prior, ok := owner[a]; ok {
+				return fmt.Errorf("snmp config %s belongs to tenants %s and %s", a, prior, name)
+			}
+			owner[a] = name
+		}
+	}
+	users := make(map[string]string) // sender to tenant
+	for _, a := range agents {
+		tenant := tenantOf(a.Name)
+		sender := senderName(a.Name)
+		if len(tenant) > 0 {
+			if !tenantSender(tenant, sender) {
+				return fmt.Errorf("snmp config %s of tenant %s uses influx config %s, which the tenant may not", a.Name, tenant, sender)
+			}
+		}
+		if prior, ok := users[sender]; ok && prior != tenant {
+			return fmt.Errorf("influx config %s is shared by tenants %q and %q", sender, prior, tenant)
+		}
+		users[sender] = tenant
+	}
+	return nil
+}
+
+// TenantSender tags every point with the tenant, replacing any tag already set
+func TenantSender(send SendFunc, tenant string) SendFunc {
+	if len(tenant) == 0 {
+		return send
+	}
+	return func(name string, tags map[string]string, fields map[string]interface{}, ts time.Time) error {
+		return send(name, withTag(tags, tenantTag, tenant), fields, ts)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	client "github.com/influxdata/influxdb/client/v2"
+)
+
+// maxThrottle limits how long a Retry-After can pause writes
+const maxThrottle = 10 * time.Minute
+
+// throttleError is a write refused because the server is overloaded or rate limiting,
+// with how long it asked to wait before trying again
+type throttleError struct {
+	wait time.Duration
+	msg  string
+}
+
+func (e *throttleError) Error() string {
+	return fmt.Sprintf("throttled for %s: %s", e.wait, e.msg)
+}
+
+// statusError is a write the server refused, with the status it answered
+type statusError struct {
+	code   int
+	status string
+	body   string
+}
+
+func (e *statusError) Error() string {
+	if msg := strings.TrimSpace(e.body); len(msg) > 0 {
+		return msg
+	}
+	return e.status
+}
+
+// retryAfter returns the wait given by a Retry-After header,
+// as seconds or a date, or the default retry if there is none
+func retryAfter(header string, now time.Time) time.Duration {
+	wait := retry
+	if secs, err := strconv.Atoi(strings.TrimSpace(header)); err == nil {
+		wait = time.Duration(secs) * time.Second
+	} else if when, err := http.ParseTime(header); err == nil {
+		wait = when.Sub(now)
+	}
+	if wait < time.Second {
+		wait = time.Second
+	}
+	if wait > maxThrottle {
+		wait = maxThrottle
+	}
+	return wait
+}
+
+// httpWriter returns a function that writes batches like the influxdb client,
+// optionally compressing them, but reports 429 and 503 responses as throttling with the server's Retry-After
+func httpWriter(conf client.HTTPConfig, compress bool) (func(client.BatchPoints) error, error) {
+	u, err := url.Parse(conf.Addr)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path.Join(u.Path, "write")
+	tr := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: conf.InsecureSkipVerify},
+		Proxy:           conf.Proxy,
+		DialContext:     conf.DialContext,
+	}
+	if conf.TLSConfig != nil {
+		tr.TLSClientConfig = conf.TLSConfig
+	}
+	hc := &http.Client{Timeout: conf.Timeout, Transport: tr}
+	agent := conf.UserAgent
+	if len(agent) == 0 {
+		agent = "influxsnmp/" + version
+	}
+
+	return func(bp client.BatchPoints) error {
+		var b bytes.Buffer
+		var w io.Writer = &b
+		var zw *gzip.Writer
+		if compress {
+			zw = gzip.NewWriter(&b)
+			w = zw
+		}
+		for _, pt := range bp.Points() {
+			io.WriteString(w, pt.PrecisionString(bp.Precision()))
+			io.WriteString(w, "\n")
+		}
+		if zw != nil {
+			if err := zw.Close(); err != nil {
+				return err
+			}
+		}
+		req, err := http.NewRequest("POST", u.String(), &b)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("User-Agent", agent)
+		if compress {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		if len(conf.Username) > 0 {
+			req.SetBasicAuth(conf.Username, conf.Password)
+		}
+		q := url.Values{}
+		q.Set("db", bp.Database())
+		q.Set("rp", bp.RetentionPolicy())
+		q.Set("precision", bp.Precision())
+		q.Set("consistency", bp.WriteConsistency())
+		req.URL.RawQuery = q.Encode()
+
+		resp, err := hc.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		switch resp.StatusCode {
+		case http.StatusOK, http.StatusNoContent:
+			return nil
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			return &throttleError{retryAfter(resp.Header.Get("Retry-After"), time.Now()), resp.Status}
+		}
+		return &statusError{resp.StatusCode, resp.Status, string(body)}
+	}, nil
+}
+
+// throttled pauses the writer for as long as the server asked.
+// While it waits the queue fills, so Send blocks and polling slows to match
+func (s *influxSender) throttled(wait time.Duration) {
+	until := time.Now().Add(wait)
+	s.Lock()
+	s.stats.ThrottledUntil = until
+	s.Unlock()
+	log.Printf("influxdb is throttling writes, pausing for %s\n", wait)
+	time.Sleep(wait)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// mibOIDs maps the names in the loaded mibs to their oids, to find the columns of tables
+var mibOIDs map[string]string
+
+// walkReps are how many values are asked for per request, by host,
+// once its agent has answered tooBig
+var walkReps = struct {
+	sync.Mutex
+	reps map[string]uint32
+}{reps: make(map[string]uint32)}
+
+// tooBig returns true if the agent answered that the response would be too large
+// for a single PDU, which some embedded agents do with far fewer OIDs than others
+func tooBig(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "toobig") || strings.Contains(msg, "too big")
+}
+
+// repetitions returns how many values the host's agent is asked for per request,
+// or 0 if it hasn't answered tooBig and the SNMP library's own walk is used
+func repetitions(host string) uint32 {
+	walkReps.Lock()
+	defer walkReps.Unlock()
+	return walkReps.reps[host]
+}
+
+// reduceRepetitions halves the values the host's agent is asked for per request,
+// down to one, unless another walk already reduced them since they were from
+func reduceRepetitions(host string, from uint32) {
+	walkReps.Lock()
+	defer walkReps.Unlock()
+	reps := walkReps.reps[host]
+	if reps != from {
+		return
+	}
+	if reps == 0 {
+		reps = defaultRepetitions
+	}
+	if reps == 1 {
+		log.Printf("%s answered tooBig asking for one value per request, its walks can't be reduced further\n", host)
+		return
+	}
+	reps /= 2
+	walkReps.reps[host] = reps
+	log.Printf("%s answered tooBig, asking for %d values per request from the next poll\n", host, reps)
+}
+
+// removeRepetitions forgets the values per request the host's agent was reduced to
+func removeRepetitions(host string) {
+	walkReps.Lock()
+	delete(walkReps.reps, host)
+	walkReps.Unlock()
+}
+
+// sampleWalk samples the walk, asking the agent for fewer values per request
+// from the next poll each time it answers tooBig. The walk that failed isn't
+// retried, so the rows it sent aren't sent again in the same cycle. Walks with
+// aliases are left to the SNMP library, so they can't be reduced
+func sampleWalk(p snmp.Profile, c *SnmpConfig, crit snmp.Criteria, sender snmp.Sender) error {
+	reps := repetitions(p.Host)
+	err := sampleAgent(p, c, crit, sender)
+	if tooBig(err) && len(crit.Aliases) == 0 {
+		reduceRepetitions(p.Host, reps)
+	}
+	return err
+}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestReduceRepetitions(t *testing.T) {
+	const host = "toobig.example.com"
+	defer removeRepetitions(host)
+	want := []uint32{25, 12, 6, 3, 1, 1}
+	for i, reps := range want {
+		reduceRepetitions(host, repetitions(host))
+		if got := repetitions(host); got != reps {
+			t.Fatalf("after %d tooBig answers asking for %d, want %d", i+1, got, reps)
+		}
+	}
+	// a walk that started before the last reduction doesn't reduce it again
+	removeRepetitions(host)
+	reduceRepetitions(host, 0)
+	reduceRepetitions(host, 0)
+	if got := repetitions(host); got != 25 {
+		t.Errorf("asking for %d, want 25", got)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// otherRows is the tag value of the rollup of the rows not in the top N
+const otherRows = "other"
+
+// topPoint is a buffered point of a row
+type topPoint struct {
+	name   string
+	fields map[string]interface{}
+	ts     time.Time
+}
+
+// topRow is a table row, the points polled with the same tags
+type topRow struct {
+	tags   map[string]string
+	rank   float64
+	ranked bool
+	points []topPoint
+}
+
+// topRows holds a walk's rows until the end of the cycle, to send only the top N
+// by a column, and a rollup of the rest
+type topRows struct {
+	sync.Mutex
+	n        int
+	column   string
+	counters map[string]bool // columns whose values are summed in the rollup, with their deltas
+	rows     map[string]*topRow
+}
+
+// parseTop parses the top rows setting, as "N column", e.g., "20 ifHCInOctets"
+func parseTop(spec string) (int, string, error) {
+	f := strings.Fields(spec)
+	if len(f) == 0 {
+		return 0, "", nil
+	}
+	if len(f) != 2 {
+		return 0, "", fmt.Errorf("invalid top: %q", spec)
+	}
+	n, err := strconv.Atoi(f[0])
+	if err != nil || n < 1 {
+		return 0, "", fmt.Errorf("invalid top count: %q", spec)
+	}
+	return n, f[1], nil
+}
+
+// newTopRows returns the rows of a walk, or nil if it isn't reduced to the top N.
+// The values of the counter columns are summed in the rollup, other columns only by their deltas
+func newTopRows(spec, counters string) *topRows {
+	// the setting was validated at startup
+	n, column, _ := parseTop(spec)
+	if n == 0 {
+		return nil
+	}
+	t := &topRows{n: n, column: column, counters: make(map[string]bool), rows: make(map[string]*topRow)}
+	for _, name := range strings.Fields(counters) {
+		t.counters[name] = true
+	}
+	return t
+}
+
+// add buffers the point in its row, replacing one of the same column
+// sent again by a retry. The row is ranked by the delta of the column,
+// if it has one, otherwise by its value
+func (t *topRows) add(name string, tags map[string]string, fields map[string]interface{}, ts time.Time) {
+	copied := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		copied[k] = v
+	}
+	key := seriesKey("", tags)
+	t.Lock()
+	defer t.Unlock()
+	row, ok := t.rows[key]
+	if !ok {
+		row = &topRow{tags: tags}
+		t.rows[key] = row
+	}
+	i := 0
+	for i < len(row.points) && row.points[i].name != name {
+		i++
+	}
+	if i < len(row.points) {
+		row.points[i] = topPoint{name, copied, ts}
+	} else {
+		row.points = append(row.points, topPoint{name, copied, ts})
+	}
+	if name != t.column {
+		return
+	}
+	v, ok := copied[deltaField]
+	if !ok {
+		v = copied["value"]
+	}
+	row.rank, row.ranked = toFloat(v)
+}
+
+// flush sends the top N rows of the cycle just finished, and the sum of the
+// counters and deltas of the rest with the tags that differ between rows set to "other"
+func (t *topRows) flush(send SendFunc) error {
+	t.Lock()
+	rows := make([]*topRow, 0, len(t.rows))
+	for _, row := range t.rows {
+		rows = append(rows, row)
+	}
+	t.rows = make(map[string]*topRow)
+	t.Unlock()
+	if len(rows) == 0 {
+		return nil
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].ranked != rows[j].ranked {
+			return rows[i].ranked
+		}
+		return rows[i].rank > rows[j].rank
+	})
+	for i, row := range rows {
+		if i == t.n {
+			break
+		}
+		for _, pt := range row.points {
+			if err := send(pt.name, row.tags, pt.fields, pt.ts); err != nil {
+				return err
+			}
+		}
+	}
+	if len(rows) <= t.n {
+		return nil
+	}
+	rest := rows[t.n:]
+	tags := rollupTags(rows)
+	rollups := make(map[string]*topPoint)
+	var names []string
+	for _, row := range rest {
+		for _, pt := range row.points {
+			r, ok := rollups[pt.name]
+			if !ok {
+				r = &topPoint{name: pt.name, fields: map[string]interface{}{}, ts: pt.ts}
+				rollups[pt.name] = r
+				names = append(names, pt.name)
+			}
+			for k, v := range pt.fields {
+				if k != deltaField && (k != "value" || !t.counters[pt.name]) {
+					// gauges and state can't be summed
+					continue
+				}
+				if sum, ok := addValues(r.fields[k], v); ok {
+					r.fields[k] = sum
+				}
+			}
+		}
+	}
+	for _, name := range names {
+		r := rollups[name]
+		if len(r.fields) == 0 {
+			continue
+		}
+		r.fields["rows"] = len(rest)
+		if err := send(name, tags, r.fields, r.ts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollupTags returns the tags shared by the rows, with the tags that differ set to "other"
+func rollupTags(rows []*topRow) map[string]string {
+	tags := make(map[string]string)
+	for _, row := range rows {
+		for k, v := range row.tags {
+			if prior, ok := tags[k]; ok && prior != v {
+				tags[k] = otherRows
+			} else if !ok {
+				tags[k] = v
+			}
+		}
+	}
+	for _, row := range rows {
+		for k := range tags {
+			if _, ok := row.tags[k]; !ok {
+				tags[k] = otherRows
+			}
+		}
+	}
+	return tags
+}
+
+// integerValue converts integer values to int64
+func integerValue(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+// addValues sums numeric values, keeping integers as integers so the rollup
+// has the same field types as the rows
+func addValues(sum, value interface{}) (interface{}, bool) {
+	if sum == nil {
+		if i, ok := integerValue(value); ok {
+			return i, true
+		}
+		f, ok := toFloat(value)
+		return f, ok
+	}
+	if a, ok := sum.(int64); ok {
+		if b, ok := integerValue(value); ok {
+			return a + b, true
+		}
+	}
+	a, ok := toFloat(sum)
+	if !ok {
+		return nil, false
+	}
+	b, ok := toFloat(value)
+	if !ok {
+		return sum, true
+	}
+	return a + b, true
+}
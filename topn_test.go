@@ -0,0 +1,133 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTop(t *testing.T) {
+	tests := []struct {
+		spec   string
+		n      int
+		column string
+		fail   bool
+	}{
+		{spec: ""},
+		{spec: "  "},
+		{spec: "20 ifHCInOctets", n: 20, column: "ifHCInOctets"},
+		{spec: " 5   cpmCPUTotal5minRev ", n: 5, column: "cpmCPUTotal5minRev"},
+		{spec: "20", fail: true},
+		{spec: "ifHCInOctets 20", fail: true},
+		{spec: "0 ifHCInOctets", fail: true},
+		{spec: "-3 ifHCInOctets", fail: true},
+		{spec: "20 ifHCInOctets ifHCOutOctets", fail: true},
+	}
+	for _, tt := range tests {
+		n, column, err := parseTop(tt.spec)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%q: expected an error", tt.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.spec, err)
+		} else if n != tt.n || column != tt.column {
+			t.Errorf("%q: got %d %q, want %d %q", tt.spec, n, column, tt.n, tt.column)
+		}
+	}
+}
+
+// topSent is a point sent by the top rows
+type topSent struct {
+	name   string
+	tags   map[string]string
+	fields map[string]interface{}
+}
+
+func TestTopRowsFlush(t *testing.T) {
+	ts := time.Now()
+	type row struct {
+		ifName string
+		in     map[string]interface{}
+		status interface{}
+	}
+	tests := []struct {
+		name string
+		top  string
+		rows []row
+		want []topSent
+	}{
+		{
+			name: "fewer rows than the top",
+			top:  "2 ifHCInOctets",
+			rows: []row{
+				{"eth0", map[string]interface{}{"value": uint64(10), deltaField: int64(5)}, 1},
+			},
+			want: []topSent{
+				{"ifHCInOctets", map[string]string{"ifName": "eth0"}, map[string]interface{}{"value": uint64(10), deltaField: int64(5)}},
+				{"ifOperStatus", map[string]string{"ifName": "eth0"}, map[string]interface{}{"value": 1}},
+			},
+		},
+		{
+			name: "ranked by delta with a rollup",
+			top:  "1 ifHCInOctets",
+			rows: []row{
+				{"eth0", map[string]interface{}{"value": uint64(1000), deltaField: int64(5)}, 1},
+				{"eth1", map[string]interface{}{"value": uint64(10), deltaField: int64(50)}, 2},
+				{"eth2", map[string]interface{}{"value": uint64(20), deltaField: int64(7)}, 1},
+			},
+			want: []topSent{
+				{"ifHCInOctets", map[string]string{"ifName": "eth1"}, map[string]interface{}{"value": uint64(10), deltaField: int64(50)}},
+				{"ifOperStatus", map[string]string{"ifName": "eth1"}, map[string]interface{}{"value": 2}},
+				{"ifHCInOctets", map[string]string{"ifName": otherRows}, map[string]interface{}{"value": int64(1020), deltaField: int64(12), "rows": 2}},
+			},
+		},
+		{
+			name: "ranked by value without deltas",
+			top:  "1 ifHCInOctets",
+			rows: []row{
+				{"eth0", map[string]interface{}{"value": 3.5}, nil},
+				{"eth1", map[string]interface{}{"value": 1.5}, nil},
+				{"eth2", map[string]interface{}{"value": 9.0}, nil},
+			},
+			want: []topSent{
+				{"ifHCInOctets", map[string]string{"ifName": "eth2"}, map[string]interface{}{"value": 9.0}},
+				{"ifHCInOctets", map[string]string{"ifName": otherRows}, map[string]interface{}{"value": 5.0, "rows": 2}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		rows := newTopRows(tt.top, "ifHCInOctets")
+		for _, r := range tt.rows {
+			tags := map[string]string{"ifName": r.ifName}
+			rows.add("ifHCInOctets", tags, r.in, ts)
+			if r.status != nil {
+				rows.add("ifOperStatus", tags, map[string]interface{}{"value": r.status}, ts)
+			}
+		}
+		var got []topSent
+		send := func(name string, tags map[string]string, fields map[string]interface{}, _ time.Time) error {
+			got = append(got, topSent{name, tags, fields})
+			return nil
+		}
+		if err := rows.flush(send); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+		got = nil
+		if rows.flush(send); len(got) > 0 {
+			t.Errorf("%s: rows sent again after the flush: %+v", tt.name, got)
+		}
+	}
+}
+
+func TestNewTopRows(t *testing.T) {
+	if rows := newTopRows("", "ifHCInOctets"); rows != nil {
+		t.Errorf("expected no top rows without a setting")
+	}
+}
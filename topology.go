@@ -0,0 +1,23 @@
+package main
+
+import (
+	snmp "github.com/paulstuart/snmputil"
+)
+
+const (
+	// lldpOID is the LLDP-MIB remote systems table
+	lldpOID = "lldpRemEntry"
+	// cdpOID is the CISCO-CDP-MIB neighbor cache
+	cdpOID = "cdpCacheEntry"
+)
+
+// topology is the latest set of neighbors of each host
+var topology = newSnapshot("neighbors")
+
+// topologyPoller periodically walks the neighbor tables
+func topologyPoller(send SendFunc, p snmp.Profile, c *SnmpConfig, stop chan struct{}) {
+	if c.CDP {
+		go topology.poll(send, p, c, cdpOID, c.Topology, stop)
+	}
+	topology.poll(send, p, c, lldpOID, c.Topology, stop)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// varbindOverhead approximates the encoding of a varbind without its value:
+// the sequence header and a typical table column OID with its index
+const varbindOverhead = 20
+
+// valueSize estimates the BER encoded size of a varbind with the value
+func valueSize(value interface{}) int64 {
+	size := int64(varbindOverhead)
+	switch v := value.(type) {
+	case string:
+		size += int64(len(v)) + 2
+	case []byte:
+		size += int64(len(v)) + 2
+	case uint64, int64:
+		size += 10
+	default:
+		size += 6
+	}
+	return size
+}
+
+// TrafficSender counts the values received from an agent
+// and their estimated size on the wire
+func TrafficSender(sender snmp.Sender, count func(values, bytes int64)) snmp.Sender {
+	return func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+		count(1, valueSize(value))
+		return sender(name, tags, value, ts)
+	}
+}
+
+// trafficPoint returns the self-metrics of polling an agent's oid
+func trafficPoint(host, mib, oid string, stats snmpStats) (string, map[string]string, map[string]interface{}) {
+	tags := map[string]string{"host": host, "mib": mib, "oid": oid}
+	if len(cfg.Common.Station) > 0 {
+		tags["station"] = cfg.Common.Station
+	}
+	fields := map[string]interface{}{
+		"polls":  stats.GetCnt,
+		"errors": stats.ErrCnt,
+		"values": stats.Values,
+		"bytes":  stats.Bytes,
+	}
+	if stats.TooBig > 0 {
+		fields["too_big"] = stats.TooBig
+	}
+	return "influxsnmp_traffic", tags, fields
+}
+
+// trafficString summarizes the traffic stats for display
+func trafficString(stats snmpStats) string {
+	if stats.GetCnt == 0 {
+		return fmt.Sprintf("%d values, %d bytes", stats.Values, stats.Bytes)
+	}
+	polls := int64(stats.GetCnt)
+	return fmt.Sprintf("%d values, %d bytes (%d values, %d bytes per poll, at most %d in one poll)",
+		stats.Values, stats.Bytes, stats.Values/polls, stats.Bytes/polls, stats.MaxPoll)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// uptimeSlack is how far sysUpTime may drift from the wall clock
+// between polls before it is considered an implausible jump
+const uptimeSlack = 30 * time.Second
+
+// uptimeWrap is when sysUpTime, as TimeTicks in hundredths of a second, wraps to zero
+const uptimeWrap = (1 << 32) * 10 * time.Millisecond
+
+// rebootHook is a function called when a host reboots, and the agent it was added for
+type rebootHook struct {
+	agent string
+	fn    func()
+}
+
+var (
+	rebootLock  sync.Mutex
+	rebootHooks = make(map[string][]rebootHook) // host to its hooks
+)
+
+// onReboot registers a function to call when the host is detected to have rebooted,
+// e.g., to reset state that depends on continuous counters. It is removed when the
+// agent is retired, or with the host if the state is shared by the agents polling it
+func onReboot(host, agent string, fn func()) {
+	rebootLock.Lock()
+	rebootHooks[host] = append(rebootHooks[host], rebootHook{agent, fn})
+	rebootLock.Unlock()
+}
+
+// removeReboots drops the hooks added for the agent
+func removeReboots(agent string) {
+	rebootLock.Lock()
+	for host, list := range rebootHooks {
+		kept := list[:0]
+		for _, h := range list {
+			if h.agent != agent {
+				kept = append(kept, h)
+			}
+		}
+		if len(kept) == 0 {
+			delete(rebootHooks, host)
+		} else {
+			rebootHooks[host] = kept
+		}
+	}
+	rebootLock.Unlock()
+}
+
+// removeHostReboots drops the hooks of the host
+func removeHostReboots(host string) {
+	rebootLock.Lock()
+	delete(rebootHooks, host)
+	rebootLock.Unlock()
+}
+
+// needsUptime returns true if the mibs config keeps state that is reset
+// when the agent reboots, so its sysUpTime is watched even without uptime set
+func needsUptime(m *MibConfig) bool {
+	return len(m.KeyBy) > 0 || len(m.Deltas) > 0 || len(m.Joins) > 0
+}
+
+func rebooted(host string) {
+	rebootLock.Lock()
+	hooks := append([]rebootHook(nil), rebootHooks[host]...)
+	rebootLock.Unlock()
+	for _, h := range hooks {
+		h.fn()
+	}
+}
+
+// uptimeTracker compares successive sysUpTime readings against the wall clock
+type uptimeTracker struct {
+	uptime time.Duration
+	polled time.Time
+}
+
+// check returns the kind of anomaly detected, if any
+func (u *uptimeTracker) check(uptime time.Duration, now time.Time) string {
+	prior, polled := u.uptime, u.polled
+	u.uptime, u.polled = uptime, now
+	if polled.IsZero() {
+		return ""
+	}
+	elapsed := uptime - prior
+	if uptime < prior {
+		// after about 497 days the ticks wrap, which the wall clock agrees with
+		elapsed += uptimeWrap
+		if drift := elapsed - now.Sub(polled); drift > uptimeSlack || drift < -uptimeSlack {
+			return "reboot"
+		}
+	}
+	drift := elapsed - now.Sub(polled)
+	if drift > uptimeSlack || drift < -uptimeSlack {
+		return "jump"
+	}
+	return ""
+}
+
+// uptimeAnchor is a host's last sysUpTime reading, to timestamp points on its clock
+type uptimeAnchor struct {
+	uptime time.Duration
+	at     time.Time // halfway through the request it was read with
+	rate   float64   // of the host's clock to the wall clock, between its last two readings
+}
+
+var uptimeAnchors = struct {
+	sync.Mutex
+	hosts map[string]uptimeAnchor
+}{hosts: make(map[string]uptimeAnchor)}
+
+// anchorUptime records the host's sysUpTime reading. The rate its clock runs at
+// is taken from the previous reading, unless it rebooted or its clock jumped since
+func anchorUptime(host string, uptime time.Duration, at time.Time, kind string) {
+	uptimeAnchors.Lock()
+	defer uptimeAnchors.Unlock()
+	a := uptimeAnchor{uptime, at, 1}
+	prior, ok := uptimeAnchors.hosts[host]
+	if elapsed := at.Sub(prior.at); ok && len(kind) == 0 && elapsed > 0 && uptime > prior.uptime {
+		a.rate = float64(uptime-prior.uptime) / float64(elapsed)
+	}
+	uptimeAnchors.hosts[host] = a
+}
+
+// uptimeTime returns the time on the host's clock: the time of its last sysUpTime
+// reading, advanced by the time since then at the rate its clock runs. It is
+// false if the host's sysUpTime hasn't been read
+func uptimeTime(host string, t time.Time) (time.Time, bool) {
+	uptimeAnchors.Lock()
+	a, ok := uptimeAnchors.hosts[host]
+	uptimeAnchors.Unlock()
+	if !ok {
+		return t, false
+	}
+	return a.at.Add(time.Duration(float64(t.Sub(a.at)) * a.rate)), true
+}
+
+// removeUptime forgets the host's last sysUpTime reading
+func removeUptime(host string) {
+	uptimeAnchors.Lock()
+	delete(uptimeAnchors.hosts, host)
+	uptimeAnchors.Unlock()
+}
+
+// uptimeCheck polls sysUpTime to detect reboots and implausible clock jumps,
+// and to timestamp points on the host's clock
+func uptimeCheck(send SendFunc, p snmp.Profile, c *SnmpConfig, stop chan struct{}) {
+	var u uptimeTracker
+	sender := func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+		ticks, ok := toFloat(value)
+		if !ok {
+			return fmt.Errorf("invalid sysUpTime from %s: %v", p.Host, value)
+		}
+		// sysUpTime is in hundredths of a second
+		uptime := time.Duration(ticks) * time.Second / 100
+		kind := u.check(uptime, ts.Stop)
+		anchorUptime(p.Host, uptime, ts.Start.Add(ts.Stop.Sub(ts.Start)/2), kind)
+		if len(kind) == 0 {
+			return nil
+		}
+		if kind == "reboot" {
+			rebooted(p.Host)
+			annotator(fmt.Sprintf("%s rebooted", p.Host), "reboot", p.Host)
+		}
+		etags := map[string]string{"type": kind, "host": p.Host}
+		fields := map[string]interface{}{"uptime": int64(uptime / time.Second)}
+		return send(eventMeasurement, etags, fields, ts.Stop)
+	}
+	crit := snmp.Criteria{
+		OID:  "sysUpTime",
+		Freq: c.Freq,
+	}
+	pollAgent(p, c, crit, stop, sender, func(error) {})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUptimeCheck(t *testing.T) {
+	tests := []struct {
+		name         string
+		prior, after time.Duration // sysUpTime before and after
+		elapsed      time.Duration // wall clock between polls
+		want         string
+	}{
+		{"steady", time.Hour, time.Hour + time.Minute, time.Minute, ""},
+		{"reboot", time.Hour, 30 * time.Second, time.Minute, "reboot"},
+		{"jump", time.Hour, 2 * time.Hour, time.Minute, "jump"},
+		{"wrap", uptimeWrap - 20*time.Second, 40 * time.Second, time.Minute, ""},
+		{"reboot near the wrap", uptimeWrap - 20*time.Second, 10 * time.Minute, time.Minute, "reboot"},
+		{"reboot after the wrap", uptimeWrap - 20*time.Second, 5 * time.Second, 10 * time.Minute, "reboot"},
+	}
+	start := time.Now()
+	for _, tt := range tests {
+		var u uptimeTracker
+		u.check(tt.prior, start)
+		if got := u.check(tt.after, start.Add(tt.elapsed)); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRebootHooks(t *testing.T) {
+	calls := make(map[string]int)
+	onReboot("hooks.example.com", "first", func() { calls["first"]++ })
+	onReboot("hooks.example.com", "second", func() { calls["second"]++ })
+	onReboot("hooks.example.com", "", func() { calls["shared"]++ })
+	onReboot("other.example.com", "first", func() { calls["other"]++ })
+	rebooted("hooks.example.com")
+	removeReboots("first")
+	rebooted("hooks.example.com")
+	removeHostReboots("hooks.example.com")
+	rebooted("hooks.example.com")
+	rebooted("other.example.com")
+	want := map[string]int{"first": 1, "second": 2, "shared": 2}
+	for k, n := range want {
+		if calls[k] != n {
+			t.Errorf("%s called %d times, want %d", k, calls[k], n)
+		}
+	}
+	if calls["other"] != 0 {
+		t.Errorf("hook of a retired agent called")
+	}
+}
+
+func TestUptimeTime(t *testing.T) {
+	host := "anchor.example.com"
+	defer removeUptime(host)
+	start := time.Now()
+	if _, ok := uptimeTime(host, start); ok {
+		t.Fatalf("timestamp on the clock of a host not yet read")
+	}
+	tests := []struct {
+		name   string
+		uptime time.Duration
+		at     time.Duration // after start
+		kind   string
+		after  time.Duration // since the reading
+		want   time.Duration // since the reading
+	}{
+		{"first reading", time.Hour, 0, "", time.Minute, time.Minute},
+		{"clock runs fast", time.Hour + 2*time.Minute, time.Minute, "", time.Minute, 2 * time.Minute},
+		{"clock runs slow", time.Hour + 2*time.Minute + 30*time.Second, 2 * time.Minute, "", time.Minute, 30 * time.Second},
+		{"reboot", time.Minute, 3 * time.Minute, "reboot", time.Minute, time.Minute},
+	}
+	for _, tt := range tests {
+		at := start.Add(tt.at)
+		anchorUptime(host, tt.uptime, at, tt.kind)
+		got, ok := uptimeTime(host, at.Add(tt.after))
+		if want := at.Add(tt.want); !ok || !got.Equal(want) {
+			t.Errorf("%s: got %s, want %s", tt.name, got.Sub(at), tt.want)
+		}
+	}
+}
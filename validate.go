@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// invalidCount is the number of invalid values encountered
+var invalidCount int64
+
+// checkInvalid verifies the invalid value policy is valid
+func checkInvalid(policy string) error {
+	switch policy {
+	case "", "drop", "null", "zero", "log":
+		return nil
+	}
+	return fmt.Errorf("invalid value policy: %s", policy)
+}
+
+// invalidFloat returns true for values influxdb cannot store
+func invalidFloat(v interface{}) bool {
+	switch f := v.(type) {
+	case float64:
+		return math.IsNaN(f) || math.IsInf(f, 0)
+	case float32:
+		return math.IsNaN(float64(f)) || math.IsInf(float64(f), 0)
+	}
+	return false
+}
+
+// sanitize replaces invalid utf-8 and control characters,
+// and removes trailing NULs and whitespace
+func sanitize(s string) string {
+	clean := true
+	for _, r := range s {
+		if r == utf8.RuneError || unicode.IsControl(r) {
+			clean = false
+			break
+		}
+	}
+	if clean && strings.TrimRight(s, " ") == s {
+		return s
+	}
+	s = strings.TrimRight(s, "\x00 \t\r\n")
+	s = strings.ToValidUTF8(s, "?")
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+// sanitizeTags returns the tags with sanitized values,
+// dropping any left empty
+func sanitizeTags(tags map[string]string) map[string]string {
+	var clean map[string]string
+	for k, v := range tags {
+		s := sanitize(v)
+		if s == v && len(s) > 0 {
+			continue
+		}
+		if clean == nil {
+			clean = make(map[string]string, len(tags))
+			for k, v := range tags {
+				clean[k] = v
+			}
+		}
+		if len(s) == 0 {
+			delete(clean, k)
+		} else {
+			clean[k] = s
+		}
+	}
+	if clean == nil {
+		return tags
+	}
+	return clean
+}
+
+// ValidSender sanitizes strings and applies the invalid value policy to NaN and Inf fields:
+// "drop" the point (default), "null" omits the field, "zero" replaces it with 0,
+// "log" drops the point and logs it
+func ValidSender(send SendFunc) SendFunc {
+	policy := cfg.Common.Invalid
+	return func(name string, tags map[string]string, fields map[string]interface{}, ts time.Time) error {
+		tags = sanitizeTags(tags)
+		var fixed map[string]interface{}
+		copyFields := func() {
+			if fixed == nil {
+				fixed = make(map[string]interface{}, len(fields))
+				for k, v := range fields {
+					fixed[k] = v
+				}
+			}
+		}
+		for k, v := range fields {
+			switch x := v.(type) {
+			case string:
+				if s := sanitize(x); s != x {
+					copyFields()
+					fixed[k] = s
+				}
+				continue
+			case []byte:
+				copyFields()
+				fixed[k] = sanitize(string(x))
+				continue
+			}
+			if !invalidFloat(v) {
+				continue
+			}
+			atomic.AddInt64(&invalidCount, 1)
+			if policy == "log" || debugging() {
+				log.Printf("invalid value for %s %s (%s): %v\n", name, k, tagString(tags), v)
+			}
+			switch policy {
+			case "null", "zero":
+			default:
+				return nil
+			}
+			copyFields()
+			if policy == "zero" {
+				fixed[k] = 0.0
+			} else {
+				delete(fixed, k)
+			}
+		}
+		if fixed != nil {
+			if len(fixed) == 0 {
+				return nil
+			}
+			fields = fixed
+		}
+		return send(name, tags, fields, ts)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestValidSender(t *testing.T) {
+	defer func(policy string) { cfg.Common.Invalid = policy }(cfg.Common.Invalid)
+	tests := []struct {
+		policy string
+		fields map[string]interface{} // sent, nil if the point is dropped
+	}{
+		{"", nil},
+		{"drop", nil},
+		{"log", nil},
+		{"null", map[string]interface{}{"ok": 1.0}},
+		{"zero", map[string]interface{}{"ok": 1.0, "bad": 0.0}},
+	}
+	for _, tt := range tests {
+		if err := checkInvalid(tt.policy); err != nil {
+			t.Errorf("%q: %s", tt.policy, err)
+		}
+		cfg.Common.Invalid = tt.policy
+		var sent map[string]interface{}
+		send := ValidSender(func(name string, tags map[string]string, fields map[string]interface{}, ts time.Time) error {
+			sent = fields
+			return nil
+		})
+		fields := map[string]interface{}{"ok": 1.0, "bad": math.NaN()}
+		if err := send("valid", map[string]string{"host": "valid.example.com"}, fields, time.Now()); err != nil {
+			t.Errorf("%q: %s", tt.policy, err)
+		}
+		if len(sent) != len(tt.fields) {
+			t.Errorf("%q: sent %v, want %v", tt.policy, sent, tt.fields)
+			continue
+		}
+		for k, v := range tt.fields {
+			if sent[k] != v {
+				t.Errorf("%q: sent %v, want %v", tt.policy, sent, tt.fields)
+			}
+		}
+	}
+	if err := checkInvalid("ignore"); err == nil {
+		t.Errorf("unknown policy accepted")
+	}
+}
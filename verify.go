@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	client "github.com/influxdata/influxdb/client/v2"
+)
+
+const (
+	// verifyDelay is how long after a write its points are read back
+	verifyDelay = 2 * time.Second
+	// verifySample is how many points of a batch are read back
+	verifySample = 5
+	// verifyMeasurement records the results of reading back written points
+	verifyMeasurement = "influxsnmp_verify"
+)
+
+// precisions are the durations of the batch precisions
+var precisions = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+// sameField compares a written field with the value read back
+func sameField(written, read interface{}) bool {
+	a, b := fmt.Sprint(written), fmt.Sprint(read)
+	if a == b {
+		return true
+	}
+	x, err1 := strconv.ParseFloat(a, 64)
+	y, err2 := strconv.ParseFloat(b, 64)
+	return err1 == nil && err2 == nil && math.Abs(x-y) <= 1e-9*math.Max(math.Abs(x), 1)
+}
+
+// pointQuery returns the query that reads back the point
+func pointQuery(pt *client.Point, precision string) string {
+	ts := pt.Time()
+	if d, ok := precisions[precision]; ok {
+		ts = ts.Truncate(d)
+	}
+	tags := pt.Tags()
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	where := make([]string, 0, len(keys)+1)
+	for _, k := range keys {
+		where = append(where, fmt.Sprintf(`"%s" = '%s'`, identifier.Replace(k), literal.Replace(tags[k])))
+	}
+	where = append(where, fmt.Sprintf("time = %d", ts.UnixNano()))
+	return fmt.Sprintf(`SELECT * FROM "%s" WHERE %s`, identifier.Replace(pt.Name()), strings.Join(where, " AND "))
+}
+
+// verifyPoint reads back the point, returning false if it is missing
+// and the fields whose values differ
+func (s *influxSender) verifyPoint(bp client.BatchPoints, pt *client.Point) (bool, []string, error) {
+	q := client.NewQueryWithRP(pointQuery(pt, bp.Precision()), bp.Database(), bp.RetentionPolicy(), "")
+	resp, err := s.conn.Query(q)
+	if err == nil {
+		err = resp.Error()
+	}
+	if err != nil {
+		return false, nil, err
+	}
+	fields, err := pt.Fields()
+	if err != nil {
+		return false, nil, err
+	}
+	for _, r := range resp.Results {
+		for _, series := range r.Series {
+			if len(series.Values) == 0 {
+				continue
+			}
+			row := series.Values[0]
+			var differ []string
+			for name, written := range fields {
+				found := false
+				for i, col := range series.Columns {
+					if col == name && i < len(row) {
+						found = sameField(written, row[i])
+					}
+				}
+				if !found {
+					differ = append(differ, name)
+				}
+			}
+			return true, differ, nil
+		}
+	}
+	return false, nil, nil
+}
+
+// readBack reads back a sample of the batch's points after it was written,
+// recording any that are missing or have different values
+func (s *influxSender) readBack(bp client.BatchPoints) {
+	time.Sleep(verifyDelay)
+	pts := bp.Points()
+	sample := make([]*client.Point, 0, verifySample)
+	for _, i := range rand.Perm(len(pts)) {
+		if len(sample) == verifySample {
+			break
+		}
+		if pts[i].Name() != verifyMeasurement {
+			sample = append(sample, pts[i])
+		}
+	}
+	checked, missing, mismatched := 0, 0, 0
+	for _, pt := range sample {
+		found, differ, err := s.verifyPoint(bp, pt)
+		if err != nil {
+			log.Printf("verify error for %s: %s\n", bp.Database(), err)
+			continue
+		}
+		checked++
+		switch {
+		case !found:
+			missing++
+			log.Printf("verify: point missing from %s: %s\n", bp.Database(), pt.String())
+		case len(differ) > 0:
+			mismatched++
+			log.Printf("verify: fields %v differ in %s: %s\n", differ, bp.Database(), pt.String())
+		}
+	}
+	if checked == 0 {
+		return
+	}
+	s.Lock()
+	s.stats.Verified += int64(checked - missing - mismatched)
+	s.stats.Unverified += int64(missing + mismatched)
+	s.Unlock()
+	if cfg.Common.SelfMetrics {
+		tags := map[string]string{"database": bp.Database()}
+		if rp := bp.RetentionPolicy(); len(rp) > 0 {
+			tags["retention"] = rp
+		}
+		fields := map[string]interface{}{"checked": checked, "missing": missing, "mismatched": mismatched}
+		if err := s.Send("", verifyMeasurement, tags, fields, time.Now()); err != nil {
+			log.Printf("verify error for %s: %s\n", bp.Database(), err)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+// tableWalk is a table to poll on an agent and where its results go
+type tableWalk struct {
+	send    SendFunc
+	profile snmp.Profile
+	crit    snmp.Criteria
+	info    snmpInfo
+	dest    string        // identifies where the data is saved
+	stop    chan struct{} // closed when its agent is stopped
+}
+
+// walkKey identifies walks that can share a single walk, which differ
+// only in how their results are processed: walks of the same OID, or of
+// the same table and its columns, which are walked together by its entry
+func walkKey(p snmp.Profile, c snmp.Criteria) string {
+	oid := c.OID
+	if entry, _ := tableOf(oid); len(entry) > 0 && len(c.Index) == 0 {
+		oid = entry
+	}
+	return fmt.Sprintf("%+v|%s|%s|%d|%d|%s|%t|%s|%s|%s",
+		p, oid, c.Index, c.Freq, c.Count,
+		strings.Join(c.Regexps, " "), c.Keep,
+		seriesKey("", c.Tags), seriesKey("", c.Aliases), seriesKey("", c.Rename),
+	)
+}
+
+// tableOf returns the entry of the table the oid is, or is a column of, and
+// whether it is the table or entry itself. Tables are named ...Table and their
+// rows ...Entry, as the SMI requires
+func tableOf(oid string) (string, bool) {
+	if !numeric(oid) {
+		o, ok := mibOIDs[oid]
+		if !ok {
+			return "", false
+		}
+		oid = o
+	}
+	oid = strings.Trim(oid, ".")
+	switch name := oidNames[oid]; {
+	case strings.HasSuffix(name, "Entry"):
+		return name, true
+	case strings.HasSuffix(name, "Table"):
+		if entry := oidNames[oid+".1"]; strings.HasSuffix(entry, "Entry") {
+			return entry, true
+		}
+		return "", false
+	}
+	if i := strings.LastIndex(oid, "."); i > 0 {
+		if entry := oidNames[oid[:i]]; strings.HasSuffix(entry, "Entry") {
+			return entry, false
+		}
+	}
+	return "", false
+}
+
+// walkCrit returns the criteria the walks sharing a poller are walked by,
+// the entry of their table if they don't all walk the same OID
+func walkCrit(walks []tableWalk) snmp.Criteria {
+	crit := walks[0].crit
+	for _, w := range walks[1:] {
+		if w.crit.OID != crit.OID {
+			crit.OID, _ = tableOf(crit.OID)
+			break
+		}
+	}
+	return crit
+}
+
+// ownColumns passes on only the values of the walk's column, when its
+// table is walked as a whole for the others sharing the walk
+func ownColumns(w tableWalk, walked string, sender snmp.Sender) snmp.Sender {
+	if w.crit.OID == walked {
+		return sender
+	}
+	if _, whole := tableOf(w.crit.OID); whole {
+		return sender
+	}
+	names := map[string]bool{w.crit.OID: true}
+	if renamed, ok := w.crit.Rename[w.crit.OID]; ok {
+		names[renamed] = true
+	}
+	return func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+		if !names[name] {
+			return nil
+		}
+		return sender(name, tags, value, ts)
+	}
+}
+
+// dupKey identifies walks that would save the same data twice,
+// whatever credentials they are polled with
+func dupKey(w tableWalk) string {
+	c := w.crit
+	return fmt.Sprintf("%s:%d|%s|%s|%d|%s|%t|%s|%s|%s|%s",
+		w.profile.Host, w.profile.Port, c.OID, c.Index, c.Freq,
+		strings.Join(c.Regexps, " "), c.Keep,
+		seriesKey("", c.Tags), seriesKey("", c.Aliases), seriesKey("", c.Rename), w.dest,
+	)
+}
+
+// polledWalks are the walks being polled, by dupKey, so agents started later,
+// such as by discovery, don't poll what another already saves. The duplicates
+// are held back, to be polled in turn if the agent polling the walk is retired
+var polledWalks = struct {
+	sync.Mutex
+	walks map[string]tableWalk
+	held  map[string][]tableWalk
+}{
+	walks: make(map[string]tableWalk),
+	held:  make(map[string][]tableWalk),
+}
+
+// claimWalk returns true if the walk isn't already polled, holding it back if it is
+func claimWalk(w tableWalk) bool {
+	dup := dupKey(w)
+	polledWalks.Lock()
+	defer polledWalks.Unlock()
+	prior, ok := polledWalks.walks[dup]
+	if !ok {
+		polledWalks.walks[dup] = w
+		return true
+	}
+	polledWalks.held[dup] = append(polledWalks.held[dup], w)
+	log.Printf("warning: %s on %s every %ds is polled by both %s and %s, ignoring %s\n",
+		w.crit.OID, w.profile.Host, w.crit.Freq, prior.info.Name, w.info.Name, w.info.Name)
+	return false
+}
+
+// releaseWalks forgets the walks of the agent, returning the duplicates
+// that were held back in their favor, which are now to be polled instead
+func releaseWalks(agent string) []tableWalk {
+	polledWalks.Lock()
+	defer polledWalks.Unlock()
+	for dup, list := range polledWalks.held {
+		kept := list[:0]
+		for _, w := range list {
+			if w.info.Name != agent {
+				kept = append(kept, w)
+			}
+		}
+		if len(kept) == 0 {
+			delete(polledWalks.held, dup)
+		} else {
+			polledWalks.held[dup] = kept
+		}
+	}
+	var next []tableWalk
+	for dup, w := range polledWalks.walks {
+		if w.info.Name != agent {
+			continue
+		}
+		delete(polledWalks.walks, dup)
+		if list, ok := polledWalks.held[dup]; ok {
+			polledWalks.walks[dup] = list[0]
+			next = append(next, list[0])
+			if len(list) == 1 {
+				delete(polledWalks.held, dup)
+			} else {
+				polledWalks.held[dup] = list[1:]
+			}
+		}
+	}
+	return next
+}
+
+// walkCache groups the walks that can share a single poller
+type walkCache struct {
+	keys  []string
+	walks map[string][]tableWalk
+}
+
+func newWalkCache() *walkCache {
+	return &walkCache{
+		walks: make(map[string][]tableWalk),
+	}
+}
+
+// add includes the walk unless it duplicates one already polled
+func (c *walkCache) add(w tableWalk) {
+	if !claimWalk(w) {
+		return
+	}
+	key := walkKey(w.profile, w.crit)
+	if _, ok := c.walks[key]; !ok {
+		c.keys = append(c.keys, key)
+	}
+	c.walks[key] = append(c.walks[key], w)
+}
+
+// list returns the groups of walks, one per poller
+func (c *walkCache) list() [][]tableWalk {
+	list := make([][]tableWalk, 0, len(c.keys))
+	for _, key := range c.keys {
+		walks := c.walks[key]
+		if len(walks) > 1 && debugging() {
+			names := make([]string, 0, len(walks))
+			for _, w := range walks {
+				names = append(names, w.info.Name)
+			}
+			sort.Strings(names)
+			log.Printf("sharing walk of %s on %s for %s\n", walkCrit(walks).OID, walks[0].profile.Host, strings.Join(names, ", "))
+		}
+		list = append(list, walks)
+	}
+	return list
+}
+
+// fanout passes the results of a walk to each of the senders, leaving out
+// those of agents that were stopped while others still share the walk
+func fanout(senders []snmp.Sender, errFns []snmp.ErrFunc, stops []chan struct{}) (snmp.Sender, snmp.ErrFunc) {
+	sender := func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+		var err error
+		for i, s := range senders {
+			if stopped(stops[i]) {
+				continue
+			}
+			// each sender may modify the tags
+			t := make(map[string]string, len(tags))
+			for k, v := range tags {
+				t[k] = v
+			}
+			if serr := s(name, t, value, ts); err == nil {
+				err = serr
+			}
+		}
+		return err
+	}
+	errFn := func(err error) {
+		for i, fn := range errFns {
+			if !stopped(stops[i]) {
+				fn(err)
+			}
+		}
+	}
+	return sender, errFn
+}
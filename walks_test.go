@@ -0,0 +1,160 @@
+package main
+
+import (
+	"testing"
+
+	snmp "github.com/paulstuart/snmputil"
+)
+
+func TestDupKey(t *testing.T) {
+	base := tableWalk{
+		profile: snmp.Profile{Host: "router1.example.com", Port: 161, Community: "public"},
+		crit:    snmp.Criteria{OID: "ifXTable", Freq: 60, Tags: map[string]string{"site": "sfo"}},
+		dest:    "snmp",
+	}
+	tests := []struct {
+		name string
+		edit func(w *tableWalk)
+		same bool
+	}{
+		{"identical", func(w *tableWalk) {}, true},
+		{"community", func(w *tableWalk) { w.profile.Community = "private" }, true},
+		{"timeout", func(w *tableWalk) { w.profile.Timeout = 30 }, true},
+		{"host", func(w *tableWalk) { w.profile.Host = "router2.example.com" }, false},
+		{"port", func(w *tableWalk) { w.profile.Port = 1161 }, false},
+		{"oid", func(w *tableWalk) { w.crit.OID = "ifTable" }, false},
+		{"index", func(w *tableWalk) { w.crit.Index = "1" }, false},
+		{"freq", func(w *tableWalk) { w.crit.Freq = 300 }, false},
+		{"regexps", func(w *tableWalk) { w.crit.Regexps = []string{"^Gi"} }, false},
+		{"keep", func(w *tableWalk) { w.crit.Keep = true }, false},
+		{"tags", func(w *tableWalk) { w.crit.Tags = map[string]string{"site": "lax"} }, false},
+		{"aliases", func(w *tableWalk) { w.crit.Aliases = map[string]string{"ifName": "port"} }, false},
+		{"rename", func(w *tableWalk) { w.crit.Rename = map[string]string{"ifHCInOctets": "in"} }, false},
+		{"dest", func(w *tableWalk) { w.dest = "archive" }, false},
+	}
+	want := dupKey(base)
+	for _, tt := range tests {
+		w := base
+		tt.edit(&w)
+		if got := dupKey(w); (got == want) != tt.same {
+			t.Errorf("%s: same key is %t, want %t", tt.name, got == want, tt.same)
+		}
+	}
+}
+
+func TestFanout(t *testing.T) {
+	var sent, done [2]int
+	var senders []snmp.Sender
+	var errFns []snmp.ErrFunc
+	for i := range sent {
+		i := i
+		senders = append(senders, func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+			sent[i]++
+			return nil
+		})
+		errFns = append(errFns, func(error) { done[i]++ })
+	}
+	stops := []chan struct{}{make(chan struct{}), make(chan struct{})}
+	sender, errFn := fanout(senders, errFns, stops)
+	sender("ifHCInOctets", map[string]string{"index": "1"}, uint64(1), snmp.TimeStamp{})
+	errFn(nil)
+	close(stops[0])
+	sender("ifHCInOctets", map[string]string{"index": "1"}, uint64(2), snmp.TimeStamp{})
+	errFn(nil)
+	if sent != [2]int{1, 2} || done != [2]int{1, 2} {
+		t.Errorf("got sent %v and done %v, want the stopped agent left out", sent, done)
+	}
+}
+
+func TestHeldWalks(t *testing.T) {
+	walk := func(agent string) tableWalk {
+		return tableWalk{
+			profile: snmp.Profile{Host: "held.example.com", Port: 161},
+			crit:    snmp.Criteria{OID: "ifXTable", Freq: 60},
+			info:    snmpInfo{Name: agent},
+			dest:    "snmp",
+		}
+	}
+	if !claimWalk(walk("static")) {
+		t.Fatal("expected the first walk to be polled")
+	}
+	if claimWalk(walk("discovered")) || claimWalk(walk("other")) {
+		t.Fatal("expected the duplicates to be held back")
+	}
+	releaseWalks("other")
+	next := releaseWalks("static")
+	if len(next) != 1 || next[0].info.Name != "discovered" {
+		t.Fatalf("got %+v, want the held walk of discovered", next)
+	}
+	if claimWalk(walk("static")) {
+		t.Error("expected the walk to be held back while discovered polls it")
+	}
+	if next := releaseWalks("discovered"); len(next) != 1 || next[0].info.Name != "static" {
+		t.Errorf("got %+v, want the held walk of static", next)
+	}
+	releaseWalks("static")
+	if len(polledWalks.walks) != 0 || len(polledWalks.held) != 0 {
+		t.Errorf("walks left after all agents are retired: %+v %+v", polledWalks.walks, polledWalks.held)
+	}
+}
+
+func TestTableWalks(t *testing.T) {
+	setMibOIDs(map[string]string{
+		"ifXTable":      ".1.3.6.1.2.1.31.1.1",
+		"ifXEntry":      ".1.3.6.1.2.1.31.1.1.1",
+		"ifName":        ".1.3.6.1.2.1.31.1.1.1.1",
+		"ifHCInOctets":  ".1.3.6.1.2.1.31.1.1.1.6",
+		"ifHCOutOctets": ".1.3.6.1.2.1.31.1.1.1.10",
+		"sysUpTime":     ".1.3.6.1.2.1.1.3",
+	})
+	p := snmp.Profile{Host: "tables.example.com", Port: 161}
+	walk := func(agent, oid string) tableWalk {
+		return tableWalk{
+			profile: p,
+			crit:    snmp.Criteria{OID: oid, Freq: 60, Rename: map[string]string{"ifHCInOctets": "in"}},
+			info:    snmpInfo{Name: agent},
+			dest:    "snmp",
+		}
+	}
+	cache := newWalkCache()
+	cache.add(walk("errors", "ifHCInOctets"))
+	cache.add(walk("traffic", "ifXTable"))
+	cache.add(walk("names", "ifName"))
+	cache.add(walk("uptime", "sysUpTime"))
+	list := cache.list()
+	if len(list) != 2 || len(list[0]) != 3 || len(list[1]) != 1 {
+		t.Fatalf("got %d groups, want the columns and their table in one", len(list))
+	}
+	crit := walkCrit(list[0])
+	if crit.OID != "ifXEntry" {
+		t.Errorf("walking %s, want ifXEntry", crit.OID)
+	}
+	if crit := walkCrit(list[1]); crit.OID != "sysUpTime" {
+		t.Errorf("walking %s, want sysUpTime", crit.OID)
+	}
+	for _, w := range list[0] {
+		releaseWalks(w.info.Name)
+	}
+	releaseWalks("uptime")
+
+	sent := make(map[string][]string)
+	for _, w := range list[0] {
+		w := w
+		sender := ownColumns(w, crit.OID, func(name string, tags map[string]string, value interface{}, ts snmp.TimeStamp) error {
+			sent[w.info.Name] = append(sent[w.info.Name], name)
+			return nil
+		})
+		for _, name := range []string{"in", "ifHCOutOctets", "ifName"} {
+			sender(name, nil, uint64(1), snmp.TimeStamp{})
+		}
+	}
+	want := map[string]int{"errors": 1, "traffic": 3, "names": 1}
+	for agent, n := range want {
+		if len(sent[agent]) != n {
+			t.Errorf("%s was sent %v, want %d columns", agent, sent[agent], n)
+		}
+	}
+	if got := sent["errors"]; len(got) != 1 || got[0] != "in" {
+		t.Errorf("errors was sent %v, want its renamed column", got)
+	}
+}
@@ -25,21 +25,34 @@ func myIps() (ips []string) {
 	return
 }
 
-func faviconPage(w http.ResponseWriter, r *http.Request) {
-	fav := "favicon.ico"
-	http.ServeFile(w, r, fav)
-}
-
 func homePage(w http.ResponseWriter, r *http.Request) {
 	const layout = "Jan 2, 2006 at 3:04pm (MST)"
 
-	if err := tmpl.Execute(w, status()); err != nil {
+	page := statusPage{SystemStatus: status(), Query: parseStatusQuery(r)}
+	page.Agents, page.Pages = page.Query.apply(page.SnmpStats)
+	for i := range page.Agents {
+		page.Agents[i].Links = agentLinks(page.Agents[i].Name)
+	}
+	if err := tmpl.Execute(w, page); err != nil {
 		log.Printf("home error:%s\n", err)
 	}
 }
 
 var webHandlers = []hFunc{
 	{"/favicon.ico", faviconPage},
+	{"/api/inventory", inventory.ServeHTTP},
+	{"/api/topology", topology.ServeHTTP},
+	{"/metrics", metrics.ServeHTTP},
+	{"/api/last/", latest.ServeHTTP},
+	{"/problems", problemsPage},
+	{"/calendar", calendarPage},
+	{"/api/status", statusAPI},
+	{"/api/coverage", coverageAPI},
+	{"/api/dump", dumpAPI},
+	{"/api/config", configAPI},
+	{"/api/query", queryAPI},
+	{"/api/credentials/", credentialAPI},
+	{"/api/set", setAPI},
 	{"/", homePage},
 }
 